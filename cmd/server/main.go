@@ -12,10 +12,12 @@ import (
 	"time"
 
 	"github.com/honeycombio/otel-config-go/otelconfig"
+	"github.com/jack5341/otel-map-server/internal/alerting"
 	"github.com/jack5341/otel-map-server/internal/config"
 	"github.com/jack5341/otel-map-server/internal/db"
 	errorz "github.com/jack5341/otel-map-server/internal/errors"
 	httpserver "github.com/jack5341/otel-map-server/internal/http"
+	"github.com/jack5341/otel-map-server/internal/rollup"
 	"github.com/labstack/echo/v4"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
@@ -33,6 +35,10 @@ func main() {
 
 	log.Println("config loaded")
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(cfg, os.Args[2:]))
+	}
+
 	otelShutdown, err := otelconfig.ConfigureOpenTelemetry(otelconfig.WithServiceName(cfg.ServiceName))
 	if err != nil {
 		exp, expErr := stdouttrace.New(stdouttrace.WithPrettyPrint())
@@ -58,6 +64,16 @@ func main() {
 
 	log.Println("database initialized")
 
+	if cfg.MigrateOnStart {
+		migrateCtx, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+		applied, err := db.Migrate(migrateCtx, database)
+		cancel()
+		if err != nil {
+			panic(errors.Join(errorz.ErrDatabaseError, err))
+		}
+		log.Printf("schema migrated, %d migrations applied", len(applied))
+	}
+
 	e := echo.New()
 	otelTracer := otel.Tracer(cfg.ServiceName)
 	httpserver.Register(e, database, otelTracer, &cfg)
@@ -78,6 +94,17 @@ func main() {
 	shutdownCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	if cfg.AlertInterval > 0 {
+		go alerting.NewEngine(database, otelTracer, &cfg).Run(shutdownCtx)
+		log.Println("alerting engine started")
+	} else {
+		log.Println("alerting disabled")
+	}
+
+	go rollup.NewRoller(database, otelTracer, &cfg).Run(shutdownCtx)
+
+	log.Println("edge rollup started")
+
 	select {
 	case <-shutdownCtx.Done():
 		// graceful shutdown
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jack5341/otel-map-server/internal/config"
+	"github.com/jack5341/otel-map-server/internal/db"
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+)
+
+// migrateTimeout bounds a migration run; creating views and indexes on a
+// large spans table takes a while.
+const migrateTimeout = 10 * time.Minute
+
+// runMigrate implements the migrate subcommand: "migrate" applies the
+// pending migrations and "migrate status" lists all of them. It returns the
+// process exit code.
+func runMigrate(cfg config.Config, args []string) int {
+	database, err := db.Open(cfg.ClickHouseDSN)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, errors.Join(errorz.ErrDatabaseError, err))
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+	defer cancel()
+
+	switch {
+	case len(args) == 0:
+		applied, err := db.Migrate(ctx, database)
+		for _, m := range applied {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+	case len(args) == 1 && args[0] == "status":
+		statuses, err := db.MigrationStatuses(ctx, database)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT\tCHECKSUM")
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if s.AppliedAt != nil {
+				appliedAt = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\t%s\n", s.Version, s.Name, appliedAt, s.Checksum[:12])
+		}
+		w.Flush()
+	default:
+		fmt.Fprintln(os.Stderr, "usage: server migrate [status]")
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,438 @@
+package alerting
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jack5341/otel-map-server/internal/config"
+	mapz "github.com/jack5341/otel-map-server/internal/mapz"
+	"github.com/jack5341/otel-map-server/internal/models"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// seedInstance is the state row that records that a new_dependency rule has
+// taken stock of the edges existing when it was created.
+const seedInstance = ""
+
+const (
+	// sessionTimeout bounds the evaluation of one session, so a slow
+	// session does not hold up the others.
+	sessionTimeout = 10 * time.Second
+	// notifyWorkers deliver queued notifications concurrently; notifyQueue
+	// bounds how many rules can wait for a worker.
+	notifyWorkers = 4
+	notifyQueue   = 256
+)
+
+// Engine periodically evaluates the alert rules of every session over the
+// most recent window of spans and notifies the rules' receivers when alerts
+// fire or resolve.
+//
+// Webhook and Slack receivers are notified once per transition and again
+// every repeat interval while an alert keeps firing. Alertmanager receivers
+// get every firing alert on each evaluation, as Alertmanager expects, and
+// deduplicate by labels themselves.
+//
+// Notifications are delivered by a pool of workers, outside the evaluation
+// loop, so an unreachable receiver delays neither other rules nor other
+// sessions. A rule has at most one delivery in flight; its outcome is
+// recorded in the alert states on the rule's next evaluation, and a delivery
+// that fails after retries is attempted again then.
+type Engine struct {
+	db         *gorm.DB
+	otelTracer trace.Tracer
+	notifier   *Notifier
+	interval   time.Duration
+	window     time.Duration
+	repeat     time.Duration
+	deliveries chan delivery
+
+	mu        sync.Mutex
+	inFlight  map[uuid.UUID]bool
+	delivered map[uuid.UUID][]models.AlertState
+}
+
+// delivery is the notifications due for one rule, and the states they
+// report.
+type delivery struct {
+	sessionToken uuid.UUID
+	rule         Rule
+	sends        []send
+	due          []models.AlertState
+	at           time.Time
+}
+
+type send struct {
+	receiver Receiver
+	alerts   []Alert
+}
+
+func NewEngine(db *gorm.DB, otelTracer trace.Tracer, cfg *config.Config) *Engine {
+	return &Engine{
+		db:         db,
+		otelTracer: otelTracer,
+		notifier:   NewNotifier(),
+		interval:   cfg.AlertInterval,
+		window:     cfg.AlertWindow,
+		repeat:     cfg.AlertRepeatInterval,
+		deliveries: make(chan delivery, notifyQueue),
+		inFlight:   map[uuid.UUID]bool{},
+		delivered:  map[uuid.UUID][]models.AlertState{},
+	}
+}
+
+// Run evaluates all sessions every interval until ctx is done.
+func (e *Engine) Run(ctx context.Context) {
+	for range notifyWorkers {
+		go e.deliver(ctx)
+	}
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			e.evaluateAll(ctx)
+		}
+	}
+}
+
+func (e *Engine) evaluateAll(ctx context.Context) {
+	listCtx, cancel := context.WithTimeout(ctx, sessionTimeout)
+	sessions, err := NewStore(e.db, e.otelTracer, listCtx).Sessions()
+	cancel()
+	if err != nil {
+		log.Printf("alerting: %v", err)
+		return
+	}
+	for _, token := range sessions {
+		sessionCtx, cancel := context.WithTimeout(ctx, sessionTimeout)
+		if err := e.EvaluateSession(sessionCtx, token); err != nil {
+			log.Printf("alerting: session %s: %v", token, err)
+		}
+		cancel()
+	}
+}
+
+// EvaluateSession evaluates the rules of one session, queues due
+// notifications and stores the resulting alert states.
+func (e *Engine) EvaluateSession(ctx context.Context, sessionToken uuid.UUID) (err error) {
+	ctx, span := e.otelTracer.Start(ctx, "Engine.EvaluateSession")
+	defer span.End()
+
+	store := NewStore(e.db, e.otelTracer, ctx)
+	rules, err := store.Rules(sessionToken)
+	if err != nil || len(rules) == 0 {
+		return err
+	}
+	previous, err := store.States(sessionToken)
+	if err != nil {
+		return err
+	}
+	byRule := map[uuid.UUID]map[string]models.AlertState{}
+	for _, s := range previous {
+		if byRule[s.RuleID] == nil {
+			byRule[s.RuleID] = map[string]models.AlertState{}
+		}
+		byRule[s.RuleID][s.Instance] = s
+	}
+	// Record the deliveries made since the last evaluation, or keep them for
+	// the next one if this one fails.
+	delivered := e.takeDelivered(rules)
+	defer func() {
+		if err != nil {
+			e.restoreDelivered(delivered)
+		}
+	}()
+	for _, rule := range rules {
+		for _, s := range delivered[rule.ID] {
+			if prev, ok := byRule[rule.ID][s.Instance]; ok {
+				prev.NotifiedState, prev.NotifiedAt = s.NotifiedState, s.NotifiedAt
+				byRule[rule.ID][s.Instance] = prev
+			}
+		}
+	}
+
+	now := time.Now().UTC()
+	mapper := mapz.NewMapper(e.db, e.otelTracer, ctx).WithOptions(mapz.Options{
+		Since:     now.Add(-e.window),
+		Quantiles: Quantiles,
+	})
+	token := sessionToken.String()
+	var services []mapz.Service
+	var edges []mapz.Edge
+	for _, rule := range rules {
+		if rule.Scope == ScopeService && services == nil {
+			if services, err = mapper.GetServicesWithMetrics(token); err != nil {
+				return err
+			}
+		}
+		if rule.Scope == ScopeEdge && edges == nil {
+			if edges, err = mapper.GetEdges(token); err != nil {
+				return err
+			}
+		}
+	}
+
+	var changed []models.AlertState
+	for _, rule := range rules {
+		states := e.evaluateRule(rule, byRule[rule.ID], observe(rule.Scope, services, edges, e.window), now)
+		for i := range states {
+			states[i].Token = sessionToken
+		}
+		changed = append(changed, e.notify(sessionToken, rule, states, now)...)
+	}
+
+	return store.SaveStates(changed)
+}
+
+// evaluateRule returns the next state of every instance of the rule that is
+// observed now or has a stored state.
+func (e *Engine) evaluateRule(rule Rule, previous map[string]models.AlertState, observations map[string]observation, now time.Time) []models.AlertState {
+	instances := map[string]bool{}
+	for instance := range observations {
+		if rule.matches(instance) {
+			instances[instance] = true
+		}
+	}
+	for instance := range previous {
+		if instance != seedInstance {
+			instances[instance] = true
+		}
+	}
+
+	var states []models.AlertState
+	if rule.Kind == KindNewDependency {
+		_, seeded := previous[seedInstance]
+		if !seeded {
+			states = append(states, models.AlertState{RuleID: rule.ID, Instance: seedInstance, State: StateInactive, StartedAt: now})
+		}
+		for instance := range instances {
+			prev, known := previous[instance]
+			_, observed := observations[instance]
+			next := e.stepNewDependency(rule, prev, known, observed && seeded, now)
+			if !known || !quiet(prev, next) {
+				next.RuleID, next.Instance = rule.ID, instance
+				states = append(states, next)
+			}
+		}
+		return states
+	}
+
+	for instance := range instances {
+		prev := previous[instance]
+		o := observations[instance]
+		active, value := false, 0.0
+		if rule.matches(instance) {
+			active, value = condition(rule, o, prev.Baseline, e.window)
+		}
+		next := step(rule, prev, active, value, now)
+		if rule.Kind == KindRPSDrop && next.State != StatePending && next.State != StateFiring {
+			next.Baseline = o.rps
+			if prev.Baseline > 0 {
+				next.Baseline = baselineWeight*o.rps + (1-baselineWeight)*prev.Baseline
+			}
+		}
+		if !quiet(prev, next) {
+			next.RuleID, next.Instance = rule.ID, instance
+			states = append(states, next)
+		}
+	}
+	return states
+}
+
+// quiet reports whether an instance stays inactive with nothing to record.
+func quiet(prev, next models.AlertState) bool {
+	wasInactive := prev.State == "" || prev.State == StateInactive
+	return wasInactive && next.State == StateInactive && prev.Baseline == next.Baseline
+}
+
+// step moves an instance through inactive, pending, firing and resolved.
+func step(rule Rule, prev models.AlertState, active bool, value float64, now time.Time) models.AlertState {
+	next := prev
+	next.Value = value
+	switch {
+	case active && (prev.State == StatePending || prev.State == StateFiring):
+		if prev.State == StatePending && now.Sub(prev.StartedAt) >= rule.For() {
+			next.State, next.FiredAt = StateFiring, now
+		}
+	case active:
+		next.State, next.StartedAt = StatePending, now
+		next.FiredAt, next.ResolvedAt = time.Time{}, time.Time{}
+		if rule.For() == 0 {
+			next.State, next.FiredAt = StateFiring, now
+		}
+	case prev.State == StateFiring:
+		next.State, next.ResolvedAt = StateResolved, now
+	case prev.State == StateResolved && prev.NotifiedState == StateFiring:
+		// Stays resolved until the resolution has been delivered.
+	default:
+		next.State = StateInactive
+	}
+	return next
+}
+
+// stepNewDependency fires for an edge that was not known to the rule and
+// keeps it firing for the rule's duration, at least one evaluation.
+func (e *Engine) stepNewDependency(rule Rule, prev models.AlertState, known, isNew bool, now time.Time) models.AlertState {
+	switch {
+	case !known && isNew:
+		return models.AlertState{State: StateFiring, StartedAt: now, FiredAt: now}
+	case !known:
+		return models.AlertState{State: StateInactive, StartedAt: now}
+	}
+
+	next := prev
+	switch prev.State {
+	case StateFiring:
+		if now.Sub(prev.FiredAt) >= max(rule.For(), e.interval) {
+			next.State, next.ResolvedAt = StateResolved, now
+		}
+	case StateResolved:
+		if prev.NotifiedState != StateFiring {
+			next.State = StateInactive
+		}
+	}
+	return next
+}
+
+// notify queues the rule's due notifications and returns the states to
+// store. Nothing is queued while the rule's previous delivery is in flight;
+// the alerts still due are sent on a later evaluation.
+func (e *Engine) notify(sessionToken uuid.UUID, rule Rule, states []models.AlertState, now time.Time) []models.AlertState {
+	for i := range states {
+		states[i].UpdatedAt = now
+	}
+
+	var due, firing []int
+	for i, s := range states {
+		if s.State == StateFiring {
+			firing = append(firing, i)
+		}
+		repeat := s.State == StateFiring && (s.NotifiedState != StateFiring || now.Sub(s.NotifiedAt) >= e.repeat)
+		if repeat || (s.State == StateResolved && s.NotifiedState == StateFiring) {
+			due = append(due, i)
+		}
+	}
+
+	d := delivery{sessionToken: sessionToken, rule: rule, at: now}
+	for _, receiver := range rule.Receivers {
+		indexes := due
+		if receiver.Type == ReceiverAlertmanager {
+			indexes = firing
+			for _, i := range due {
+				if states[i].State == StateResolved {
+					indexes = append(indexes, i)
+				}
+			}
+		}
+		if len(indexes) == 0 {
+			continue
+		}
+		alerts := make([]Alert, len(indexes))
+		for j, i := range indexes {
+			alerts[j] = alert(sessionToken, rule, states[i])
+		}
+		d.sends = append(d.sends, send{receiver: receiver, alerts: alerts})
+	}
+	if len(d.sends) == 0 {
+		return states
+	}
+	for _, i := range due {
+		d.due = append(d.due, states[i])
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.inFlight[rule.ID] {
+		return states
+	}
+	select {
+	case e.deliveries <- d:
+		e.inFlight[rule.ID] = true
+	default:
+		log.Printf("alerting: rule %s: notification queue full", rule.ID)
+	}
+	return states
+}
+
+// deliver sends queued notifications until ctx is done. When every receiver
+// of a rule got its alerts, the due states are recorded as notified.
+func (e *Engine) deliver(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case d := <-e.deliveries:
+			delivered := true
+			for _, s := range d.sends {
+				if err := e.notifier.Send(ctx, d.sessionToken, s.receiver, s.alerts); err != nil {
+					log.Printf("alerting: rule %s: %s receiver: %v", d.rule.ID, s.receiver.Type, err)
+					delivered = false
+				}
+			}
+
+			e.mu.Lock()
+			delete(e.inFlight, d.rule.ID)
+			if delivered {
+				for _, s := range d.due {
+					s.NotifiedState, s.NotifiedAt = s.State, d.at
+					e.delivered[d.rule.ID] = append(e.delivered[d.rule.ID], s)
+				}
+			}
+			e.mu.Unlock()
+		}
+	}
+}
+
+// takeDelivered returns and forgets the states delivered for the rules since
+// their last evaluation.
+func (e *Engine) takeDelivered(rules []Rule) map[uuid.UUID][]models.AlertState {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	taken := map[uuid.UUID][]models.AlertState{}
+	for _, rule := range rules {
+		if states, ok := e.delivered[rule.ID]; ok {
+			taken[rule.ID] = states
+			delete(e.delivered, rule.ID)
+		}
+	}
+	return taken
+}
+
+func (e *Engine) restoreDelivered(taken map[uuid.UUID][]models.AlertState) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for ruleID, states := range taken {
+		e.delivered[ruleID] = append(states, e.delivered[ruleID]...)
+	}
+}
+
+func alert(sessionToken uuid.UUID, rule Rule, s models.AlertState) Alert {
+	sum := sha256.Sum256([]byte(sessionToken.String() + "/" + rule.ID.String() + "/" + s.Instance))
+	a := Alert{
+		Fingerprint: hex.EncodeToString(sum[:8]),
+		RuleID:      rule.ID,
+		Rule:        rule.Name,
+		Kind:        rule.Kind,
+		Scope:       rule.Scope,
+		Instance:    s.Instance,
+		Status:      s.State,
+		Value:       s.Value,
+		Threshold:   rule.Threshold,
+		StartsAt:    s.FiredAt,
+	}
+	if s.State == StateResolved {
+		a.EndsAt = s.ResolvedAt
+	}
+	return a
+}
@@ -0,0 +1,217 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+)
+
+const (
+	notifyAttempts = 3
+	notifyBackoff  = time.Second
+	notifyTimeout  = 10 * time.Second
+)
+
+// Alert is one alert instance as sent to receivers.
+type Alert struct {
+	Fingerprint string    `json:"fingerprint"`
+	RuleID      uuid.UUID `json:"rule_id"`
+	Rule        string    `json:"rule"`
+	Kind        string    `json:"kind"`
+	Scope       string    `json:"scope"`
+	Instance    string    `json:"instance"`
+	Status      string    `json:"status"`
+	Value       float64   `json:"value"`
+	Threshold   float64   `json:"threshold"`
+	StartsAt    time.Time `json:"starts_at"`
+	EndsAt      time.Time `json:"ends_at,omitzero"`
+}
+
+// Summary is a one-line description of the alert.
+func (a Alert) Summary() string {
+	return fmt.Sprintf("[%s] %s on %s: %s", strings.ToUpper(a.Status), a.Rule, a.Instance, a.describe())
+}
+
+func (a Alert) describe() string {
+	switch a.Kind {
+	case KindErrorRate:
+		return fmt.Sprintf("error rate %.2f%% (threshold %.2f%%)", a.Value*100, a.Threshold*100)
+	case KindLatency:
+		return fmt.Sprintf("latency %.2fms (threshold %.2fms)", a.Value, a.Threshold)
+	case KindRPSDrop:
+		return fmt.Sprintf("%.2f requests per second (drop threshold %.0f%%)", a.Value, a.Threshold*100)
+	default:
+		return "new dependency"
+	}
+}
+
+// Notifier delivers alerts to webhook, Slack and Alertmanager receivers,
+// retrying failed deliveries with exponential backoff.
+type Notifier struct {
+	client *http.Client
+}
+
+func NewNotifier() *Notifier {
+	dialer := &net.Dialer{Timeout: notifyTimeout, Control: dialPublic}
+	transport := &http.Transport{
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: notifyTimeout,
+		MaxIdleConns:        10,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	return &Notifier{client: &http.Client{Timeout: notifyTimeout, Transport: transport}}
+}
+
+// dialPublic refuses connections to addresses rules may not name, including
+// those a receiver's host name resolves to or redirects lead to.
+func dialPublic(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil || !publicAddr(ip) {
+		return fmt.Errorf("%w: %s", errorz.ErrReceiverNotAllowed, host)
+	}
+	return nil
+}
+
+// Send delivers the alerts of a session to one receiver.
+func (n *Notifier) Send(ctx context.Context, sessionToken uuid.UUID, receiver Receiver, alerts []Alert) error {
+	url, body, err := payload(sessionToken, receiver, alerts)
+	if err != nil {
+		return errors.Join(errorz.ErrWhileSendingNotification, err)
+	}
+
+	backoff := notifyBackoff
+	for attempt := 1; ; attempt++ {
+		retry, err := n.post(ctx, url, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt == notifyAttempts {
+			return errors.Join(errorz.ErrWhileSendingNotification, err)
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Join(errorz.ErrWhileSendingNotification, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post reports whether a failed request is worth retrying: network errors,
+// rate limiting and server errors are, other rejections are not.
+func (n *Notifier) post(ctx context.Context, url string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if errors.Is(err, errorz.ErrReceiverNotAllowed) {
+		return false, err
+	}
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("receiver responded with %s", resp.Status)
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+func payload(sessionToken uuid.UUID, receiver Receiver, alerts []Alert) (string, []byte, error) {
+	var v any
+	url := receiver.URL
+	switch receiver.Type {
+	case ReceiverSlack:
+		v = slackPayload(alerts)
+	case ReceiverAlertmanager:
+		if !strings.HasSuffix(url, "/api/v2/alerts") {
+			url = strings.TrimSuffix(url, "/") + "/api/v2/alerts"
+		}
+		v = alertmanagerPayload(sessionToken, alerts)
+	default:
+		status := StateResolved
+		for _, a := range alerts {
+			if a.Status == StateFiring {
+				status = StateFiring
+			}
+		}
+		v = map[string]any{
+			"version":       "1",
+			"session_token": sessionToken,
+			"status":        status,
+			"alerts":        alerts,
+		}
+	}
+
+	body, err := json.Marshal(v)
+	return url, body, err
+}
+
+func slackPayload(alerts []Alert) map[string]any {
+	lines := make([]string, len(alerts))
+	attachments := make([]map[string]any, len(alerts))
+	for i, a := range alerts {
+		lines[i] = a.Summary()
+		color := "good"
+		if a.Status == StateFiring {
+			color = "danger"
+		}
+		attachments[i] = map[string]any{
+			"color":  color,
+			"title":  a.Rule,
+			"text":   a.Instance + ": " + a.describe(),
+			"footer": a.Fingerprint,
+			"ts":     a.StartsAt.Unix(),
+		}
+	}
+	return map[string]any{
+		"text":        strings.Join(lines, "\n"),
+		"attachments": attachments,
+	}
+}
+
+func alertmanagerPayload(sessionToken uuid.UUID, alerts []Alert) []map[string]any {
+	out := make([]map[string]any, len(alerts))
+	for i, a := range alerts {
+		alert := map[string]any{
+			"labels": map[string]string{
+				"alertname":     a.Rule,
+				"rule_id":       a.RuleID.String(),
+				"kind":          a.Kind,
+				"scope":         a.Scope,
+				"instance":      a.Instance,
+				"session_token": sessionToken.String(),
+			},
+			"annotations": map[string]string{
+				"summary": a.Summary(),
+				"value":   fmt.Sprintf("%g", a.Value),
+			},
+			"startsAt": a.StartsAt,
+		}
+		if !a.EndsAt.IsZero() {
+			alert["endsAt"] = a.EndsAt
+		}
+		out[i] = alert
+	}
+	return out
+}
@@ -0,0 +1,238 @@
+package alerting
+
+import (
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+	mapz "github.com/jack5341/otel-map-server/internal/mapz"
+)
+
+const (
+	KindErrorRate     = "error_rate"
+	KindLatency       = "latency"
+	KindRPSDrop       = "rps_drop"
+	KindNewDependency = "new_dependency"
+)
+
+const (
+	ScopeService = "service"
+	ScopeEdge    = "edge"
+)
+
+const (
+	StateInactive = "inactive"
+	StatePending  = "pending"
+	StateFiring   = "firing"
+	StateResolved = "resolved"
+)
+
+const (
+	ReceiverWebhook      = "webhook"
+	ReceiverSlack        = "slack"
+	ReceiverAlertmanager = "alertmanager"
+)
+
+// Quantiles are the latency quantiles rules can alert on.
+var Quantiles = []float64{0.5, 0.9, 0.95, 0.99}
+
+// baselineWeight is the weight of the newest sample in the EWMA traffic
+// baseline of rps_drop rules.
+const baselineWeight = 0.2
+
+type Receiver struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+// Rule alerts on the services or edges of a session:
+//
+//   - error_rate fires when the error rate exceeds Threshold (0-1).
+//   - latency fires when the Quantile latency exceeds Threshold milliseconds.
+//   - rps_drop fires when requests per second fall more than Threshold (0-1)
+//     below their moving average.
+//   - new_dependency fires when an edge that was never seen appears.
+//
+// Target restricts the rule to one service, or one edge written as
+// "source -> target"; for edges a service name matches either end. A
+// condition has to hold for ForSeconds before the alert fires; for
+// new_dependency it is how long the alert keeps firing.
+type Rule struct {
+	ID          uuid.UUID  `json:"id"`
+	Name        string     `json:"name"`
+	Kind        string     `json:"kind"`
+	Scope       string     `json:"scope"`
+	Target      string     `json:"target,omitempty"`
+	Quantile    string     `json:"quantile,omitempty"`
+	Threshold   float64    `json:"threshold"`
+	MinRequests uint64     `json:"min_requests"`
+	ForSeconds  int64      `json:"for_seconds"`
+	Receivers   []Receiver `json:"receivers"`
+}
+
+func (r Rule) For() time.Duration {
+	return time.Duration(r.ForSeconds) * time.Second
+}
+
+func (r Rule) Validate() error {
+	if r.Name == "" || r.ForSeconds < 0 {
+		return errorz.ErrInvalidAlertRule
+	}
+	if r.Scope != ScopeService && r.Scope != ScopeEdge {
+		return errorz.ErrInvalidAlertRule
+	}
+
+	switch r.Kind {
+	case KindErrorRate, KindRPSDrop:
+		if r.Threshold <= 0 || r.Threshold > 1 {
+			return errorz.ErrInvalidAlertRule
+		}
+	case KindLatency:
+		if r.Threshold <= 0 || !slices.Contains(quantileKeys(), r.Quantile) {
+			return errorz.ErrInvalidAlertRule
+		}
+	case KindNewDependency:
+		if r.Scope != ScopeEdge {
+			return errorz.ErrInvalidAlertRule
+		}
+	default:
+		return errorz.ErrInvalidAlertRule
+	}
+
+	for _, receiver := range r.Receivers {
+		switch receiver.Type {
+		case ReceiverWebhook, ReceiverSlack, ReceiverAlertmanager:
+		default:
+			return errorz.ErrInvalidAlertRule
+		}
+		u, err := url.Parse(receiver.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errorz.ErrInvalidAlertRule
+		}
+		if !publicHost(u.Hostname()) {
+			return errorz.ErrReceiverNotAllowed
+		}
+	}
+	return nil
+}
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598), internal to
+// the provider like the private ranges.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicHost reports whether a receiver host may be called: anyone holding
+// a session token can add receivers, so they must not reach the server's
+// own network. Names are checked again once resolved, when dialling.
+func publicHost(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "" || host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return publicAddr(ip)
+	}
+	return true
+}
+
+// publicAddr reports whether ip is outside the loopback, private, link-local,
+// shared, multicast and unspecified ranges.
+func publicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !sharedAddressSpace.Contains(ip)
+}
+
+func (r Rule) matches(instance string) bool {
+	if r.Target == "" || r.Target == instance {
+		return true
+	}
+	if r.Scope == ScopeEdge {
+		source, target, _ := strings.Cut(instance, edgeSeparator)
+		return r.Target == source || r.Target == target
+	}
+	return false
+}
+
+func quantileKeys() []string {
+	keys := make([]string, len(Quantiles))
+	for i, q := range Quantiles {
+		keys[i] = mapz.QuantileKey(q)
+	}
+	return keys
+}
+
+const edgeSeparator = " -> "
+
+// observation is what a rule is evaluated against: the metrics of one
+// service, or of one edge summed over its paths.
+type observation struct {
+	requests  uint64
+	errors    uint64
+	rps       float64
+	latencyMs map[string]float64
+}
+
+func (o observation) errorRate() float64 {
+	if o.requests == 0 {
+		return 0
+	}
+	return float64(o.errors) / float64(o.requests)
+}
+
+func observe(scope string, services []mapz.Service, edges []mapz.Edge, window time.Duration) map[string]observation {
+	observations := map[string]observation{}
+	if scope == ScopeService {
+		for _, svc := range services {
+			observations[svc.ServiceName] = observation{
+				requests:  uint64(svc.TotalRequests),
+				errors:    uint64(svc.ErrorCount),
+				rps:       float64(svc.TotalRequests) / window.Seconds(),
+				latencyMs: svc.LatencyQuantiles,
+			}
+		}
+		return observations
+	}
+
+	for _, e := range edges {
+		if e.SourceServiceName == e.TargetServiceName {
+			continue
+		}
+		key := e.SourceServiceName + edgeSeparator + e.TargetServiceName
+		o := observations[key]
+		o.requests += e.TotalRequests
+		o.errors += e.ErrorCount
+		o.rps += float64(e.TotalRequests) / window.Seconds()
+		if o.latencyMs == nil {
+			o.latencyMs = map[string]float64{}
+		}
+		// Paths are summarised by their slowest quantile.
+		for q, v := range e.LatencyQuantiles {
+			o.latencyMs[q] = max(o.latencyMs[q], v)
+		}
+		observations[key] = o
+	}
+	return observations
+}
+
+// condition reports whether the rule's condition holds for an instance and
+// the value it was checked against. For rps_drop, MinRequests applies to the
+// usual traffic over the window, so that quiet services do not alert on noise.
+func condition(r Rule, o observation, baseline float64, window time.Duration) (bool, float64) {
+	enough := o.requests >= r.MinRequests
+	switch r.Kind {
+	case KindErrorRate:
+		v := o.errorRate()
+		return enough && v > r.Threshold, v
+	case KindLatency:
+		v := o.latencyMs[r.Quantile]
+		return enough && v > r.Threshold, v
+	case KindRPSDrop:
+		usual := baseline * window.Seconds()
+		return baseline > 0 && usual >= float64(r.MinRequests) && o.rps < baseline*(1-r.Threshold), o.rps
+	default:
+		return false, 0
+	}
+}
@@ -0,0 +1,225 @@
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+	"github.com/jack5341/otel-map-server/internal/metrics"
+	"github.com/jack5341/otel-map-server/internal/models"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const getAlertRulesQuery = `
+SELECT
+    id,
+    argMax(name, updated_at) AS name,
+    argMax(kind, updated_at) AS kind,
+    argMax(scope, updated_at) AS scope,
+    argMax(target, updated_at) AS target,
+    argMax(quantile, updated_at) AS quantile,
+    argMax(threshold, updated_at) AS threshold,
+    argMax(min_requests, updated_at) AS min_requests,
+    argMax(for_seconds, updated_at) AS for_seconds,
+    argMax(receivers, updated_at) AS receivers,
+    argMax(deleted, updated_at) AS deleted
+FROM alert_rules
+WHERE token = ?
+GROUP BY id
+HAVING NOT deleted
+ORDER BY name
+`
+
+const getAlertSessionsQuery = `
+SELECT token
+FROM (
+    SELECT token, id, argMax(deleted, updated_at) AS deleted
+    FROM alert_rules
+    GROUP BY token, id
+)
+WHERE NOT deleted
+GROUP BY token
+`
+
+const getAlertStatesQuery = `
+SELECT
+    rule_id,
+    instance,
+    argMax(state, updated_at) AS state,
+    argMax(value, updated_at) AS value,
+    argMax(baseline, updated_at) AS baseline,
+    argMax(started_at, updated_at) AS started_at,
+    argMax(fired_at, updated_at) AS fired_at,
+    argMax(resolved_at, updated_at) AS resolved_at,
+    argMax(notified_at, updated_at) AS notified_at,
+    argMax(notified_state, updated_at) AS notified_state
+FROM alert_states
+WHERE token = ?
+GROUP BY rule_id, instance
+ORDER BY rule_id, instance
+`
+
+// Store persists the alert rules of sessions and the state of their alerts.
+type Store struct {
+	db         *gorm.DB
+	otelTracer trace.Tracer
+	ctx        context.Context
+}
+
+func NewStore(db *gorm.DB, otelTracer trace.Tracer, ctx context.Context) *Store {
+	return &Store{db: db, otelTracer: otelTracer, ctx: ctx}
+}
+
+func (s *Store) Rules(sessionToken uuid.UUID) ([]Rule, error) {
+	ctx, span := s.otelTracer.Start(s.ctx, "Store.Rules")
+	defer span.End()
+
+	var rows []models.AlertRule
+	start := time.Now()
+	err := s.db.WithContext(ctx).Raw(getAlertRulesQuery, sessionToken).Scan(&rows).Error
+	metrics.ObserveQuery("get_alert_rules", start, err)
+	if err != nil {
+		return nil, errors.Join(errorz.ErrWhileGettingAlertRules, err)
+	}
+
+	rules := make([]Rule, 0, len(rows))
+	for _, row := range rows {
+		rule := Rule{
+			ID:          row.ID,
+			Name:        row.Name,
+			Kind:        row.Kind,
+			Scope:       row.Scope,
+			Target:      row.Target,
+			Quantile:    row.Quantile,
+			Threshold:   row.Threshold,
+			MinRequests: row.MinRequests,
+			ForSeconds:  row.ForSeconds,
+		}
+		if err := json.Unmarshal([]byte(row.Receivers), &rule.Receivers); err != nil {
+			return nil, errors.Join(errorz.ErrWhileGettingAlertRules, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Sessions returns the tokens of sessions with at least one rule.
+func (s *Store) Sessions() ([]uuid.UUID, error) {
+	ctx, span := s.otelTracer.Start(s.ctx, "Store.Sessions")
+	defer span.End()
+
+	var tokens []uuid.UUID
+	start := time.Now()
+	err := s.db.WithContext(ctx).Raw(getAlertSessionsQuery).Scan(&tokens).Error
+	metrics.ObserveQuery("get_alert_sessions", start, err)
+	if err != nil {
+		return nil, errors.Join(errorz.ErrWhileGettingAlertRules, err)
+	}
+	return tokens, nil
+}
+
+// SaveRule stores a new rule, or replaces the one with the same ID.
+func (s *Store) SaveRule(sessionToken uuid.UUID, rule Rule) (Rule, error) {
+	ctx, span := s.otelTracer.Start(s.ctx, "Store.SaveRule")
+	defer span.End()
+
+	if err := rule.Validate(); err != nil {
+		return Rule{}, err
+	}
+	if rule.ID == uuid.Nil {
+		rule.ID = uuid.New()
+	}
+	if rule.Receivers == nil {
+		rule.Receivers = []Receiver{}
+	}
+
+	row, err := ruleRow(sessionToken, rule, false)
+	if err != nil {
+		return Rule{}, errors.Join(errorz.ErrWhileSavingAlertRule, err)
+	}
+	if err := s.db.WithContext(ctx).Create(row).Error; err != nil {
+		return Rule{}, errors.Join(errorz.ErrWhileSavingAlertRule, err)
+	}
+	return rule, nil
+}
+
+func (s *Store) DeleteRule(sessionToken uuid.UUID, id uuid.UUID) error {
+	ctx, span := s.otelTracer.Start(s.ctx, "Store.DeleteRule")
+	defer span.End()
+
+	rules, err := s.Rules(sessionToken)
+	if err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		if rule.ID != id {
+			continue
+		}
+		row, err := ruleRow(sessionToken, rule, true)
+		if err != nil {
+			return errors.Join(errorz.ErrWhileSavingAlertRule, err)
+		}
+		if err := s.db.WithContext(ctx).Create(row).Error; err != nil {
+			return errors.Join(errorz.ErrWhileSavingAlertRule, err)
+		}
+		return nil
+	}
+	return errorz.ErrAlertRuleNotFound
+}
+
+// States returns the latest state of every alert instance of the session.
+func (s *Store) States(sessionToken uuid.UUID) ([]models.AlertState, error) {
+	ctx, span := s.otelTracer.Start(s.ctx, "Store.States")
+	defer span.End()
+
+	var states []models.AlertState
+	start := time.Now()
+	err := s.db.WithContext(ctx).Raw(getAlertStatesQuery, sessionToken).Scan(&states).Error
+	metrics.ObserveQuery("get_alert_states", start, err)
+	if err != nil {
+		return nil, errors.Join(errorz.ErrWhileGettingAlertStates, err)
+	}
+	for i := range states {
+		states[i].Token = sessionToken
+	}
+	return states, nil
+}
+
+func (s *Store) SaveStates(states []models.AlertState) error {
+	ctx, span := s.otelTracer.Start(s.ctx, "Store.SaveStates")
+	defer span.End()
+
+	if len(states) == 0 {
+		return nil
+	}
+	if err := s.db.WithContext(ctx).Create(&states).Error; err != nil {
+		return errors.Join(errorz.ErrWhileSavingAlertStates, err)
+	}
+	return nil
+}
+
+func ruleRow(token uuid.UUID, rule Rule, deleted bool) (*models.AlertRule, error) {
+	receivers, err := json.Marshal(rule.Receivers)
+	if err != nil {
+		return nil, err
+	}
+	return &models.AlertRule{
+		ID:          rule.ID,
+		Token:       token,
+		Name:        rule.Name,
+		Kind:        rule.Kind,
+		Scope:       rule.Scope,
+		Target:      rule.Target,
+		Quantile:    rule.Quantile,
+		Threshold:   rule.Threshold,
+		MinRequests: rule.MinRequests,
+		ForSeconds:  rule.ForSeconds,
+		Receivers:   string(receivers),
+		Deleted:     deleted,
+		UpdatedAt:   time.Now().UTC(),
+	}, nil
+}
@@ -0,0 +1,253 @@
+package analysis
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"time"
+
+	mapz "github.com/jack5341/otel-map-server/internal/mapz"
+)
+
+const (
+	MetricErrorRate = "error_rate"
+	MetricLatency   = "latency_p95_ms"
+	MetricTraffic   = "requests_per_second"
+)
+
+const (
+	DirectionHigh = "high"
+	DirectionLow  = "low"
+)
+
+const (
+	AnomalyScopeService = "service"
+	AnomalyScopeEdge    = "edge"
+)
+
+// madScale turns a median absolute deviation into a standard deviation
+// estimate for normally distributed data.
+const madScale = 1.4826
+
+// minSpread floors the spread of a baseline per metric, so a perfectly flat
+// history does not turn the smallest change into an anomaly. The spread is
+// also at least a tenth of the baseline median.
+var minSpread = map[string]float64{
+	MetricErrorRate: 0.01,
+	MetricLatency:   5,
+	MetricTraffic:   0.1,
+}
+
+type AnomalyOptions struct {
+	// Threshold is the robust z-score from which a bucket is anomalous.
+	Threshold float64
+	// Lookback is how many preceding buckets form the baseline when there is
+	// not enough history at the same time of day.
+	Lookback int
+	// MinBaseline is the number of usable buckets a baseline needs.
+	MinBaseline int
+	// MinRequests is the traffic a bucket needs for its error rate and
+	// latency to count, and the usual traffic below which drops are ignored.
+	MinRequests uint64
+}
+
+func DefaultAnomalyOptions() AnomalyOptions {
+	return AnomalyOptions{
+		Threshold:   3.5,
+		Lookback:    60,
+		MinBaseline: 10,
+		MinRequests: 10,
+	}
+}
+
+// Anomaly is a bucket whose metric deviates from the baseline of its node or
+// edge. Baseline is the median the bucket was compared with.
+type Anomaly struct {
+	Scope             string    `json:"scope"`
+	ID                string    `json:"id"`
+	ServiceName       string    `json:"service_name,omitempty"`
+	SourceServiceName string    `json:"source_service_name,omitempty"`
+	TargetServiceName string    `json:"target_service_name,omitempty"`
+	Metric            string    `json:"metric"`
+	Time              time.Time `json:"time"`
+	Value             float64   `json:"value"`
+	Baseline          float64   `json:"baseline"`
+	Score             float64   `json:"score"`
+	Direction         string    `json:"direction"`
+}
+
+// AnomalyReport lists the anomalous buckets, newest first, and the scores of
+// the latest complete bucket per node and edge.
+type AnomalyReport struct {
+	Anomalies []Anomaly                        `json:"anomalies"`
+	Nodes     map[string]mapz.AnomalyScores    `json:"-"`
+	Edges     map[[2]string]mapz.AnomalyScores `json:"-"`
+}
+
+// DetectAnomalies scores every bucket of the series against a baseline of the
+// same metric: the buckets at the same time of day on previous days when there
+// are enough of them, otherwise the preceding Lookback buckets. Scores are
+// robust z-scores, the distance from the baseline median in units of its
+// scaled median absolute deviation. Buckets that have not ended by now are
+// skipped, since their traffic is still incomplete.
+func DetectAnomalies(services []mapz.ServiceSeries, edges []mapz.EdgeSeries, step time.Duration, now time.Time, opts AnomalyOptions) AnomalyReport {
+	report := AnomalyReport{
+		Anomalies: []Anomaly{},
+		Nodes:     map[string]mapz.AnomalyScores{},
+		Edges:     map[[2]string]mapz.AnomalyScores{},
+	}
+
+	for _, s := range services {
+		anomalies, latest := scoreSeries(s.Points, step, now, opts)
+		for _, a := range anomalies {
+			a.Scope, a.ID, a.ServiceName = AnomalyScopeService, s.ID, s.ServiceName
+			report.Anomalies = append(report.Anomalies, a)
+		}
+		if len(latest) > 0 {
+			report.Nodes[s.ID] = latest
+		}
+	}
+	for _, e := range edges {
+		anomalies, latest := scoreSeries(e.Points, step, now, opts)
+		for _, a := range anomalies {
+			a.Scope, a.ID = AnomalyScopeEdge, e.SourceID+" -> "+e.TargetID
+			a.SourceServiceName, a.TargetServiceName = e.SourceServiceName, e.TargetServiceName
+			report.Anomalies = append(report.Anomalies, a)
+		}
+		if len(latest) > 0 {
+			report.Edges[[2]string{e.SourceID, e.TargetID}] = latest
+		}
+	}
+
+	sort.SliceStable(report.Anomalies, func(i, j int) bool {
+		a, b := report.Anomalies[i], report.Anomalies[j]
+		if !a.Time.Equal(b.Time) {
+			return a.Time.After(b.Time)
+		}
+		return a.Score > b.Score
+	})
+	return report
+}
+
+// Apply attaches the latest scores to the map's nodes and edges.
+func (r AnomalyReport) Apply(services []mapz.Service, edges []mapz.Edge) {
+	for i := range services {
+		services[i].AnomalyScores = r.Nodes[services[i].NodeID()]
+	}
+	for i := range edges {
+		edges[i].AnomalyScores = r.Edges[[2]string{edges[i].SourceNodeID(), edges[i].TargetNodeID()}]
+	}
+}
+
+func scoreSeries(points []mapz.SeriesPoint, step time.Duration, now time.Time, opts AnomalyOptions) ([]Anomaly, mapz.AnomalyScores) {
+	complete := len(points)
+	for complete > 0 && points[complete-1].Time.Add(step).After(now) {
+		complete--
+	}
+
+	var anomalies []Anomaly
+	var latest mapz.AnomalyScores
+	for _, metric := range []string{MetricErrorRate, MetricLatency, MetricTraffic} {
+		usable := func(p mapz.SeriesPoint) bool {
+			return metric == MetricTraffic || p.Requests >= opts.MinRequests
+		}
+		for i := 0; i < complete; i++ {
+			if !usable(points[i]) {
+				continue
+			}
+			baseline := baselineValues(points, i, step, metric, usable, opts)
+			if len(baseline) < opts.MinBaseline {
+				continue
+			}
+
+			value := metricValue(points[i], metric)
+			median, score := robustScore(value, baseline, metric)
+			if i == complete-1 {
+				if latest == nil {
+					latest = mapz.AnomalyScores{}
+				}
+				latest[metric] = score
+			}
+
+			direction := DirectionHigh
+			if score < 0 {
+				direction = DirectionLow
+			}
+			switch {
+			case math.Abs(score) < opts.Threshold:
+				continue
+			case metric != MetricTraffic && direction == DirectionLow:
+				// Fewer errors or faster responses are not a problem.
+				continue
+			case metric == MetricTraffic && median*step.Seconds() < float64(opts.MinRequests):
+				continue
+			}
+			anomalies = append(anomalies, Anomaly{
+				Metric:    metric,
+				Time:      points[i].Time,
+				Value:     value,
+				Baseline:  median,
+				Score:     score,
+				Direction: direction,
+			})
+		}
+	}
+	return anomalies, latest
+}
+
+// baselineValues returns the metric at the same time on previous days, or in
+// the preceding Lookback buckets when there are too few of those.
+func baselineValues(points []mapz.SeriesPoint, i int, step time.Duration, metric string, usable func(mapz.SeriesPoint) bool, opts AnomalyOptions) []float64 {
+	var values []float64
+	if day := 24 * time.Hour; day%step == 0 {
+		perDay := int(day / step)
+		for j := i - perDay; j >= 0; j -= perDay {
+			if usable(points[j]) {
+				values = append(values, metricValue(points[j], metric))
+			}
+		}
+		if len(values) >= opts.MinBaseline {
+			return values
+		}
+	}
+
+	values = values[:0]
+	for j := max(0, i-opts.Lookback); j < i; j++ {
+		if usable(points[j]) {
+			values = append(values, metricValue(points[j], metric))
+		}
+	}
+	return values
+}
+
+func metricValue(p mapz.SeriesPoint, metric string) float64 {
+	switch metric {
+	case MetricErrorRate:
+		return p.ErrorRate
+	case MetricLatency:
+		return p.LatencyP95Ms
+	default:
+		return p.RequestsPerSecond
+	}
+}
+
+func robustScore(value float64, baseline []float64, metric string) (float64, float64) {
+	median := medianOf(baseline)
+	deviations := make([]float64, len(baseline))
+	for i, v := range baseline {
+		deviations[i] = math.Abs(v - median)
+	}
+	spread := max(madScale*medianOf(deviations), minSpread[metric], 0.1*math.Abs(median))
+	score := (value - median) / spread
+	return math.Round(median*1e4) / 1e4, math.Round(score*100) / 100
+}
+
+func medianOf(values []float64) float64 {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
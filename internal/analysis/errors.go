@@ -0,0 +1,72 @@
+package analysis
+
+import (
+	mapz "github.com/jack5341/otel-map-server/internal/mapz"
+)
+
+// ErrorBreakdown explains what is failing on a service or an edge.
+type ErrorBreakdown struct {
+	SourceServiceName string                `json:"source_service_name,omitempty"`
+	ServiceName       string                `json:"service_name"`
+	Exceptions        []mapz.ExceptionGroup `json:"exceptions"`
+	HTTPStatusCodes   map[string]uint64     `json:"http_status_codes"`
+	GRPCStatusCodes   map[string]uint64     `json:"grpc_status_codes"`
+}
+
+type ErrorReport struct {
+	Services []ErrorBreakdown `json:"services"`
+	Edges    []ErrorBreakdown `json:"edges"`
+}
+
+// ErrorsInput is the mapz data an error report is assembled from.
+type ErrorsInput struct {
+	ServiceExceptions  []mapz.ExceptionGroup
+	EdgeExceptions     []mapz.ExceptionGroup
+	ServiceStatusCodes []mapz.StatusCodeCount
+	EdgeStatusCodes    []mapz.StatusCodeCount
+}
+
+// Errors groups exception groups and status code counts per service and per
+// edge, in the order the services and edges are first seen.
+func Errors(in ErrorsInput) ErrorReport {
+	return ErrorReport{
+		Services: breakdowns(in.ServiceExceptions, in.ServiceStatusCodes),
+		Edges:    breakdowns(in.EdgeExceptions, in.EdgeStatusCodes),
+	}
+}
+
+func breakdowns(exceptions []mapz.ExceptionGroup, codes []mapz.StatusCodeCount) []ErrorBreakdown {
+	result := []ErrorBreakdown{}
+	index := map[[2]string]int{}
+	get := func(source, service string) *ErrorBreakdown {
+		key := [2]string{source, service}
+		i, ok := index[key]
+		if !ok {
+			i = len(result)
+			index[key] = i
+			result = append(result, ErrorBreakdown{
+				SourceServiceName: source,
+				ServiceName:       service,
+				Exceptions:        []mapz.ExceptionGroup{},
+				HTTPStatusCodes:   map[string]uint64{},
+				GRPCStatusCodes:   map[string]uint64{},
+			})
+		}
+		return &result[i]
+	}
+
+	for _, e := range exceptions {
+		b := get(e.SourceServiceName, e.ServiceName)
+		b.Exceptions = append(b.Exceptions, e)
+	}
+	for _, c := range codes {
+		b := get(c.SourceServiceName, c.ServiceName)
+		switch c.Kind {
+		case mapz.StatusKindHTTP:
+			b.HTTPStatusCodes[c.StatusCode] += c.Occurrences
+		case mapz.StatusKindGRPC:
+			b.GRPCStatusCodes[c.StatusCode] += c.Occurrences
+		}
+	}
+	return result
+}
@@ -0,0 +1,280 @@
+package analysis
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jack5341/otel-map-server/internal/graphz"
+	mapz "github.com/jack5341/otel-map-server/internal/mapz"
+)
+
+const (
+	FindingCycle     = "cycle"
+	FindingFanOut    = "fan_out"
+	FindingChatty    = "chatty_edge"
+	FindingNPlusOne  = "n_plus_one"
+	FindingDeepChain = "deep_chain"
+)
+
+const (
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+const maxExamples = 3
+
+// Thresholds tune when a pattern is reported.
+type Thresholds struct {
+	// FanOut is the number of distinct callees above which a service is flagged.
+	FanOut int
+	// ChattyCallsPerTrace is the average number of calls per trace between two
+	// services above which the edge is flagged.
+	ChattyCallsPerTrace float64
+	// NPlusOneRepeats is the number of identical child spans under one parent
+	// at which the parent is flagged.
+	NPlusOneRepeats int
+	// ChainDepth is the number of services in a single synchronous call chain
+	// at which the chain is flagged.
+	ChainDepth int
+	// SampleTraces is how many recent traces are walked for deep chains, at
+	// most MaxSampleTraces.
+	SampleTraces int
+}
+
+// MaxSampleTraces bounds SampleTraces: the spans of the sampled traces are
+// held in memory while they are walked.
+const MaxSampleTraces = 5000
+
+func DefaultThresholds() Thresholds {
+	return Thresholds{
+		FanOut:              10,
+		ChattyCallsPerTrace: 10,
+		NPlusOneRepeats:     5,
+		ChainDepth:          6,
+		SampleTraces:        500,
+	}
+}
+
+type Finding struct {
+	Type            string   `json:"type"`
+	Severity        string   `json:"severity"`
+	Services        []string `json:"services"`
+	Message         string   `json:"message"`
+	Value           float64  `json:"value"`
+	ExampleTraceIDs []string `json:"example_trace_ids"`
+}
+
+// Input is the mapz data an analysis pass runs over.
+type Input struct {
+	Edges     []mapz.Edge
+	CallStats []mapz.EdgeCallStats
+	Repeats   []mapz.RepeatedChildSpans
+	Spans     []mapz.SpanLink
+}
+
+// Analyze flags dependency cycles, excessive fan-out, chatty edges, N+1
+// patterns and deep synchronous call chains.
+func Analyze(in Input, t Thresholds) []Finding {
+	g := graphz.New(in.Edges)
+
+	examples := make(map[[2]string][]string, len(in.CallStats))
+	for _, s := range in.CallStats {
+		examples[[2]string{s.SourceServiceName, s.TargetServiceName}] = s.ExampleTraceIDs
+	}
+
+	findings := []Finding{}
+	findings = append(findings, cycles(g, examples)...)
+	findings = append(findings, fanOut(g, examples, t)...)
+	findings = append(findings, chatty(in.CallStats, t)...)
+	findings = append(findings, nPlusOne(in.Repeats, t)...)
+	findings = append(findings, deepChains(in.Spans, t)...)
+	return findings
+}
+
+func cycles(g *graphz.Graph, examples map[[2]string][]string) []Finding {
+	var findings []Finding
+	for _, component := range g.Cycles() {
+		members := map[string]bool{}
+		for _, s := range component {
+			members[s] = true
+		}
+		var traceIDs []string
+		for _, s := range component {
+			for _, t := range g.Callees(s) {
+				if members[t] {
+					traceIDs = appendExamples(traceIDs, examples[[2]string{s, t}])
+				}
+			}
+		}
+		findings = append(findings, Finding{
+			Type:            FindingCycle,
+			Severity:        SeverityCritical,
+			Services:        component,
+			Message:         fmt.Sprintf("%d services depend on each other: %s", len(component), strings.Join(component, ", ")),
+			Value:           float64(len(component)),
+			ExampleTraceIDs: traceIDs,
+		})
+	}
+	return findings
+}
+
+func fanOut(g *graphz.Graph, examples map[[2]string][]string, t Thresholds) []Finding {
+	var findings []Finding
+	for _, s := range g.Services() {
+		callees := g.Callees(s)
+		if len(callees) <= t.FanOut {
+			continue
+		}
+		var traceIDs []string
+		for _, c := range callees {
+			traceIDs = appendExamples(traceIDs, examples[[2]string{s, c}])
+		}
+		findings = append(findings, Finding{
+			Type:            FindingFanOut,
+			Severity:        SeverityWarning,
+			Services:        []string{s},
+			Message:         fmt.Sprintf("%s calls %d distinct services", s, len(callees)),
+			Value:           float64(len(callees)),
+			ExampleTraceIDs: traceIDs,
+		})
+	}
+	return findings
+}
+
+func chatty(stats []mapz.EdgeCallStats, t Thresholds) []Finding {
+	var findings []Finding
+	for _, s := range stats {
+		if s.AvgCallsPerTrace <= t.ChattyCallsPerTrace {
+			continue
+		}
+		findings = append(findings, Finding{
+			Type:     FindingChatty,
+			Severity: SeverityWarning,
+			Services: []string{s.SourceServiceName, s.TargetServiceName},
+			Message: fmt.Sprintf("%s calls %s %.1f times per trace on average (max %d)",
+				s.SourceServiceName, s.TargetServiceName, s.AvgCallsPerTrace, s.MaxCallsPerTrace),
+			Value:           s.AvgCallsPerTrace,
+			ExampleTraceIDs: appendExamples(nil, s.ExampleTraceIDs),
+		})
+	}
+	return findings
+}
+
+func nPlusOne(repeats []mapz.RepeatedChildSpans, t Thresholds) []Finding {
+	var findings []Finding
+	for _, r := range repeats {
+		if r.MaxRepeats < uint64(t.NPlusOneRepeats) {
+			continue
+		}
+		findings = append(findings, Finding{
+			Type:     FindingNPlusOne,
+			Severity: SeverityWarning,
+			Services: []string{r.ParentServiceName, r.ChildServiceName},
+			Message: fmt.Sprintf("%s repeats %q on %s up to %d times under one parent (%d occurrences)",
+				r.ParentServiceName, r.SpanName, r.ChildServiceName, r.MaxRepeats, r.Occurrences),
+			Value:           r.AvgRepeats,
+			ExampleTraceIDs: appendExamples(nil, r.ExampleTraceIDs),
+		})
+	}
+	return findings
+}
+
+// deepChains walks each sampled trace tree and measures the longest chain of
+// synchronous cross-service calls; consumer spans start a new chain since
+// they are decoupled from their producer.
+func deepChains(spans []mapz.SpanLink, t Thresholds) []Finding {
+	byTrace := map[string][]mapz.SpanLink{}
+	for _, s := range spans {
+		byTrace[s.TraceID] = append(byTrace[s.TraceID], s)
+	}
+
+	type chain struct {
+		services []string
+		traceIDs []string
+	}
+	chains := map[string]*chain{}
+	var order []string
+
+	for traceID, traceSpans := range byTrace {
+		children := map[string][]mapz.SpanLink{}
+		ids := map[string]bool{}
+		for _, s := range traceSpans {
+			ids[s.SpanID] = true
+		}
+		var roots []mapz.SpanLink
+		for _, s := range traceSpans {
+			if s.ParentSpanID == "" || !ids[s.ParentSpanID] {
+				roots = append(roots, s)
+			} else {
+				children[s.ParentSpanID] = append(children[s.ParentSpanID], s)
+			}
+		}
+
+		var deepest []string
+		var walk func(s mapz.SpanLink, path []string)
+		walk = func(s mapz.SpanLink, path []string) {
+			if isConsumer(s.SpanKind) {
+				path = []string{s.ServiceName}
+			} else if len(path) == 0 || path[len(path)-1] != s.ServiceName {
+				path = append(append([]string(nil), path...), s.ServiceName)
+			}
+			if len(path) > len(deepest) {
+				deepest = path
+			}
+			for _, c := range children[s.SpanID] {
+				walk(c, path)
+			}
+		}
+		for _, r := range roots {
+			walk(r, nil)
+		}
+
+		if len(deepest) < t.ChainDepth {
+			continue
+		}
+		key := strings.Join(deepest, " -> ")
+		c, ok := chains[key]
+		if !ok {
+			c = &chain{services: deepest}
+			chains[key] = c
+			order = append(order, key)
+		}
+		if len(c.traceIDs) < maxExamples {
+			c.traceIDs = append(c.traceIDs, traceID)
+		}
+	}
+
+	sort.Strings(order)
+	var findings []Finding
+	for _, key := range order {
+		c := chains[key]
+		findings = append(findings, Finding{
+			Type:            FindingDeepChain,
+			Severity:        SeverityWarning,
+			Services:        c.services,
+			Message:         fmt.Sprintf("synchronous call chain %d services deep: %s", len(c.services), key),
+			Value:           float64(len(c.services)),
+			ExampleTraceIDs: c.traceIDs,
+		})
+	}
+	return findings
+}
+
+func isConsumer(kind string) bool {
+	kind = strings.ToUpper(kind)
+	return kind == "CONSUMER" || kind == "SPAN_KIND_CONSUMER"
+}
+
+func appendExamples(dst, src []string) []string {
+	if dst == nil {
+		dst = []string{}
+	}
+	for _, id := range src {
+		if len(dst) >= maxExamples {
+			break
+		}
+		dst = append(dst, id)
+	}
+	return dst
+}
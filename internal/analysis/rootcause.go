@@ -0,0 +1,114 @@
+package analysis
+
+import (
+	"math"
+	"slices"
+	"sort"
+
+	mapz "github.com/jack5341/otel-map-server/internal/mapz"
+)
+
+// RootCause is a service, or one of its operations, suspected of starting
+// failures. Share is the fraction of failed traces in which it originated an
+// error and RootShare the fraction it made fail at the root. OriginRatio is
+// the fraction of its failed spans that originated rather than propagated an
+// error.
+type RootCause struct {
+	ServiceName     string   `json:"service_name"`
+	Operation       string   `json:"operation,omitempty"`
+	Score           float64  `json:"score"`
+	Share           float64  `json:"share"`
+	RootShare       float64  `json:"root_share"`
+	OriginRatio     float64  `json:"origin_ratio"`
+	Originated      uint64   `json:"originated"`
+	Propagated      uint64   `json:"propagated"`
+	AffectedTraces  uint64   `json:"affected_traces"`
+	RootFailures    uint64   `json:"root_failures"`
+	ExampleTraceIDs []string `json:"example_trace_ids"`
+}
+
+type RootCauseReport struct {
+	FailedTraces uint64      `json:"failed_traces"`
+	Services     []RootCause `json:"services"`
+	Operations   []RootCause `json:"operations"`
+}
+
+// RootCauses ranks the services and operations that originated errors by
+// OriginRatio times the mean of Share and RootShare, keeping the limit best
+// of each. Services that only propagated their callees' failures are left
+// out. A service's trace counts add up its operations, so a trace in which
+// several of its operations failed counts more than once.
+func RootCauses(origins []mapz.ErrorOrigin, failedTraces uint64, limit int) RootCauseReport {
+	report := RootCauseReport{FailedTraces: failedTraces, Services: []RootCause{}, Operations: []RootCause{}}
+
+	services := map[string]*RootCause{}
+	var order []string
+	for _, o := range origins {
+		op := RootCause{
+			ServiceName:     o.ServiceName,
+			Operation:       o.Operation,
+			Originated:      o.Originated,
+			Propagated:      o.Propagated,
+			AffectedTraces:  o.AffectedTraces,
+			RootFailures:    o.RootFailures,
+			ExampleTraceIDs: o.ExampleTraceIDs,
+		}
+		report.Operations = append(report.Operations, op)
+
+		svc, ok := services[o.ServiceName]
+		if !ok {
+			svc = &RootCause{ServiceName: o.ServiceName, ExampleTraceIDs: []string{}}
+			services[o.ServiceName] = svc
+			order = append(order, o.ServiceName)
+		}
+		svc.Originated += o.Originated
+		svc.Propagated += o.Propagated
+		svc.AffectedTraces += o.AffectedTraces
+		svc.RootFailures += o.RootFailures
+		for _, id := range o.ExampleTraceIDs {
+			if len(svc.ExampleTraceIDs) < maxExamples && !slices.Contains(svc.ExampleTraceIDs, id) {
+				svc.ExampleTraceIDs = append(svc.ExampleTraceIDs, id)
+			}
+		}
+	}
+	for _, name := range order {
+		report.Services = append(report.Services, *services[name])
+	}
+
+	report.Services = rankRootCauses(report.Services, failedTraces, limit)
+	report.Operations = rankRootCauses(report.Operations, failedTraces, limit)
+	return report
+}
+
+func rankRootCauses(causes []RootCause, failedTraces uint64, limit int) []RootCause {
+	ranked := []RootCause{}
+	for _, c := range causes {
+		if c.Originated == 0 {
+			continue
+		}
+		if failedTraces > 0 {
+			c.Share = math.Min(1, float64(c.AffectedTraces)/float64(failedTraces))
+			c.RootShare = math.Min(1, float64(c.RootFailures)/float64(failedTraces))
+		}
+		c.OriginRatio = float64(c.Originated) / float64(c.Originated+c.Propagated)
+		c.Score = c.OriginRatio * (c.Share + c.RootShare) / 2
+		for _, v := range []*float64{&c.Share, &c.RootShare, &c.OriginRatio, &c.Score} {
+			*v = math.Round(*v*1e4) / 1e4
+		}
+		if c.ExampleTraceIDs == nil {
+			c.ExampleTraceIDs = []string{}
+		}
+		ranked = append(ranked, c)
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].Score != ranked[j].Score {
+			return ranked[i].Score > ranked[j].Score
+		}
+		return ranked[i].AffectedTraces > ranked[j].AffectedTraces
+	})
+	if limit > 0 && len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+	return ranked
+}
@@ -0,0 +1,98 @@
+package analysis
+
+import (
+	"sort"
+
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+	mapz "github.com/jack5341/otel-map-server/internal/mapz"
+)
+
+// VersionDelta is the difference between a version's metrics and the
+// baseline's; positive values mean the version is worse.
+type VersionDelta struct {
+	ErrorRate    float64 `json:"error_rate"`
+	LatencyP50Ms float64 `json:"latency_p50_ms"`
+	LatencyP90Ms float64 `json:"latency_p90_ms"`
+	LatencyP95Ms float64 `json:"latency_p95_ms"`
+}
+
+type VersionStats struct {
+	Version string       `json:"version"`
+	Service mapz.Service `json:"service"`
+	// RequestShare is the fraction of the service's requests this version
+	// served.
+	RequestShare float64      `json:"request_share"`
+	Delta        VersionDelta `json:"delta"`
+	// Incoming are the edges whose target is this version.
+	Incoming []mapz.Edge `json:"incoming"`
+}
+
+type VersionComparison struct {
+	ServiceName string         `json:"service_name"`
+	Baseline    string         `json:"baseline"`
+	Versions    []VersionStats `json:"versions"`
+	// Annotations are the markers, such as deploys, that may explain the
+	// differences between versions.
+	Annotations []mapz.Annotation `json:"annotations"`
+}
+
+// CompareVersions compares the versions of a service on a map split by
+// mapz.VersionAttribute. The baseline defaults to the version that served the
+// most requests.
+func CompareVersions(serviceName, baseline string, services []mapz.Service, edges []mapz.Edge) (VersionComparison, error) {
+	cmp := VersionComparison{ServiceName: serviceName, Baseline: baseline, Versions: []VersionStats{}, Annotations: []mapz.Annotation{}}
+
+	var total int64
+	byVersion := map[string]int{}
+	for _, svc := range services {
+		if svc.ServiceName != serviceName {
+			continue
+		}
+		version := svc.Groups[mapz.VersionAttribute]
+		byVersion[version] = len(cmp.Versions)
+		cmp.Versions = append(cmp.Versions, VersionStats{Version: version, Service: svc, Incoming: []mapz.Edge{}})
+		total += svc.TotalRequests
+	}
+	if len(cmp.Versions) == 0 {
+		return cmp, errorz.ErrServiceNotFound
+	}
+
+	sort.SliceStable(cmp.Versions, func(i, j int) bool {
+		return cmp.Versions[i].Service.TotalRequests > cmp.Versions[j].Service.TotalRequests
+	})
+	for i, v := range cmp.Versions {
+		byVersion[v.Version] = i
+	}
+	if cmp.Baseline == "" {
+		cmp.Baseline = cmp.Versions[0].Version
+	}
+	b, ok := byVersion[cmp.Baseline]
+	if !ok {
+		return cmp, errorz.ErrVersionNotFound
+	}
+	base := cmp.Versions[b].Service
+
+	for i := range cmp.Versions {
+		v := &cmp.Versions[i]
+		if total > 0 {
+			v.RequestShare = float64(v.Service.TotalRequests) / float64(total)
+		}
+		v.Delta = VersionDelta{
+			ErrorRate:    v.Service.ErrorRate - base.ErrorRate,
+			LatencyP50Ms: v.Service.LatencyP50Ms - base.LatencyP50Ms,
+			LatencyP90Ms: v.Service.LatencyP90Ms - base.LatencyP90Ms,
+			LatencyP95Ms: v.Service.LatencyP95Ms - base.LatencyP95Ms,
+		}
+	}
+
+	for _, e := range edges {
+		if e.TargetServiceName != serviceName || e.SourceNodeID() == e.TargetNodeID() {
+			continue
+		}
+		if i, ok := byVersion[e.TargetGroups[mapz.VersionAttribute]]; ok {
+			cmp.Versions[i].Incoming = append(cmp.Versions[i].Incoming, e)
+		}
+	}
+
+	return cmp, nil
+}
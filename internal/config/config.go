@@ -1,9 +1,11 @@
 package config
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/caarlos0/env/v11"
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
 )
 
 type Config struct {
@@ -13,7 +15,23 @@ type Config struct {
 	ServiceName      string        `env:"SERVICE_NAME" envDefault:"default"`
 	ShutdownTimeoutS int           `env:"SHUTDOWN_TIMEOUT_SECONDS" envDefault:"10"`
 	BaseURL          string        `env:"BASE_URL" envDefault:"otelmap.com"`
+	MigrateOnStart   bool          `env:"MIGRATE_ON_START" envDefault:"true"`
 	ShutdownTimeout  time.Duration `env:"-"`
+
+	AlertIntervalS       int           `env:"ALERT_EVALUATION_INTERVAL_SECONDS" envDefault:"60"`
+	AlertWindowS         int           `env:"ALERT_WINDOW_SECONDS" envDefault:"300"`
+	AlertRepeatIntervalS int           `env:"ALERT_REPEAT_INTERVAL_SECONDS" envDefault:"14400"`
+	AlertInterval        time.Duration `env:"-"`
+	AlertWindow          time.Duration `env:"-"`
+	AlertRepeatInterval  time.Duration `env:"-"`
+
+	RollupIntervalS int           `env:"ROLLUP_INTERVAL_SECONDS" envDefault:"30"`
+	RollupDelayS    int           `env:"ROLLUP_DELAY_SECONDS" envDefault:"120"`
+	RollupInterval  time.Duration `env:"-"`
+	RollupDelay     time.Duration `env:"-"`
+
+	MapCacheTTLS int           `env:"MAP_CACHE_TTL_SECONDS" envDefault:"5"`
+	MapCacheTTL  time.Duration `env:"-"`
 }
 
 func Load() (Config, error) {
@@ -21,6 +39,19 @@ func Load() (Config, error) {
 	if err := env.Parse(&cfg); err != nil {
 		return cfg, err
 	}
+	// An alert evaluation interval of 0 disables alerting.
+	if cfg.AlertIntervalS < 0 || cfg.AlertWindowS <= 0 || cfg.AlertRepeatIntervalS <= 0 {
+		return cfg, fmt.Errorf("%w: ALERT_EVALUATION_INTERVAL_SECONDS must not be negative, ALERT_WINDOW_SECONDS and ALERT_REPEAT_INTERVAL_SECONDS must be positive", errorz.ErrInvalidConfig)
+	}
+	if cfg.RollupIntervalS <= 0 || cfg.RollupDelayS < 0 {
+		return cfg, fmt.Errorf("%w: ROLLUP_INTERVAL_SECONDS must be positive and ROLLUP_DELAY_SECONDS must not be negative", errorz.ErrInvalidConfig)
+	}
 	cfg.ShutdownTimeout = time.Duration(cfg.ShutdownTimeoutS) * time.Second
+	cfg.AlertInterval = time.Duration(cfg.AlertIntervalS) * time.Second
+	cfg.AlertWindow = time.Duration(cfg.AlertWindowS) * time.Second
+	cfg.AlertRepeatInterval = time.Duration(cfg.AlertRepeatIntervalS) * time.Second
+	cfg.RollupInterval = time.Duration(cfg.RollupIntervalS) * time.Second
+	cfg.RollupDelay = time.Duration(cfg.RollupDelayS) * time.Second
+	cfg.MapCacheTTL = time.Duration(cfg.MapCacheTTLS) * time.Second
 	return cfg, nil
 }
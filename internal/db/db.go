@@ -7,10 +7,9 @@ import (
 	"gorm.io/driver/clickhouse"
 	"gorm.io/gorm"
 	"gorm.io/plugin/opentelemetry/tracing"
-
-	"github.com/jack5341/otel-map-server/internal/models"
 )
 
+// Open connects to ClickHouse. The schema is managed by Migrate.
 func Open(dsn string) (*gorm.DB, error) {
 	gormDB, err := gorm.Open(clickhouse.Open(dsn), &gorm.Config{})
 	if err != nil {
@@ -36,11 +35,5 @@ func Open(dsn string) (*gorm.DB, error) {
 		panic(err)
 	}
 
-	// Skip OtelTrace auto-migration as it conflicts with ClickHouse schema
-	err = gormDB.AutoMigrate(&models.SessionToken{})
-	if err != nil {
-		return nil, err
-	}
-
 	return gormDB, nil
 }
@@ -0,0 +1,203 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationName matches migration files, e.g. 0001_session_tokens.sql.
+var migrationName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.sql$`)
+
+// statementEnd splits a migration into statements; each ends with a
+// semicolon at the end of a line.
+var statementEnd = regexp.MustCompile(`;[ \t]*(\r?\n|$)`)
+
+const createMigrationsTable = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version UInt32,
+    name String,
+    checksum String,
+    applied_at DateTime
+)
+ENGINE = MergeTree
+ORDER BY version
+`
+
+const getAppliedMigrationsQuery = `
+SELECT
+    version,
+    any(name) AS name,
+    any(checksum) AS checksum,
+    min(applied_at) AS applied_at
+FROM schema_migrations
+GROUP BY version
+ORDER BY version
+`
+
+// Migration is an up migration. Its statements must be idempotent: one that
+// fails part way is run again from the start.
+type Migration struct {
+	Version    uint32
+	Name       string
+	Checksum   string
+	statements []string
+}
+
+// MigrationStatus is a migration and when it was applied, if it was.
+type MigrationStatus struct {
+	Version   uint32     `json:"version"`
+	Name      string     `json:"name"`
+	Checksum  string     `json:"checksum"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+type appliedMigration struct {
+	Version   uint32
+	Name      string
+	Checksum  string
+	AppliedAt time.Time
+}
+
+// Migrations returns the embedded migrations in version order.
+func Migrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []Migration
+	seen := map[uint32]string{}
+	for _, entry := range entries {
+		match := migrationName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("%w: %s", errorz.ErrInvalidMigration, entry.Name())
+		}
+		version, err := strconv.ParseUint(match[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", errorz.ErrInvalidMigration, entry.Name())
+		}
+		if other, ok := seen[uint32(version)]; ok {
+			return nil, fmt.Errorf("%w: %s and %s share version %d", errorz.ErrInvalidMigration, other, entry.Name(), version)
+		}
+		seen[uint32(version)] = entry.Name()
+
+		content, err := migrationFiles.ReadFile("migrations/" + entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(content)
+		migrations = append(migrations, Migration{
+			Version:    uint32(version),
+			Name:       match[2],
+			Checksum:   hex.EncodeToString(sum[:]),
+			statements: splitStatements(string(content)),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// splitStatements splits SQL into statements, dropping those that hold only
+// comments.
+func splitStatements(sql string) []string {
+	var statements []string
+	for _, stmt := range statementEnd.Split(sql, -1) {
+		code := false
+		for _, line := range strings.Split(stmt, "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "--") {
+				code = true
+				break
+			}
+		}
+		if code {
+			statements = append(statements, strings.TrimSpace(stmt))
+		}
+	}
+	return statements
+}
+
+// Migrate applies the pending migrations in version order and returns them.
+// It refuses to run when an applied migration's file has changed since.
+func Migrate(ctx context.Context, gormDB *gorm.DB) ([]Migration, error) {
+	statuses, migrations, err := migrationStatuses(ctx, gormDB)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	for i, m := range migrations {
+		if statuses[i].AppliedAt != nil {
+			continue
+		}
+		for _, stmt := range m.statements {
+			if err := gormDB.WithContext(ctx).Exec(stmt).Error; err != nil {
+				return applied, errors.Join(fmt.Errorf("%w: %04d_%s", errorz.ErrWhileMigrating, m.Version, m.Name), err)
+			}
+		}
+		row := appliedMigration{Version: m.Version, Name: m.Name, Checksum: m.Checksum, AppliedAt: time.Now().UTC()}
+		if err := gormDB.WithContext(ctx).Table("schema_migrations").Create(&row).Error; err != nil {
+			return applied, errors.Join(errorz.ErrWhileMigrating, err)
+		}
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
+
+// MigrationStatuses lists the embedded migrations and when each was applied.
+func MigrationStatuses(ctx context.Context, gormDB *gorm.DB) ([]MigrationStatus, error) {
+	statuses, _, err := migrationStatuses(ctx, gormDB)
+	return statuses, err
+}
+
+func migrationStatuses(ctx context.Context, gormDB *gorm.DB) ([]MigrationStatus, []Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := gormDB.WithContext(ctx).Exec(createMigrationsTable).Error; err != nil {
+		return nil, nil, errors.Join(errorz.ErrWhileMigrating, err)
+	}
+
+	var rows []appliedMigration
+	if err := gormDB.WithContext(ctx).Raw(getAppliedMigrationsQuery).Scan(&rows).Error; err != nil {
+		return nil, nil, errors.Join(errorz.ErrWhileMigrating, err)
+	}
+	applied := make(map[uint32]appliedMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+
+	statuses := make([]MigrationStatus, len(migrations))
+	for i, m := range migrations {
+		statuses[i] = MigrationStatus{Version: m.Version, Name: m.Name, Checksum: m.Checksum}
+		row, ok := applied[m.Version]
+		if !ok {
+			continue
+		}
+		if row.Checksum != m.Checksum {
+			return nil, nil, fmt.Errorf("%w: %04d_%s", errorz.ErrMigrationChecksumMismatch, m.Version, m.Name)
+		}
+		appliedAt := row.AppliedAt
+		statuses[i].AppliedAt = &appliedAt
+	}
+	return statuses, migrations, nil
+}
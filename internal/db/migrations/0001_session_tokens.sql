@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS session_tokens (
+    token UUID,
+    created_at DateTime,
+    updated_at DateTime
+)
+ENGINE = MergeTree
+ORDER BY token;
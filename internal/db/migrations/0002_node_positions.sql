@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS node_positions (
+    token UUID,
+    algorithm String,
+    node String,
+    x Float64,
+    y Float64,
+    updated_at DateTime
+)
+ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY (token, algorithm, node);
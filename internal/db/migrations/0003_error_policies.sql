@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS error_policies (
+    token UUID,
+    http_server_errors Bool,
+    grpc_errors Bool,
+    exception_events Bool,
+    updated_at DateTime
+)
+ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY token;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS slos (
+    id UUID,
+    token UUID,
+    service_name String,
+    operation String,
+    availability_target Float64,
+    latency_threshold_ms Float64,
+    latency_target Float64,
+    deleted Bool,
+    updated_at DateTime
+)
+ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY (token, id);
@@ -0,0 +1,34 @@
+CREATE TABLE IF NOT EXISTS alert_rules (
+    id UUID,
+    token UUID,
+    name String,
+    kind String,
+    scope String,
+    target String,
+    quantile String,
+    threshold Float64,
+    min_requests UInt64,
+    for_seconds Int64,
+    receivers String,
+    deleted Bool,
+    updated_at DateTime
+)
+ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY (token, id);
+
+CREATE TABLE IF NOT EXISTS alert_states (
+    token UUID,
+    rule_id UUID,
+    instance String,
+    state String,
+    value Float64,
+    baseline Float64,
+    started_at DateTime,
+    fired_at DateTime,
+    resolved_at DateTime,
+    notified_at DateTime,
+    notified_state String,
+    updated_at DateTime64(3)
+)
+ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY (token, rule_id, instance);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS annotations (
+    id UUID,
+    token UUID,
+    kind String,
+    service_name String,
+    version String,
+    time DateTime64(3),
+    text String,
+    deleted Bool,
+    updated_at DateTime
+)
+ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY (token, id);
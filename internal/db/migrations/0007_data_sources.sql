@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS data_sources (
+    token UUID,
+    source String,
+    updated_at DateTime
+)
+ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY token;
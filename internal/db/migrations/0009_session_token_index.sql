@@ -0,0 +1,4 @@
+-- Every query is scoped to one session by this attribute. The index applies
+-- to parts written from now on; older parts are read in full until merged.
+ALTER TABLE default.otel_traces
+    ADD INDEX IF NOT EXISTS idx_session_token ResourceAttributes['otelmap.session_token'] TYPE bloom_filter(0.001) GRANULARITY 1;
@@ -0,0 +1,62 @@
+-- Per-minute stats read instead of raw spans. Duration digests are
+-- AggregatingMergeTree states merged with -Merge combinators. Edge stats are
+-- filled by the server's rollup, which relies on insert deduplication to
+-- roll a minute up only once.
+CREATE TABLE IF NOT EXISTS aggregate_coverage (
+    name String,
+    covered_from DateTime,
+    watermark DateTime,
+    updated_at DateTime
+)
+ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY name;
+
+CREATE TABLE IF NOT EXISTS service_stats_1m (
+    session_token String,
+    service_name String,
+    minute DateTime,
+    requests SimpleAggregateFunction(sum, UInt64),
+    errors SimpleAggregateFunction(sum, UInt64),
+    max_duration SimpleAggregateFunction(max, UInt64),
+    first_seen SimpleAggregateFunction(min, DateTime64(9)),
+    last_seen SimpleAggregateFunction(max, DateTime64(9)),
+    duration_digest AggregateFunction(quantilesTDigest(0.5, 0.9, 0.95, 0.99), UInt64)
+)
+ENGINE = AggregatingMergeTree
+ORDER BY (session_token, service_name, minute);
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS service_stats_1m_mv TO service_stats_1m AS
+SELECT
+    t.ResourceAttributes['otelmap.session_token'] AS session_token,
+    t.ServiceName AS service_name,
+    toStartOfMinute(t.Timestamp) AS minute,
+    toUInt64(count()) AS requests,
+    toUInt64(countIf(upper(t.StatusCode) IN ('2', 'ERROR', 'STATUS_CODE_ERROR'))) AS errors,
+    max(t.Duration) AS max_duration,
+    min(t.Timestamp) AS first_seen,
+    max(t.Timestamp) AS last_seen,
+    quantilesTDigestState(0.5, 0.9, 0.95, 0.99)(t.Duration) AS duration_digest
+FROM default.otel_traces AS t
+WHERE session_token != ''
+GROUP BY session_token, service_name, minute;
+
+CREATE TABLE IF NOT EXISTS edge_stats_1m (
+    session_token String,
+    source_service_name String,
+    target_service_name String,
+    target_service_path String,
+    minute DateTime,
+    requests SimpleAggregateFunction(sum, UInt64),
+    errors SimpleAggregateFunction(sum, UInt64),
+    max_duration SimpleAggregateFunction(max, UInt64),
+    duration_digest AggregateFunction(quantilesTDigest(0.5, 0.9, 0.95, 0.99), UInt64)
+)
+ENGINE = AggregatingMergeTree
+ORDER BY (session_token, source_service_name, target_service_name, target_service_path, minute)
+SETTINGS non_replicated_deduplication_window = 10000;
+
+-- The stats cover spans from the next full minute on.
+INSERT INTO aggregate_coverage
+SELECT name, toStartOfMinute(now()) + INTERVAL 1 MINUTE, toStartOfMinute(now()) + INTERVAL 1 MINUTE, now()
+FROM (SELECT arrayJoin(['service_stats_1m', 'edge_stats_1m']) AS name)
+WHERE name NOT IN (SELECT name FROM aggregate_coverage);
@@ -4,6 +4,7 @@ import "errors"
 
 var ErrErrorWileStartingOTel = errors.New("error while starting OTel")
 var ErrConfigNotFound = errors.New("config not found")
+var ErrInvalidConfig = errors.New("invalid config")
 var ErrServerError = errors.New("server error")
 var ErrDatabaseError = errors.New("database error")
 
@@ -11,6 +12,76 @@ var ErrSessionTokenRequired = errors.New("service map session token is required"
 var ErrSessionTokenNotFound = errors.New("session token not found")
 var ErrInvalidSessionToken = errors.New("invalid session token")
 var ErrWhileCreatingSessionToken = errors.New("error while creating session token")
+var ErrUnsupportedFormat = errors.New("unsupported format")
+var ErrUnsupportedLayout = errors.New("unsupported layout")
+var ErrImageTooLarge = errors.New("map too large to render as an image")
+var ErrInvalidGraphQuery = errors.New("invalid graph query")
+var ErrInvalidThreshold = errors.New("invalid threshold")
+var ErrInvalidAttributeKey = errors.New("invalid attribute key")
+var ErrInvalidFilter = errors.New("invalid filter expression")
+var ErrInvalidLimit = errors.New("invalid limit")
+var ErrInvalidQuantile = errors.New("invalid quantile")
+var ErrServiceNotFound = errors.New("service not found")
+var ErrVersionNotFound = errors.New("version not found")
 
 var ErrWhileGettingEdges = errors.New("error while getting edges")
 var ErrWhileGettingServicesWithMetrics = errors.New("error while getting services with metrics")
+var ErrWhileGettingEdgeMetrics = errors.New("error while getting edge metrics")
+var ErrWhileGettingCallPatterns = errors.New("error while getting call patterns")
+var ErrWhileGettingExceptions = errors.New("error while getting exceptions")
+
+var ErrWhileGettingServices = errors.New("error while getting services")
+var ErrWhileGettingOperations = errors.New("error while getting operations")
+var ErrWhileGettingTraces = errors.New("error while getting traces")
+var ErrTraceNotFound = errors.New("trace not found")
+var ErrInvalidTraceQuery = errors.New("invalid trace query")
+
+var ErrWhileGettingLayout = errors.New("error while getting layout")
+var ErrWhileSavingLayout = errors.New("error while saving layout")
+
+var ErrWhileGettingErrorPolicy = errors.New("error while getting error policy")
+var ErrWhileSavingErrorPolicy = errors.New("error while saving error policy")
+var ErrInvalidErrorPolicy = errors.New("invalid error policy")
+
+var ErrWhileGettingSLOs = errors.New("error while getting SLOs")
+var ErrWhileSavingSLO = errors.New("error while saving SLO")
+var ErrWhileEvaluatingSLO = errors.New("error while evaluating SLO")
+var ErrInvalidSLO = errors.New("invalid SLO")
+var ErrInvalidWindow = errors.New("invalid window")
+var ErrSLONotFound = errors.New("SLO not found")
+
+var ErrWhileGettingAlertRules = errors.New("error while getting alert rules")
+var ErrWhileSavingAlertRule = errors.New("error while saving alert rule")
+var ErrWhileGettingAlertStates = errors.New("error while getting alert states")
+var ErrWhileSavingAlertStates = errors.New("error while saving alert states")
+var ErrWhileSendingNotification = errors.New("error while sending notification")
+var ErrInvalidAlertRule = errors.New("invalid alert rule")
+var ErrAlertRuleNotFound = errors.New("alert rule not found")
+var ErrReceiverNotAllowed = errors.New("receiver address not allowed")
+
+var ErrWhileGettingSeries = errors.New("error while getting time series")
+var ErrInvalidStep = errors.New("invalid step")
+
+var ErrWhileGettingAnnotations = errors.New("error while getting annotations")
+var ErrWhileSavingAnnotation = errors.New("error while saving annotation")
+var ErrInvalidAnnotation = errors.New("invalid annotation")
+var ErrAnnotationNotFound = errors.New("annotation not found")
+
+var ErrWhileGettingErrorOrigins = errors.New("error while getting error origins")
+
+var ErrWhileGettingLogs = errors.New("error while getting logs")
+
+var ErrWhileGettingResourceMetrics = errors.New("error while getting resource metrics")
+var ErrMetricNotFound = errors.New("metric not found")
+
+var ErrWhileGettingDataSource = errors.New("error while getting data source")
+var ErrWhileSavingDataSource = errors.New("error while saving data source")
+var ErrInvalidDataSource = errors.New("invalid data source")
+var ErrWhileGettingSpanMetrics = errors.New("error while getting span metrics")
+
+var ErrWhileGettingAggregates = errors.New("error while getting aggregated stats")
+var ErrWhileSavingAggregates = errors.New("error while saving aggregated stats")
+
+var ErrWhileMigrating = errors.New("error while migrating")
+var ErrInvalidMigration = errors.New("invalid migration")
+var ErrMigrationChecksumMismatch = errors.New("applied migration has changed")
@@ -0,0 +1,93 @@
+package export
+
+import (
+	"fmt"
+
+	mapz "github.com/jack5341/otel-map-server/internal/mapz"
+)
+
+type CytoscapeNodeData struct {
+	ID            string            `json:"id"`
+	Label         string            `json:"label"`
+	Groups        map[string]string `json:"groups,omitempty"`
+	TotalRequests int64             `json:"total_requests"`
+	ErrorCount    int64             `json:"error_count"`
+	ErrorRate     float64           `json:"error_rate"`
+	LatencyP50Ms  float64           `json:"latency_p50_ms"`
+	LatencyP90Ms  float64           `json:"latency_p90_ms"`
+	LatencyP95Ms  float64           `json:"latency_p95_ms"`
+}
+
+type CytoscapeEdgeData struct {
+	ID                string  `json:"id"`
+	Source            string  `json:"source"`
+	Target            string  `json:"target"`
+	Label             string  `json:"label"`
+	Path              string  `json:"path"`
+	TotalRequests     uint64  `json:"total_requests"`
+	RequestsPerSecond float64 `json:"requests_per_second"`
+}
+
+type CytoscapeNode struct {
+	Data CytoscapeNodeData `json:"data"`
+}
+
+type CytoscapeEdge struct {
+	Data CytoscapeEdgeData `json:"data"`
+}
+
+// CytoscapeElements is the `elements` object accepted by cytoscape.js.
+type CytoscapeElements struct {
+	Nodes []CytoscapeNode `json:"nodes"`
+	Edges []CytoscapeEdge `json:"edges"`
+}
+
+type CytoscapeGraph struct {
+	Elements CytoscapeElements `json:"elements"`
+}
+
+// Cytoscape maps the service map into cytoscape.js elements. Node ids are the
+// node ids of the map, which are the service names unless nodes are split, so
+// styles and selectors can target services directly.
+func Cytoscape(services []mapz.Service, edges []mapz.Edge) CytoscapeGraph {
+	names, _ := nodeIDs(services, edges)
+	byName := servicesByName(services)
+
+	graph := CytoscapeGraph{Elements: CytoscapeElements{
+		Nodes: make([]CytoscapeNode, 0, len(names)),
+		Edges: make([]CytoscapeEdge, 0, len(edges)),
+	}}
+
+	for _, name := range names {
+		svc, ok := byName[name]
+		label := name
+		if ok {
+			label = svc.ServiceName
+		}
+		graph.Elements.Nodes = append(graph.Elements.Nodes, CytoscapeNode{Data: CytoscapeNodeData{
+			ID:            name,
+			Label:         label,
+			Groups:        svc.Groups,
+			TotalRequests: svc.TotalRequests,
+			ErrorCount:    svc.ErrorCount,
+			ErrorRate:     svc.ErrorRate,
+			LatencyP50Ms:  svc.LatencyP50Ms,
+			LatencyP90Ms:  svc.LatencyP90Ms,
+			LatencyP95Ms:  svc.LatencyP95Ms,
+		}})
+	}
+
+	for i, e := range edges {
+		graph.Elements.Edges = append(graph.Elements.Edges, CytoscapeEdge{Data: CytoscapeEdgeData{
+			ID:                fmt.Sprintf("e%d", i),
+			Source:            e.SourceNodeID(),
+			Target:            e.TargetNodeID(),
+			Label:             fmt.Sprintf("%s (%.2f rps)", e.TargetServicePath, e.RequestsPerSecond),
+			Path:              e.TargetServicePath,
+			TotalRequests:     e.TotalRequests,
+			RequestsPerSecond: e.RequestsPerSecond,
+		}})
+	}
+
+	return graph
+}
@@ -0,0 +1,44 @@
+package export
+
+import (
+	"fmt"
+	"strings"
+
+	mapz "github.com/jack5341/otel-map-server/internal/mapz"
+)
+
+// DOT renders the service map as a Graphviz digraph. Metrics are written both
+// into labels and as plain attributes so tooling can read them back.
+func DOT(services []mapz.Service, edges []mapz.Edge) []byte {
+	names, ids := nodeIDs(services, edges)
+	byName := servicesByName(services)
+
+	var b strings.Builder
+	b.WriteString("digraph service_map {\n")
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=box, style=rounded];\n")
+
+	for _, name := range names {
+		svc, ok := byName[name]
+		fmt.Fprintf(&b, "  %s [label=%s", ids[name], dotQuote(nodeLabel(name, svc, ok)))
+		if ok {
+			fmt.Fprintf(&b, ", total_requests=%d, error_count=%d, error_rate=%g, latency_p50_ms=%g, latency_p90_ms=%g, latency_p95_ms=%g",
+				svc.TotalRequests, svc.ErrorCount, svc.ErrorRate, svc.LatencyP50Ms, svc.LatencyP90Ms, svc.LatencyP95Ms)
+		}
+		b.WriteString("];\n")
+	}
+
+	for _, e := range edges {
+		fmt.Fprintf(&b, "  %s -> %s [label=%s, path=%s, total_requests=%d, requests_per_second=%g];\n",
+			ids[e.SourceNodeID()], ids[e.TargetNodeID()], dotQuote(edgeLabel(e)),
+			dotQuote(e.TargetServicePath), e.TotalRequests, e.RequestsPerSecond)
+	}
+
+	b.WriteString("}\n")
+	return []byte(b.String())
+}
+
+func dotQuote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + r.Replace(s) + `"`
+}
@@ -0,0 +1,111 @@
+package export
+
+import (
+	"fmt"
+	"strings"
+
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+	"github.com/jack5341/otel-map-server/internal/layout"
+	mapz "github.com/jack5341/otel-map-server/internal/mapz"
+)
+
+// Format identifies a service map output format.
+type Format string
+
+const (
+	FormatJSON      Format = "json"
+	FormatNodeGraph Format = "nodegraph"
+	FormatDOT       Format = "dot"
+	FormatMermaid   Format = "mermaid"
+	FormatGraphML   Format = "graphml"
+	FormatCytoscape Format = "cytoscape"
+	FormatSVG       Format = "svg"
+	FormatPNG       Format = "png"
+)
+
+// ContentTypes maps each text format to the media type it is served with and
+// accepted under for content negotiation.
+var ContentTypes = map[Format]string{
+	FormatDOT:       "text/vnd.graphviz",
+	FormatMermaid:   "text/vnd.mermaid",
+	FormatGraphML:   "application/graphml+xml",
+	FormatCytoscape: "application/vnd.cytoscape+json",
+	FormatSVG:       "image/svg+xml",
+	FormatPNG:       "image/png",
+}
+
+// nodeIDs assigns a stable, syntax-safe identifier to every map node, including
+// nodes only seen as an edge endpoint, in order of first appearance.
+func nodeIDs(services []mapz.Service, edges []mapz.Edge) ([]string, map[string]string) {
+	var names []string
+	ids := make(map[string]string)
+	add := func(name string) {
+		if _, ok := ids[name]; !ok {
+			ids[name] = fmt.Sprintf("n%d", len(names))
+			names = append(names, name)
+		}
+	}
+	for _, svc := range services {
+		add(svc.NodeID())
+	}
+	for _, e := range edges {
+		add(e.SourceNodeID())
+		add(e.TargetNodeID())
+	}
+	return names, ids
+}
+
+func servicesByName(services []mapz.Service) map[string]mapz.Service {
+	byName := make(map[string]mapz.Service, len(services))
+	for _, svc := range services {
+		byName[svc.NodeID()] = svc
+	}
+	return byName
+}
+
+func nodeLabel(name string, svc mapz.Service, ok bool) string {
+	if !ok {
+		return name
+	}
+	return fmt.Sprintf("%s\n%d req, %.2f%% err\np50 %.2fms p95 %.2fms",
+		name, svc.TotalRequests, svc.ErrorRate*100, svc.LatencyP50Ms, svc.LatencyP95Ms)
+}
+
+func edgeLabel(e mapz.Edge) string {
+	return fmt.Sprintf("%s\n%d req, %.2f rps", e.TargetServicePath, e.TotalRequests, e.RequestsPerSecond)
+}
+
+// Negotiate picks the output format from the explicit format parameter,
+// falling back to the first media type of the Accept header that maps to a
+// known format, and finally to JSON.
+func Negotiate(format, accept string) (Format, error) {
+	if format != "" {
+		switch f := Format(strings.ToLower(format)); f {
+		case FormatJSON, FormatNodeGraph, FormatDOT, FormatMermaid, FormatGraphML, FormatCytoscape, FormatSVG, FormatPNG:
+			return f, nil
+		default:
+			return "", errorz.ErrUnsupportedFormat
+		}
+	}
+
+	for _, part := range strings.Split(accept, ",") {
+		mediaType := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		for f, contentType := range ContentTypes {
+			if mediaType == contentType {
+				return f, nil
+			}
+		}
+	}
+	return FormatJSON, nil
+}
+
+// Graph converts the service map into layout input, keeping one layout edge
+// per map edge so routes line up with the edges slice.
+func Graph(services []mapz.Service, edges []mapz.Edge) layout.Graph {
+	names, _ := nodeIDs(services, edges)
+	g := layout.Graph{Nodes: names, Edges: make([][2]string, 0, len(edges))}
+	for _, e := range edges {
+		g.Edges = append(g.Edges, [2]string{e.SourceNodeID(), e.TargetNodeID()})
+	}
+	return g
+}
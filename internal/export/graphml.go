@@ -0,0 +1,111 @@
+package export
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+
+	mapz "github.com/jack5341/otel-map-server/internal/mapz"
+)
+
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID       string `xml:"id,attr"`
+	For      string `xml:"for,attr"`
+	AttrName string `xml:"attr.name,attr"`
+	AttrType string `xml:"attr.type,attr"`
+}
+
+type graphMLGraph struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	ID     string        `xml:"id,attr"`
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+var graphMLKeys = []graphMLKey{
+	{ID: "name", For: "node", AttrName: "name", AttrType: "string"},
+	{ID: "total_requests", For: "node", AttrName: "total_requests", AttrType: "long"},
+	{ID: "error_count", For: "node", AttrName: "error_count", AttrType: "long"},
+	{ID: "error_rate", For: "node", AttrName: "error_rate", AttrType: "double"},
+	{ID: "latency_p50_ms", For: "node", AttrName: "latency_p50_ms", AttrType: "double"},
+	{ID: "latency_p90_ms", For: "node", AttrName: "latency_p90_ms", AttrType: "double"},
+	{ID: "latency_p95_ms", For: "node", AttrName: "latency_p95_ms", AttrType: "double"},
+	{ID: "path", For: "edge", AttrName: "path", AttrType: "string"},
+	{ID: "edge_total_requests", For: "edge", AttrName: "total_requests", AttrType: "long"},
+	{ID: "requests_per_second", For: "edge", AttrName: "requests_per_second", AttrType: "double"},
+}
+
+// GraphML renders the service map as a GraphML document with node and edge
+// metrics declared as typed attributes.
+func GraphML(services []mapz.Service, edges []mapz.Edge) ([]byte, error) {
+	names, ids := nodeIDs(services, edges)
+	byName := servicesByName(services)
+
+	doc := graphML{
+		XMLNS: "http://graphml.graphdrawing.org/xmlns",
+		Keys:  graphMLKeys,
+		Graph: graphMLGraph{ID: "service_map", EdgeDefault: "directed"},
+	}
+
+	for _, name := range names {
+		node := graphMLNode{ID: ids[name], Data: []graphMLData{{Key: "name", Value: name}}}
+		if svc, ok := byName[name]; ok {
+			node.Data = append(node.Data,
+				graphMLData{Key: "total_requests", Value: strconv.FormatInt(svc.TotalRequests, 10)},
+				graphMLData{Key: "error_count", Value: strconv.FormatInt(svc.ErrorCount, 10)},
+				graphMLData{Key: "error_rate", Value: formatFloat(svc.ErrorRate)},
+				graphMLData{Key: "latency_p50_ms", Value: formatFloat(svc.LatencyP50Ms)},
+				graphMLData{Key: "latency_p90_ms", Value: formatFloat(svc.LatencyP90Ms)},
+				graphMLData{Key: "latency_p95_ms", Value: formatFloat(svc.LatencyP95Ms)},
+			)
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, node)
+	}
+
+	for i, e := range edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{
+			ID:     fmt.Sprintf("e%d", i),
+			Source: ids[e.SourceNodeID()],
+			Target: ids[e.TargetNodeID()],
+			Data: []graphMLData{
+				{Key: "path", Value: e.TargetServicePath},
+				{Key: "edge_total_requests", Value: strconv.FormatUint(e.TotalRequests, 10)},
+				{Key: "requests_per_second", Value: formatFloat(e.RequestsPerSecond)},
+			},
+		})
+	}
+
+	out, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
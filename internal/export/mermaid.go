@@ -0,0 +1,35 @@
+package export
+
+import (
+	"fmt"
+	"strings"
+
+	mapz "github.com/jack5341/otel-map-server/internal/mapz"
+)
+
+// Mermaid renders the service map as a Mermaid flowchart ready to be pasted
+// into Markdown.
+func Mermaid(services []mapz.Service, edges []mapz.Edge) []byte {
+	names, ids := nodeIDs(services, edges)
+	byName := servicesByName(services)
+
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+
+	for _, name := range names {
+		svc, ok := byName[name]
+		fmt.Fprintf(&b, "    %s[\"%s\"]\n", ids[name], mermaidEscape(nodeLabel(name, svc, ok)))
+	}
+
+	for _, e := range edges {
+		fmt.Fprintf(&b, "    %s -->|\"%s\"| %s\n",
+			ids[e.SourceNodeID()], mermaidEscape(edgeLabel(e)), ids[e.TargetNodeID()])
+	}
+
+	return []byte(b.String())
+}
+
+func mermaidEscape(s string) string {
+	r := strings.NewReplacer(`"`, "#quot;", "|", "#124;", "<", "#lt;", ">", "#gt;", "\n", "<br/>")
+	return r.Replace(s)
+}
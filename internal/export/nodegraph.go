@@ -0,0 +1,91 @@
+package export
+
+import (
+	mapz "github.com/jack5341/otel-map-server/internal/mapz"
+)
+
+// NodeGraphNode is a row of the nodes frame expected by Grafana's Node Graph panel.
+type NodeGraphNode struct {
+	ID                  string  `json:"id"`
+	Title               string  `json:"title"`
+	Subtitle            string  `json:"subtitle"`
+	MainStat            float64 `json:"mainstat"`
+	SecondaryStat       float64 `json:"secondarystat"`
+	ArcSuccess          float64 `json:"arc__success"`
+	ArcErrors           float64 `json:"arc__errors"`
+	DetailTotalRequests int64   `json:"detail__total_requests"`
+	DetailErrorCount    int64   `json:"detail__error_count"`
+	DetailLatencyP90Ms  float64 `json:"detail__latency_p90_ms"`
+	DetailLatencyP95Ms  float64 `json:"detail__latency_p95_ms"`
+}
+
+// NodeGraphEdge is a row of the edges frame expected by Grafana's Node Graph panel.
+type NodeGraphEdge struct {
+	ID            string  `json:"id"`
+	Source        string  `json:"source"`
+	Target        string  `json:"target"`
+	MainStat      float64 `json:"mainstat"`
+	SecondaryStat uint64  `json:"secondarystat"`
+	DetailPaths   int     `json:"detail__paths"`
+}
+
+// NodeGraphFrames holds both frames; with the Infinity datasource use
+// `nodes` and `edges` as the root selectors of two separate queries.
+type NodeGraphFrames struct {
+	Nodes []NodeGraphNode `json:"nodes"`
+	Edges []NodeGraphEdge `json:"edges"`
+}
+
+// NodeGraph maps services and edges into Node Graph frames. Node mainstat is
+// the p50 latency in milliseconds and secondarystat the request count; edge
+// mainstat is requests per second and secondarystat the request count. Edges
+// are collapsed per service pair since the panel cannot draw parallel edges.
+func NodeGraph(services []mapz.Service, edges []mapz.Edge) NodeGraphFrames {
+	frames := NodeGraphFrames{
+		Nodes: make([]NodeGraphNode, 0, len(services)),
+		Edges: make([]NodeGraphEdge, 0, len(edges)),
+	}
+
+	for _, svc := range services {
+		frames.Nodes = append(frames.Nodes, NodeGraphNode{
+			ID:                  svc.NodeID(),
+			Title:               svc.ServiceName,
+			Subtitle:            subtitle(svc),
+			MainStat:            svc.LatencyP50Ms,
+			SecondaryStat:       float64(svc.TotalRequests),
+			ArcSuccess:          1 - svc.ErrorRate,
+			ArcErrors:           svc.ErrorRate,
+			DetailTotalRequests: svc.TotalRequests,
+			DetailErrorCount:    svc.ErrorCount,
+			DetailLatencyP90Ms:  svc.LatencyP90Ms,
+			DetailLatencyP95Ms:  svc.LatencyP95Ms,
+		})
+	}
+
+	index := make(map[string]int)
+	for _, e := range edges {
+		id := e.SourceNodeID() + "->" + e.TargetNodeID()
+		i, ok := index[id]
+		if !ok {
+			i = len(frames.Edges)
+			index[id] = i
+			frames.Edges = append(frames.Edges, NodeGraphEdge{
+				ID:     id,
+				Source: e.SourceNodeID(),
+				Target: e.TargetNodeID(),
+			})
+		}
+		frames.Edges[i].MainStat += e.RequestsPerSecond
+		frames.Edges[i].SecondaryStat += e.TotalRequests
+		frames.Edges[i].DetailPaths++
+	}
+
+	return frames
+}
+
+func subtitle(svc mapz.Service) string {
+	if len(svc.Groups) == 0 {
+		return "service"
+	}
+	return mapz.GroupLabel(svc.Groups)
+}
@@ -0,0 +1,293 @@
+package graphz
+
+import (
+	"sort"
+
+	mapz "github.com/jack5341/otel-map-server/internal/mapz"
+)
+
+const (
+	DefaultMaxDepth = 10
+	DefaultMaxPaths = 100
+	// MaxDepth and MaxPaths bound what a request may ask for: path
+	// enumeration grows exponentially with depth on dense graphs.
+	MaxDepth = 10
+	MaxPaths = 100
+)
+
+// Link is the aggregated traffic from one service to another across all of
+// the target's paths.
+type Link struct {
+	Source            string  `json:"source"`
+	Target            string  `json:"target"`
+	TotalRequests     uint64  `json:"total_requests"`
+	RequestsPerSecond float64 `json:"requests_per_second"`
+}
+
+// Reachable is a service found by a traversal at its shortest distance from
+// the start service.
+type Reachable struct {
+	ServiceName string `json:"service_name"`
+	Depth       int    `json:"depth"`
+}
+
+type AffectedService struct {
+	ServiceName string `json:"service_name"`
+	Depth       int    `json:"depth"`
+	// RequestsPerSecond is the traffic this service sends towards the
+	// degraded service or towards other affected services.
+	RequestsPerSecond float64 `json:"requests_per_second"`
+	// Share is RequestsPerSecond divided by all outgoing traffic of the service.
+	Share float64 `json:"share"`
+}
+
+type Impact struct {
+	ServiceName string `json:"service_name"`
+	// Score is the total requests per second on calls that lead, directly or
+	// transitively, into the degraded service.
+	Score    float64           `json:"score"`
+	Affected []AffectedService `json:"affected"`
+}
+
+// Graph is a directed service graph built from mapz edges, keyed by node id.
+// Calls within the same node are ignored.
+type Graph struct {
+	out map[string]map[string]*Link
+	in  map[string]map[string]*Link
+}
+
+func New(edges []mapz.Edge) *Graph {
+	g := &Graph{out: map[string]map[string]*Link{}, in: map[string]map[string]*Link{}}
+	for _, e := range edges {
+		source, target := e.SourceNodeID(), e.TargetNodeID()
+		if source == target {
+			continue
+		}
+		if g.out[source] == nil {
+			g.out[source] = map[string]*Link{}
+		}
+		if g.in[target] == nil {
+			g.in[target] = map[string]*Link{}
+		}
+		link, ok := g.out[source][target]
+		if !ok {
+			link = &Link{Source: source, Target: target}
+			g.out[source][target] = link
+			g.in[target][source] = link
+		}
+		link.TotalRequests += e.TotalRequests
+		link.RequestsPerSecond += e.RequestsPerSecond
+	}
+	return g
+}
+
+// Downstream returns every service the given service calls, transitively, up
+// to maxDepth hops.
+func (g *Graph) Downstream(service string, maxDepth int) []Reachable {
+	return bfs(service, maxDepth, g.out)
+}
+
+// Upstream returns every service that calls the given service, transitively,
+// up to maxDepth hops.
+func (g *Graph) Upstream(service string, maxDepth int) []Reachable {
+	return bfs(service, maxDepth, g.in)
+}
+
+func bfs(start string, maxDepth int, adj map[string]map[string]*Link) []Reachable {
+	if maxDepth <= 0 {
+		maxDepth = DefaultMaxDepth
+	}
+
+	depth := map[string]int{start: 0}
+	queue := []string{start}
+	result := []Reachable{}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		if depth[n] == maxDepth {
+			continue
+		}
+		for _, m := range sortedKeys(adj[n]) {
+			if _, seen := depth[m]; seen {
+				continue
+			}
+			depth[m] = depth[n] + 1
+			result = append(result, Reachable{ServiceName: m, Depth: depth[m]})
+			queue = append(queue, m)
+		}
+	}
+	return result
+}
+
+// Paths returns up to maxPaths simple call paths from one service to another
+// that are at most maxDepth hops long, shortest first. Paths are searched
+// one length at a time, skipping services from which the target is too far
+// to be reached in the hops left.
+func (g *Graph) Paths(from, to string, maxDepth, maxPaths int) [][]string {
+	if maxDepth <= 0 {
+		maxDepth = DefaultMaxDepth
+	}
+	if maxPaths <= 0 {
+		maxPaths = DefaultMaxPaths
+	}
+
+	paths := [][]string{}
+	if from == to {
+		return paths
+	}
+	distance := map[string]int{to: 0}
+	for _, r := range g.Upstream(to, maxDepth) {
+		distance[r.ServiceName] = r.Depth
+	}
+	shortest, ok := distance[from]
+	if !ok {
+		return paths
+	}
+
+	onPath := map[string]bool{from: true}
+	path := []string{from}
+	var walk func(n string, length int)
+	walk = func(n string, length int) {
+		if len(paths) >= maxPaths {
+			return
+		}
+		hopsLeft := length - (len(path) - 1)
+		if n == to {
+			// Shorter paths were found by earlier lengths.
+			if hopsLeft == 0 {
+				paths = append(paths, append([]string(nil), path...))
+			}
+			return
+		}
+		for _, m := range sortedKeys(g.out[n]) {
+			if d, ok := distance[m]; !ok || d > hopsLeft-1 || onPath[m] {
+				continue
+			}
+			onPath[m] = true
+			path = append(path, m)
+			walk(m, length)
+			path = path[:len(path)-1]
+			onPath[m] = false
+		}
+	}
+	for length := shortest; length <= maxDepth && len(paths) < maxPaths; length++ {
+		walk(from, length)
+	}
+	return paths
+}
+
+// Impact estimates the blast radius of a degraded service: every upstream
+// service within maxDepth, weighted by the traffic it sends into the
+// affected part of the graph.
+func (g *Graph) Impact(service string, maxDepth int) Impact {
+	upstream := g.Upstream(service, maxDepth)
+	affected := map[string]bool{service: true}
+	for _, r := range upstream {
+		affected[r.ServiceName] = true
+	}
+
+	impact := Impact{ServiceName: service, Affected: []AffectedService{}}
+	for _, r := range upstream {
+		total, towards := 0.0, 0.0
+		for target, link := range g.out[r.ServiceName] {
+			total += link.RequestsPerSecond
+			if affected[target] {
+				towards += link.RequestsPerSecond
+			}
+		}
+		a := AffectedService{ServiceName: r.ServiceName, Depth: r.Depth, RequestsPerSecond: towards}
+		if total > 0 {
+			a.Share = towards / total
+		}
+		impact.Affected = append(impact.Affected, a)
+		impact.Score += towards
+	}
+
+	sort.SliceStable(impact.Affected, func(i, j int) bool {
+		return impact.Affected[i].RequestsPerSecond > impact.Affected[j].RequestsPerSecond
+	})
+	return impact
+}
+
+func sortedKeys(m map[string]*Link) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Cycles returns the strongly connected components with more than one
+// service, i.e. groups of services that depend on each other.
+func (g *Graph) Cycles() [][]string {
+	// Tarjan's algorithm.
+	index := map[string]int{}
+	lowlink := map[string]int{}
+	onStack := map[string]bool{}
+	var stack []string
+	var components [][]string
+	next := 0
+
+	var connect func(v string)
+	connect = func(v string) {
+		index[v], lowlink[v] = next, next
+		next++
+		stack = append(stack, v)
+		onStack[v] = true
+
+		for _, w := range sortedKeys(g.out[v]) {
+			if _, visited := index[w]; !visited {
+				connect(w)
+				lowlink[v] = min(lowlink[v], lowlink[w])
+			} else if onStack[w] {
+				lowlink[v] = min(lowlink[v], index[w])
+			}
+		}
+
+		if lowlink[v] == index[v] {
+			var component []string
+			for {
+				w := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[w] = false
+				component = append(component, w)
+				if w == v {
+					break
+				}
+			}
+			if len(component) > 1 {
+				sort.Strings(component)
+				components = append(components, component)
+			}
+		}
+	}
+	for _, n := range g.Services() {
+		if _, visited := index[n]; !visited {
+			connect(n)
+		}
+	}
+	return components
+}
+
+// Callees returns the services directly called by service, sorted by name.
+func (g *Graph) Callees(service string) []string {
+	return sortedKeys(g.out[service])
+}
+
+// Services returns every service that calls or is called by another one.
+func (g *Graph) Services() []string {
+	seen := map[string]bool{}
+	for s, targets := range g.out {
+		seen[s] = true
+		for t := range targets {
+			seen[t] = true
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for n := range seen {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
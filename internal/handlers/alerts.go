@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jack5341/otel-map-server/internal/alerting"
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+	"github.com/jack5341/otel-map-server/internal/models"
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+type AlertRulesResponse struct {
+	Rules []alerting.Rule `json:"rules"`
+}
+
+type AlertsResponse struct {
+	Alerts []models.AlertState `json:"alerts"`
+}
+
+// AlertsHandler manages the alert rules of a session and reports its alerts.
+type AlertsHandler struct {
+	db         *gorm.DB
+	otelTracer trace.Tracer
+}
+
+func NewAlertsHandler(db *gorm.DB, otelTracer trace.Tracer) *AlertsHandler {
+	return &AlertsHandler{db: db, otelTracer: otelTracer}
+}
+
+// List returns the pending, firing and recently resolved alerts of the
+// session's current rules.
+func (h *AlertsHandler) List(c echo.Context) error {
+	ctx, span := h.otelTracer.Start(c.Request().Context(), "AlertsHandler.List")
+	defer span.End()
+	sessionToken := c.Param("session-token")
+	if sessionToken == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrSessionTokenRequired.Error()})
+	}
+
+	token, err := uuid.Parse(sessionToken)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidSessionToken.Error()})
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	store := alerting.NewStore(h.db, h.otelTracer, dbCtx)
+	rules, err := store.Rules(token)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	states, err := store.States(token)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	current := map[uuid.UUID]bool{}
+	for _, rule := range rules {
+		current[rule.ID] = true
+	}
+	alerts := []models.AlertState{}
+	for _, s := range states {
+		if current[s.RuleID] && s.State != alerting.StateInactive {
+			alerts = append(alerts, s)
+		}
+	}
+
+	return c.JSON(http.StatusOK, AlertsResponse{Alerts: alerts})
+}
+
+func (h *AlertsHandler) ListRules(c echo.Context) error {
+	ctx, span := h.otelTracer.Start(c.Request().Context(), "AlertsHandler.ListRules")
+	defer span.End()
+	sessionToken := c.Param("session-token")
+	if sessionToken == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrSessionTokenRequired.Error()})
+	}
+
+	token, err := uuid.Parse(sessionToken)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidSessionToken.Error()})
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	rules, err := alerting.NewStore(h.db, h.otelTracer, dbCtx).Rules(token)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, AlertRulesResponse{Rules: rules})
+}
+
+// PutRule creates a rule, or replaces the one with the ID in the body.
+func (h *AlertsHandler) PutRule(c echo.Context) error {
+	ctx, span := h.otelTracer.Start(c.Request().Context(), "AlertsHandler.PutRule")
+	defer span.End()
+	sessionToken := c.Param("session-token")
+	if sessionToken == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrSessionTokenRequired.Error()})
+	}
+
+	token, err := uuid.Parse(sessionToken)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidSessionToken.Error()})
+	}
+
+	var rule alerting.Rule
+	if err := c.Bind(&rule); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidAlertRule.Error()})
+	}
+	if err := rule.Validate(); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	rule, err = alerting.NewStore(h.db, h.otelTracer, dbCtx).SaveRule(token, rule)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, rule)
+}
+
+func (h *AlertsHandler) DeleteRule(c echo.Context) error {
+	ctx, span := h.otelTracer.Start(c.Request().Context(), "AlertsHandler.DeleteRule")
+	defer span.End()
+	sessionToken := c.Param("session-token")
+	if sessionToken == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrSessionTokenRequired.Error()})
+	}
+
+	token, err := uuid.Parse(sessionToken)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidSessionToken.Error()})
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": errorz.ErrAlertRuleNotFound.Error()})
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	err = alerting.NewStore(h.db, h.otelTracer, dbCtx).DeleteRule(token, id)
+	if errors.Is(err, errorz.ErrAlertRuleNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
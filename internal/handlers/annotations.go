@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+	mapz "github.com/jack5341/otel-map-server/internal/mapz"
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+type AnnotationsResponse struct {
+	Annotations []mapz.Annotation `json:"annotations"`
+}
+
+// AnnotationsHandler manages the deploy, feature flag and incident markers
+// of a session.
+type AnnotationsHandler struct {
+	db         *gorm.DB
+	otelTracer trace.Tracer
+}
+
+func NewAnnotationsHandler(db *gorm.DB, otelTracer trace.Tracer) *AnnotationsHandler {
+	return &AnnotationsHandler{db: db, otelTracer: otelTracer}
+}
+
+// List returns the session's annotations, including detected version
+// changes, between the optional RFC 3339 from and to query parameters.
+func (h *AnnotationsHandler) List(c echo.Context) error {
+	ctx, span := h.otelTracer.Start(c.Request().Context(), "AnnotationsHandler.List")
+	defer span.End()
+	sessionToken := c.Param("session-token")
+	if sessionToken == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrSessionTokenRequired.Error()})
+	}
+
+	var _, err = uuid.Parse(sessionToken)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidSessionToken.Error()})
+	}
+
+	var from, to time.Time
+	for name, dst := range map[string]*time.Time{"from": &from, "to": &to} {
+		if v := c.QueryParam(name); v != "" {
+			if *dst, err = time.Parse(time.RFC3339, v); err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidWindow.Error()})
+			}
+		}
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	annotations, err := mapz.NewMapper(h.db, h.otelTracer, dbCtx).GetAnnotations(sessionToken, from, to)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, AnnotationsResponse{Annotations: annotations})
+}
+
+// Put creates an annotation, or replaces the one with the ID in the body.
+func (h *AnnotationsHandler) Put(c echo.Context) error {
+	ctx, span := h.otelTracer.Start(c.Request().Context(), "AnnotationsHandler.Put")
+	defer span.End()
+	sessionToken := c.Param("session-token")
+	if sessionToken == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrSessionTokenRequired.Error()})
+	}
+
+	var _, err = uuid.Parse(sessionToken)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidSessionToken.Error()})
+	}
+
+	var annotation mapz.Annotation
+	if err := c.Bind(&annotation); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidAnnotation.Error()})
+	}
+	if err := annotation.Validate(); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	annotation, err = mapz.NewMapper(h.db, h.otelTracer, dbCtx).SaveAnnotation(sessionToken, annotation)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, annotation)
+}
+
+func (h *AnnotationsHandler) Delete(c echo.Context) error {
+	ctx, span := h.otelTracer.Start(c.Request().Context(), "AnnotationsHandler.Delete")
+	defer span.End()
+	sessionToken := c.Param("session-token")
+	if sessionToken == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrSessionTokenRequired.Error()})
+	}
+
+	var _, err = uuid.Parse(sessionToken)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidSessionToken.Error()})
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": errorz.ErrAnnotationNotFound.Error()})
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	err = mapz.NewMapper(h.db, h.otelTracer, dbCtx).DeleteAnnotation(sessionToken, id)
+	if errors.Is(err, errorz.ErrAnnotationNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+	mapz "github.com/jack5341/otel-map-server/internal/mapz"
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// DataSourceRequest selects where a session's map metrics are read from:
+// auto, spans or span_metrics.
+type DataSourceRequest struct {
+	Source string `json:"source"`
+}
+
+// DataSourceResponse reports the configured source and the one requests
+// without map options currently resolve to.
+type DataSourceResponse struct {
+	Source   string `json:"source"`
+	Resolved string `json:"resolved"`
+}
+
+// DataSourceHandler reads and updates the data source of a session's map.
+type DataSourceHandler struct {
+	db         *gorm.DB
+	otelTracer trace.Tracer
+}
+
+func NewDataSourceHandler(db *gorm.DB, otelTracer trace.Tracer) *DataSourceHandler {
+	return &DataSourceHandler{db: db, otelTracer: otelTracer}
+}
+
+func (h *DataSourceHandler) Get(c echo.Context) error {
+	ctx, span := h.otelTracer.Start(c.Request().Context(), "DataSourceHandler.Get")
+	defer span.End()
+	sessionToken := c.Param("session-token")
+	if sessionToken == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrSessionTokenRequired.Error()})
+	}
+
+	var _, err = uuid.Parse(sessionToken)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidSessionToken.Error()})
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	mapper := mapz.NewMapper(h.db, h.otelTracer, dbCtx)
+	source, err := mapper.GetDataSource(sessionToken)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	resolved, err := mapper.DataSource(sessionToken)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, DataSourceResponse{Source: source, Resolved: resolved})
+}
+
+// Put replaces the session's data source. It applies from the next request
+// on.
+func (h *DataSourceHandler) Put(c echo.Context) error {
+	ctx, span := h.otelTracer.Start(c.Request().Context(), "DataSourceHandler.Put")
+	defer span.End()
+	sessionToken := c.Param("session-token")
+	if sessionToken == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrSessionTokenRequired.Error()})
+	}
+
+	var _, err = uuid.Parse(sessionToken)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidSessionToken.Error()})
+	}
+
+	var req DataSourceRequest
+	if err := c.Bind(&req); err != nil || !mapz.ValidDataSource(req.Source) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidDataSource.Error()})
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	mapper := mapz.NewMapper(h.db, h.otelTracer, dbCtx)
+	if err := mapper.SaveDataSource(sessionToken, req.Source); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	resolved, err := mapper.WithOptions(mapz.Options{Source: req.Source}).DataSource(sessionToken)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, DataSourceResponse{Source: req.Source, Resolved: resolved})
+}
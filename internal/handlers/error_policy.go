@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+	mapz "github.com/jack5341/otel-map-server/internal/mapz"
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// ErrorPolicyHandler reads and updates which spans a session counts as errors.
+type ErrorPolicyHandler struct {
+	db         *gorm.DB
+	otelTracer trace.Tracer
+}
+
+func NewErrorPolicyHandler(db *gorm.DB, otelTracer trace.Tracer) *ErrorPolicyHandler {
+	return &ErrorPolicyHandler{db: db, otelTracer: otelTracer}
+}
+
+func (h *ErrorPolicyHandler) Get(c echo.Context) error {
+	ctx, span := h.otelTracer.Start(c.Request().Context(), "ErrorPolicyHandler.Get")
+	defer span.End()
+	sessionToken := c.Param("session-token")
+	if sessionToken == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrSessionTokenRequired.Error()})
+	}
+
+	var _, err = uuid.Parse(sessionToken)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidSessionToken.Error()})
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	policy, err := mapz.NewMapper(h.db, h.otelTracer, dbCtx).GetErrorPolicy(sessionToken)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, policy)
+}
+
+// Put replaces the session's error policy. It applies to node and edge
+// metrics from the next request on.
+func (h *ErrorPolicyHandler) Put(c echo.Context) error {
+	ctx, span := h.otelTracer.Start(c.Request().Context(), "ErrorPolicyHandler.Put")
+	defer span.End()
+	sessionToken := c.Param("session-token")
+	if sessionToken == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrSessionTokenRequired.Error()})
+	}
+
+	var _, err = uuid.Parse(sessionToken)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidSessionToken.Error()})
+	}
+
+	var policy mapz.ErrorPolicy
+	if err := c.Bind(&policy); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidErrorPolicy.Error()})
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	if err := mapz.NewMapper(h.db, h.otelTracer, dbCtx).SaveErrorPolicy(sessionToken, policy); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, policy)
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jack5341/otel-map-server/internal/analysis"
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+	mapz "github.com/jack5341/otel-map-server/internal/mapz"
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const defaultExceptionLimit = 10
+
+type ErrorsHandler struct {
+	db         *gorm.DB
+	otelTracer trace.Tracer
+}
+
+func NewErrorsHandler(db *gorm.DB, otelTracer trace.Tracer) *ErrorsHandler {
+	return &ErrorsHandler{db: db, otelTracer: otelTracer}
+}
+
+// Get reports the top exception groups and the HTTP status and gRPC code
+// breakdowns per service and per edge. The limit query parameter caps the
+// exception groups returned for each service and edge.
+func (h *ErrorsHandler) Get(c echo.Context) error {
+	ctx, span := h.otelTracer.Start(c.Request().Context(), "ErrorsHandler.Get")
+	defer span.End()
+	sessionToken := c.Param("session-token")
+	if sessionToken == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrSessionTokenRequired.Error()})
+	}
+
+	var _, err = uuid.Parse(sessionToken)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidSessionToken.Error()})
+	}
+
+	limit, err := queryInt(c, "limit", defaultExceptionLimit)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidLimit.Error()})
+	}
+	filter, err := mapz.ParseFilter(c.QueryParam("filter"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	mapper := mapz.NewMapper(h.db, h.otelTracer, dbCtx).WithOptions(mapz.Options{Filter: filter})
+	var in analysis.ErrorsInput
+	if in.ServiceExceptions, err = mapper.GetServiceExceptions(sessionToken, limit); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	if in.EdgeExceptions, err = mapper.GetEdgeExceptions(sessionToken, limit); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	if in.ServiceStatusCodes, err = mapper.GetServiceStatusCodes(sessionToken); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	if in.EdgeStatusCodes, err = mapper.GetEdgeStatusCodes(sessionToken); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, analysis.Errors(in))
+}
+
+// RootCauses ranks the services and operations most likely to have started
+// the session's failures, with example traces. The window query parameter,
+// e.g. window=15m, only considers recent spans; limit caps each ranking.
+func (h *ErrorsHandler) RootCauses(c echo.Context) error {
+	ctx, span := h.otelTracer.Start(c.Request().Context(), "ErrorsHandler.RootCauses")
+	defer span.End()
+	sessionToken := c.Param("session-token")
+	if sessionToken == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrSessionTokenRequired.Error()})
+	}
+
+	var _, err = uuid.Parse(sessionToken)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidSessionToken.Error()})
+	}
+
+	limit, err := queryInt(c, "limit", defaultExceptionLimit)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidLimit.Error()})
+	}
+	filter, err := mapz.ParseFilter(c.QueryParam("filter"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	opts := mapz.Options{Filter: filter}
+	if v := c.QueryParam("window"); v != "" {
+		windows, err := mapz.ParseWindows(v)
+		if err != nil || len(windows) != 1 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidWindow.Error()})
+		}
+		opts.Since = time.Now().Add(-windows[0])
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	mapper := mapz.NewMapper(h.db, h.otelTracer, dbCtx).WithOptions(opts)
+	origins, err := mapper.GetErrorOrigins(sessionToken)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	failedTraces, err := mapper.GetFailedTraceCount(sessionToken)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, analysis.RootCauses(origins, failedTraces, limit))
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jack5341/otel-map-server/internal/analysis"
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+	mapz "github.com/jack5341/otel-map-server/internal/mapz"
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+type FindingsResponse struct {
+	Findings []analysis.Finding `json:"findings"`
+}
+
+type FindingsHandler struct {
+	db         *gorm.DB
+	otelTracer trace.Tracer
+}
+
+func NewFindingsHandler(db *gorm.DB, otelTracer trace.Tracer) *FindingsHandler {
+	return &FindingsHandler{db: db, otelTracer: otelTracer}
+}
+
+// Get runs the anti-pattern analysis for a session. Thresholds can be tuned
+// with the fan_out, chatty, n_plus_one, depth and sample query parameters.
+func (h *FindingsHandler) Get(c echo.Context) error {
+	ctx, span := h.otelTracer.Start(c.Request().Context(), "FindingsHandler.Get")
+	defer span.End()
+	sessionToken := c.Param("session-token")
+	if sessionToken == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrSessionTokenRequired.Error()})
+	}
+
+	var _, err = uuid.Parse(sessionToken)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidSessionToken.Error()})
+	}
+
+	thresholds, err := parseThresholds(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidThreshold.Error()})
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	mapper := mapz.NewMapper(h.db, h.otelTracer, dbCtx)
+	var in analysis.Input
+	if in.Edges, err = mapper.GetEdges(sessionToken); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	if in.CallStats, err = mapper.GetEdgeCallStats(sessionToken); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	if in.Repeats, err = mapper.GetRepeatedChildSpans(sessionToken, thresholds.NPlusOneRepeats); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	if in.Spans, err = mapper.GetRecentSpanLinks(sessionToken, thresholds.SampleTraces); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, FindingsResponse{Findings: analysis.Analyze(in, thresholds)})
+}
+
+func parseThresholds(c echo.Context) (analysis.Thresholds, error) {
+	t := analysis.DefaultThresholds()
+	ints := map[string]*int{
+		"fan_out":    &t.FanOut,
+		"n_plus_one": &t.NPlusOneRepeats,
+		"depth":      &t.ChainDepth,
+		"sample":     &t.SampleTraces,
+	}
+	for name, dst := range ints {
+		if v := c.QueryParam(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 || (name == "sample" && n > analysis.MaxSampleTraces) {
+				return t, errorz.ErrInvalidThreshold
+			}
+			*dst = n
+		}
+	}
+	if v := c.QueryParam("chatty"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f <= 0 {
+			return t, errorz.ErrInvalidThreshold
+		}
+		t.ChattyCallsPerTrace = f
+	}
+	return t, nil
+}
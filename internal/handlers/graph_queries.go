@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+	"github.com/jack5341/otel-map-server/internal/graphz"
+	mapz "github.com/jack5341/otel-map-server/internal/mapz"
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+type TraversalResponse struct {
+	ServiceName string             `json:"service_name"`
+	Direction   string             `json:"direction"`
+	MaxDepth    int                `json:"max_depth"`
+	Services    []graphz.Reachable `json:"services"`
+}
+
+type PathsResponse struct {
+	From     string     `json:"from"`
+	To       string     `json:"to"`
+	MaxDepth int        `json:"max_depth"`
+	Paths    [][]string `json:"paths"`
+}
+
+// GraphQueryHandler answers dependency questions over the computed service map.
+type GraphQueryHandler struct {
+	db         *gorm.DB
+	otelTracer trace.Tracer
+}
+
+func NewGraphQueryHandler(db *gorm.DB, otelTracer trace.Tracer) *GraphQueryHandler {
+	return &GraphQueryHandler{db: db, otelTracer: otelTracer}
+}
+
+func (h *GraphQueryHandler) Upstream(c echo.Context) error {
+	ctx, span := h.otelTracer.Start(c.Request().Context(), "GraphQueryHandler.Upstream")
+	defer span.End()
+	return h.traverse(c, ctx, "upstream")
+}
+
+func (h *GraphQueryHandler) Downstream(c echo.Context) error {
+	ctx, span := h.otelTracer.Start(c.Request().Context(), "GraphQueryHandler.Downstream")
+	defer span.End()
+	return h.traverse(c, ctx, "downstream")
+}
+
+func (h *GraphQueryHandler) traverse(c echo.Context, ctx context.Context, direction string) error {
+	depth, err := queryIntAtMost(c, "depth", graphz.DefaultMaxDepth, graphz.MaxDepth)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidGraphQuery.Error()})
+	}
+
+	g, status, err := h.graph(c, ctx)
+	if err != nil {
+		return c.JSON(status, map[string]string{"error": err.Error()})
+	}
+
+	service := c.Param("service")
+	resp := TraversalResponse{ServiceName: service, Direction: direction, MaxDepth: depth}
+	if direction == "upstream" {
+		resp.Services = g.Upstream(service, depth)
+	} else {
+		resp.Services = g.Downstream(service, depth)
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (h *GraphQueryHandler) Impact(c echo.Context) error {
+	ctx, span := h.otelTracer.Start(c.Request().Context(), "GraphQueryHandler.Impact")
+	defer span.End()
+
+	depth, err := queryIntAtMost(c, "depth", graphz.DefaultMaxDepth, graphz.MaxDepth)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidGraphQuery.Error()})
+	}
+
+	g, status, err := h.graph(c, ctx)
+	if err != nil {
+		return c.JSON(status, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, g.Impact(c.Param("service"), depth))
+}
+
+func (h *GraphQueryHandler) Paths(c echo.Context) error {
+	ctx, span := h.otelTracer.Start(c.Request().Context(), "GraphQueryHandler.Paths")
+	defer span.End()
+
+	from, to := c.QueryParam("from"), c.QueryParam("to")
+	depth, depthErr := queryIntAtMost(c, "depth", graphz.DefaultMaxDepth, graphz.MaxDepth)
+	limit, limitErr := queryIntAtMost(c, "limit", graphz.DefaultMaxPaths, graphz.MaxPaths)
+	if from == "" || to == "" || depthErr != nil || limitErr != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidGraphQuery.Error()})
+	}
+
+	g, status, err := h.graph(c, ctx)
+	if err != nil {
+		return c.JSON(status, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, PathsResponse{
+		From:     from,
+		To:       to,
+		MaxDepth: depth,
+		Paths:    g.Paths(from, to, depth, limit),
+	})
+}
+
+// graph validates the session token and builds the service graph from its
+// edges, returning the HTTP status to respond with on failure.
+func (h *GraphQueryHandler) graph(c echo.Context, ctx context.Context) (*graphz.Graph, int, error) {
+	sessionToken := c.Param("session-token")
+	if sessionToken == "" {
+		return nil, http.StatusBadRequest, errorz.ErrSessionTokenRequired
+	}
+	if _, err := uuid.Parse(sessionToken); err != nil {
+		return nil, http.StatusBadRequest, errorz.ErrInvalidSessionToken
+	}
+	opts, err := mapOptions(c)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	edges, err := mapz.NewMapper(h.db, h.otelTracer, dbCtx).WithOptions(opts).GetEdges(sessionToken)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return graphz.New(edges), http.StatusOK, nil
+}
+
+func queryInt(c echo.Context, name string, fallback int) (int, error) {
+	v := c.QueryParam(name)
+	if v == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return 0, errorz.ErrInvalidGraphQuery
+	}
+	return n, nil
+}
+
+// queryIntAtMost is queryInt rejecting values above limit.
+func queryIntAtMost(c echo.Context, name string, fallback, limit int) (int, error) {
+	n, err := queryInt(c, name, fallback)
+	if err != nil || n > limit {
+		return 0, errorz.ErrInvalidGraphQuery
+	}
+	return n, nil
+}
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+	"github.com/jack5341/otel-map-server/internal/jaeger"
+	mapz "github.com/jack5341/otel-map-server/internal/mapz"
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// SessionHeader carries the session token for clients that cannot put it in
+// the path, e.g. a Jaeger UI behind a header-injecting proxy.
+const SessionHeader = "X-OTEL-SESSION"
+
+// JaegerHandler implements the read endpoints of the Jaeger query API used
+// by Jaeger UI, scoped to a single session.
+type JaegerHandler struct {
+	db         *gorm.DB
+	otelTracer trace.Tracer
+}
+
+func NewJaegerHandler(db *gorm.DB, otelTracer trace.Tracer) *JaegerHandler {
+	return &JaegerHandler{db: db, otelTracer: otelTracer}
+}
+
+func (h *JaegerHandler) Services(c echo.Context) error {
+	ctx, span := h.otelTracer.Start(c.Request().Context(), "JaegerHandler.Services")
+	defer span.End()
+	sessionToken, err := jaegerSessionToken(c)
+	if err != nil {
+		return jaegerError(c, http.StatusBadRequest, err)
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	services, err := jaeger.NewReader(h.db, h.otelTracer, dbCtx).GetServices(sessionToken)
+	if err != nil {
+		return jaegerError(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, jaeger.Response{Data: services, Total: len(services)})
+}
+
+// Operations serves both /api/services/:service/operations (names only) and
+// /api/operations?service=&spanKind= (names with span kinds).
+func (h *JaegerHandler) Operations(c echo.Context) error {
+	ctx, span := h.otelTracer.Start(c.Request().Context(), "JaegerHandler.Operations")
+	defer span.End()
+	sessionToken, err := jaegerSessionToken(c)
+	if err != nil {
+		return jaegerError(c, http.StatusBadRequest, err)
+	}
+
+	service := c.Param("service")
+	namesOnly := service != ""
+	if !namesOnly {
+		service = c.QueryParam("service")
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	operations, err := jaeger.NewReader(h.db, h.otelTracer, dbCtx).GetOperations(sessionToken, service, c.QueryParam("spanKind"))
+	if err != nil {
+		return jaegerError(c, http.StatusInternalServerError, err)
+	}
+
+	if namesOnly {
+		seen := make(map[string]bool, len(operations))
+		names := []string{}
+		for _, op := range operations {
+			if !seen[op.Name] {
+				seen[op.Name] = true
+				names = append(names, op.Name)
+			}
+		}
+		return c.JSON(http.StatusOK, jaeger.Response{Data: names, Total: len(names)})
+	}
+
+	return c.JSON(http.StatusOK, jaeger.Response{Data: operations, Total: len(operations)})
+}
+
+func (h *JaegerHandler) FindTraces(c echo.Context) error {
+	ctx, span := h.otelTracer.Start(c.Request().Context(), "JaegerHandler.FindTraces")
+	defer span.End()
+	sessionToken, err := jaegerSessionToken(c)
+	if err != nil {
+		return jaegerError(c, http.StatusBadRequest, err)
+	}
+
+	query, err := parseTraceQuery(c)
+	if err != nil {
+		return jaegerError(c, http.StatusBadRequest, errors.Join(errorz.ErrInvalidTraceQuery, err))
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	traces, err := jaeger.NewReader(h.db, h.otelTracer, dbCtx).FindTraces(sessionToken, query)
+	if err != nil {
+		return jaegerError(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, jaeger.Response{Data: traces, Total: len(traces), Limit: query.Limit})
+}
+
+func (h *JaegerHandler) GetTrace(c echo.Context) error {
+	ctx, span := h.otelTracer.Start(c.Request().Context(), "JaegerHandler.GetTrace")
+	defer span.End()
+	sessionToken, err := jaegerSessionToken(c)
+	if err != nil {
+		return jaegerError(c, http.StatusBadRequest, err)
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	traceID := c.Param("trace-id")
+	t, err := jaeger.NewReader(h.db, h.otelTracer, dbCtx).GetTrace(sessionToken, traceID)
+	if errors.Is(err, errorz.ErrTraceNotFound) {
+		return c.JSON(http.StatusNotFound, jaeger.Response{Errors: []jaeger.ResponseError{{Code: http.StatusNotFound, Msg: err.Error(), TraceID: traceID}}})
+	}
+	if err != nil {
+		return jaegerError(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, jaeger.Response{Data: []jaeger.Trace{*t}, Total: 1})
+}
+
+func (h *JaegerHandler) Dependencies(c echo.Context) error {
+	ctx, span := h.otelTracer.Start(c.Request().Context(), "JaegerHandler.Dependencies")
+	defer span.End()
+	sessionToken, err := jaegerSessionToken(c)
+	if err != nil {
+		return jaegerError(c, http.StatusBadRequest, err)
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	edges, err := mapz.NewMapper(h.db, h.otelTracer, dbCtx).GetEdges(sessionToken)
+	if err != nil {
+		return jaegerError(c, http.StatusInternalServerError, err)
+	}
+
+	deps := jaeger.Dependencies(edges)
+	return c.JSON(http.StatusOK, jaeger.Response{Data: deps, Total: len(deps)})
+}
+
+// jaegerSessionToken reads the session token from the path prefix and falls
+// back to the session header.
+func jaegerSessionToken(c echo.Context) (string, error) {
+	sessionToken := c.Param("session-token")
+	if sessionToken == "" {
+		sessionToken = c.Request().Header.Get(SessionHeader)
+	}
+	if sessionToken == "" {
+		return "", errorz.ErrSessionTokenRequired
+	}
+	if _, err := uuid.Parse(sessionToken); err != nil {
+		return "", errorz.ErrInvalidSessionToken
+	}
+	return sessionToken, nil
+}
+
+func jaegerError(c echo.Context, status int, err error) error {
+	return c.JSON(status, jaeger.Response{Errors: []jaeger.ResponseError{{Code: status, Msg: err.Error()}}})
+}
+
+// parseTraceQuery reads the query parameters sent by Jaeger UI: start and end
+// are microseconds since epoch, durations use Go duration syntax and tags is
+// a JSON object.
+func parseTraceQuery(c echo.Context) (jaeger.TraceQuery, error) {
+	q := jaeger.TraceQuery{
+		Service:   c.QueryParam("service"),
+		Operation: c.QueryParam("operation"),
+	}
+
+	if v := c.QueryParam("start"); v != "" {
+		us, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return q, err
+		}
+		q.Start = time.UnixMicro(us)
+	}
+	if v := c.QueryParam("end"); v != "" {
+		us, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return q, err
+		}
+		q.End = time.UnixMicro(us)
+	}
+	if v := c.QueryParam("minDuration"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return q, err
+		}
+		q.MinDuration = d
+	}
+	if v := c.QueryParam("maxDuration"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return q, err
+		}
+		q.MaxDuration = d
+	}
+	if v := c.QueryParam("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			return q, err
+		}
+		q.Limit = limit
+	}
+	if v := c.QueryParam("tags"); v != "" {
+		if err := json.Unmarshal([]byte(v), &q.Tags); err != nil {
+			return q, err
+		}
+	}
+
+	return q, nil
+}
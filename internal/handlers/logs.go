@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+	mapz "github.com/jack5341/otel-map-server/internal/mapz"
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const defaultLogLimit = 100
+
+type LogsResponse struct {
+	Logs []mapz.LogRecord `json:"logs"`
+}
+
+// LogsHandler serves the log records the collector stores alongside spans.
+type LogsHandler struct {
+	db         *gorm.DB
+	otelTracer trace.Tracer
+}
+
+func NewLogsHandler(db *gorm.DB, otelTracer trace.Tracer) *LogsHandler {
+	return &LogsHandler{db: db, otelTracer: otelTracer}
+}
+
+// Trace returns the logs written in a trace, oldest first.
+func (h *LogsHandler) Trace(c echo.Context) error {
+	ctx, span := h.otelTracer.Start(c.Request().Context(), "LogsHandler.Trace")
+	defer span.End()
+	sessionToken := c.Param("session-token")
+	if sessionToken == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrSessionTokenRequired.Error()})
+	}
+
+	var _, err = uuid.Parse(sessionToken)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidSessionToken.Error()})
+	}
+
+	limit, err := queryInt(c, "limit", defaultLogLimit)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidLimit.Error()})
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	traceID := strings.ToLower(c.Param("trace-id"))
+	logs, err := mapz.NewMapper(h.db, h.otelTracer, dbCtx).GetTraceLogs(sessionToken, traceID, limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, LogsResponse{Logs: logs})
+}
+
+// Service returns the logs of a service, newest first. The window (e.g. 15m)
+// or RFC 3339 from and to query parameters bound them; errors=true keeps
+// severity ERROR and above.
+func (h *LogsHandler) Service(c echo.Context) error {
+	ctx, span := h.otelTracer.Start(c.Request().Context(), "LogsHandler.Service")
+	defer span.End()
+	sessionToken := c.Param("session-token")
+	if sessionToken == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrSessionTokenRequired.Error()})
+	}
+
+	var _, err = uuid.Parse(sessionToken)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidSessionToken.Error()})
+	}
+
+	q := mapz.LogQuery{ServiceName: c.Param("service")}
+	if q.Limit, err = queryInt(c, "limit", defaultLogLimit); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidLimit.Error()})
+	}
+	for name, dst := range map[string]*time.Time{"from": &q.From, "to": &q.To} {
+		if v := c.QueryParam(name); v != "" {
+			if *dst, err = time.Parse(time.RFC3339, v); err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidWindow.Error()})
+			}
+		}
+	}
+	if v := c.QueryParam("window"); v != "" {
+		windows, err := mapz.ParseWindows(v)
+		if err != nil || len(windows) != 1 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidWindow.Error()})
+		}
+		q.From = time.Now().Add(-windows[0])
+	}
+	q.ErrorsOnly, _ = strconv.ParseBool(c.QueryParam("errors"))
+
+	dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	logs, err := mapz.NewMapper(h.db, h.otelTracer, dbCtx).GetServiceLogs(sessionToken, q)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, LogsResponse{Logs: logs})
+}
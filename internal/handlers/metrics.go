@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+	mapz "github.com/jack5341/otel-map-server/internal/mapz"
+	"github.com/jack5341/otel-map-server/internal/metrics"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+type MetricsHandler struct {
+	db         *gorm.DB
+	otelTracer trace.Tracer
+}
+
+func NewMetricsHandler(db *gorm.DB, otelTracer trace.Tracer) *MetricsHandler {
+	return &MetricsHandler{db: db, otelTracer: otelTracer}
+}
+
+// Server exposes the server's own HTTP and query metrics.
+func (h *MetricsHandler) Server(c echo.Context) error {
+	promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{}).ServeHTTP(c.Response(), c.Request())
+	return nil
+}
+
+// ServiceGraph exposes the service graph of a session in the shape of
+// Tempo's service-graph connector so it can be scraped by Prometheus.
+func (h *MetricsHandler) ServiceGraph(c echo.Context) error {
+	ctx, span := h.otelTracer.Start(c.Request().Context(), "MetricsHandler.ServiceGraph")
+	defer span.End()
+	sessionToken := c.Param("session-token")
+	if sessionToken == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrSessionTokenRequired.Error()})
+	}
+
+	var _, err = uuid.Parse(sessionToken)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidSessionToken.Error()})
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	mapper := mapz.NewMapper(h.db, h.otelTracer, dbCtx)
+	services, err := mapper.GetServicesWithMetrics(sessionToken)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	edges, err := mapper.GetEdgeMetrics(sessionToken)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(&serviceGraphCollector{services: services, edges: edges}); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(c.Response(), c.Request())
+	return nil
+}
+
+var (
+	serviceGraphRequestTotal = prometheus.NewDesc(
+		"traces_service_graph_request_total",
+		"Total count of requests between two nodes.",
+		[]string{"client", "server", "connection_type"}, nil,
+	)
+	serviceGraphRequestFailedTotal = prometheus.NewDesc(
+		"traces_service_graph_request_failed_total",
+		"Total count of failed requests between two nodes.",
+		[]string{"client", "server", "connection_type"}, nil,
+	)
+	serviceGraphRequestServerSeconds = prometheus.NewDesc(
+		"traces_service_graph_request_server_seconds",
+		"Time for a request between two nodes as seen from the server.",
+		[]string{"client", "server", "connection_type"}, nil,
+	)
+	serviceGraphRequestClientSeconds = prometheus.NewDesc(
+		"traces_service_graph_request_client_seconds",
+		"Time for a request between two nodes as seen from the client.",
+		[]string{"client", "server", "connection_type"}, nil,
+	)
+	serviceRequestsTotal = prometheus.NewDesc(
+		"otelmap_service_requests_total",
+		"Total count of spans recorded by a service.",
+		[]string{"service"}, nil,
+	)
+	serviceErrorsTotal = prometheus.NewDesc(
+		"otelmap_service_errors_total",
+		"Total count of spans recorded with an error status by a service.",
+		[]string{"service"}, nil,
+	)
+	serviceLatencySeconds = prometheus.NewDesc(
+		"otelmap_service_latency_seconds",
+		"Span duration quantiles of a service.",
+		[]string{"service", "quantile"}, nil,
+	)
+)
+
+// serviceGraphCollector turns a snapshot of mapz results into constant metrics.
+type serviceGraphCollector struct {
+	services []mapz.Service
+	edges    []mapz.EdgeMetrics
+}
+
+func (s *serviceGraphCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- serviceGraphRequestTotal
+	ch <- serviceGraphRequestFailedTotal
+	ch <- serviceGraphRequestServerSeconds
+	ch <- serviceGraphRequestClientSeconds
+	ch <- serviceRequestsTotal
+	ch <- serviceErrorsTotal
+	ch <- serviceLatencySeconds
+}
+
+func (s *serviceGraphCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, e := range s.edges {
+		labels := []string{e.Client, e.Server, ""}
+		ch <- prometheus.MustNewConstMetric(serviceGraphRequestTotal, prometheus.CounterValue, float64(e.TotalRequests), labels...)
+		ch <- prometheus.MustNewConstMetric(serviceGraphRequestFailedTotal, prometheus.CounterValue, float64(e.FailedRequests), labels...)
+		ch <- prometheus.MustNewConstHistogram(serviceGraphRequestServerSeconds, e.TotalRequests, e.ServerSecondsSum, histogramBuckets(e.ServerSecondsBuckets), labels...)
+		ch <- prometheus.MustNewConstHistogram(serviceGraphRequestClientSeconds, e.TotalRequests, e.ClientSecondsSum, histogramBuckets(e.ClientSecondsBuckets), labels...)
+	}
+
+	for _, svc := range s.services {
+		ch <- prometheus.MustNewConstMetric(serviceRequestsTotal, prometheus.CounterValue, float64(svc.TotalRequests), svc.ServiceName)
+		ch <- prometheus.MustNewConstMetric(serviceErrorsTotal, prometheus.CounterValue, float64(svc.ErrorCount), svc.ServiceName)
+		ch <- prometheus.MustNewConstMetric(serviceLatencySeconds, prometheus.GaugeValue, svc.LatencyP50Ms/1000, svc.ServiceName, "0.5")
+		ch <- prometheus.MustNewConstMetric(serviceLatencySeconds, prometheus.GaugeValue, svc.LatencyP90Ms/1000, svc.ServiceName, "0.9")
+		ch <- prometheus.MustNewConstMetric(serviceLatencySeconds, prometheus.GaugeValue, svc.LatencyP95Ms/1000, svc.ServiceName, "0.95")
+	}
+}
+
+func histogramBuckets(counts []uint64) map[float64]uint64 {
+	buckets := make(map[float64]uint64, len(mapz.HistogramBuckets))
+	for i, upper := range mapz.HistogramBuckets {
+		if i < len(counts) {
+			buckets[upper] = counts[i]
+		}
+	}
+	return buckets
+}
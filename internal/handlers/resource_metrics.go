@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+	mapz "github.com/jack5341/otel-map-server/internal/mapz"
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// defaultResourceWindow is how far back resource metrics are read when a
+// request does not give a window.
+const defaultResourceWindow = 5 * time.Minute
+
+type ResourceMetricsResponse struct {
+	Metrics []mapz.MetricInfo `json:"metrics"`
+}
+
+type ResourceMetricSeriesResponse struct {
+	Step   string            `json:"step"`
+	Series mapz.MetricSeries `json:"series"`
+}
+
+// ResourceMetricsHandler serves the metrics the collector stores for a
+// session's services.
+type ResourceMetricsHandler struct {
+	db         *gorm.DB
+	otelTracer trace.Tracer
+}
+
+func NewResourceMetricsHandler(db *gorm.DB, otelTracer trace.Tracer) *ResourceMetricsHandler {
+	return &ResourceMetricsHandler{db: db, otelTracer: otelTracer}
+}
+
+// Get lists the metrics a service reports or, with the name query parameter,
+// returns that metric's series bucketed by step (default 1m) over window
+// (default 1h).
+func (h *ResourceMetricsHandler) Get(c echo.Context) error {
+	ctx, span := h.otelTracer.Start(c.Request().Context(), "ResourceMetricsHandler.Get")
+	defer span.End()
+	sessionToken := c.Param("session-token")
+	if sessionToken == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrSessionTokenRequired.Error()})
+	}
+
+	var _, err = uuid.Parse(sessionToken)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidSessionToken.Error()})
+	}
+
+	step, err := mapz.ParseStep(c.QueryParam("step"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	window := time.Hour
+	if v := c.QueryParam("window"); v != "" {
+		windows, err := mapz.ParseWindows(v)
+		if err != nil || len(windows) != 1 || windows[0] > (mapz.MaxSeriesPoints-1)*step {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidWindow.Error()})
+		}
+		window = windows[0]
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	mapper := mapz.NewMapper(h.db, h.otelTracer, dbCtx)
+	serviceName := c.Param("service")
+	name := c.QueryParam("name")
+	if name == "" {
+		infos, err := mapper.GetServiceMetricNames(sessionToken, serviceName)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusOK, ResourceMetricsResponse{Metrics: infos})
+	}
+
+	series, err := mapper.GetServiceMetricSeries(sessionToken, serviceName, name, time.Now().Add(-window), step)
+	if errors.Is(err, errorz.ErrMetricNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, ResourceMetricSeriesResponse{Step: step.String(), Series: *series})
+}
@@ -2,17 +2,34 @@ package handlers
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jack5341/otel-map-server/internal/analysis"
 	errorz "github.com/jack5341/otel-map-server/internal/errors"
+	"github.com/jack5341/otel-map-server/internal/export"
+	"github.com/jack5341/otel-map-server/internal/layout"
 	mapz "github.com/jack5341/otel-map-server/internal/mapz"
+	"github.com/jack5341/otel-map-server/internal/render"
 	"github.com/labstack/echo/v4"
 	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
 )
 
+const (
+	headerETag        = "ETag"
+	headerIfNoneMatch = "If-None-Match"
+)
+
 type ServiceMapRequest struct {
 	ID uuid.UUID `json:"id"`
 }
@@ -20,35 +37,67 @@ type ServiceMapRequest struct {
 type ServiceMapHandler struct {
 	db         *gorm.DB
 	otelTracer trace.Tracer
+	cache      *mapz.Cache
 }
 
 type ServiceMapResponse struct {
 	Services []mapz.Service `json:"services"`
 	Edges    []mapz.Edge    `json:"edges"`
+	Layout   *layout.Layout `json:"layout,omitempty"`
+	// DataSource is where node and edge metrics were read from: spans or
+	// span_metrics.
+	DataSource string `json:"data_source"`
 }
 
-func NewServiceMapHandler(db *gorm.DB, otelTracer trace.Tracer) *ServiceMapHandler {
-	return &ServiceMapHandler{db: db, otelTracer: otelTracer}
+func NewServiceMapHandler(db *gorm.DB, otelTracer trace.Tracer, cache *mapz.Cache) *ServiceMapHandler {
+	return &ServiceMapHandler{db: db, otelTracer: otelTracer, cache: cache}
 }
 
 func (h *ServiceMapHandler) Get(c echo.Context) error {
 	ctx, span := h.otelTracer.Start(c.Request().Context(), "ServiceMapHandler.Get")
 	defer span.End()
 	sessionToken := c.Param("session-token")
+	formatParam := c.QueryParam("format")
+	// Image routes are addressed as /service-map/<token>.svg or .png
+	if ext := path.Ext(sessionToken); ext == ".svg" || ext == ".png" {
+		sessionToken = strings.TrimSuffix(sessionToken, ext)
+		formatParam = ext[1:]
+	}
 	if sessionToken == "" {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrSessionTokenRequired.Error()})
 	}
 
-	var _, err = uuid.Parse(sessionToken)
+	tokenUUID, err := uuid.Parse(sessionToken)
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidSessionToken.Error()})
 	}
 
+	layoutParam := c.QueryParam("layout")
+	if !layout.Valid(layoutParam) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrUnsupportedLayout.Error()})
+	}
+
+	opts, err := mapOptions(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	sloWindows, err := mapz.ParseWindows(c.QueryParam("slo_windows"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
 	// Add timeout for database operations
 	dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	mapper := mapz.NewMapper(h.db, h.otelTracer, dbCtx)
+	mapper := mapz.NewMapper(h.db, h.otelTracer, dbCtx).WithOptions(opts).WithCache(h.cache)
+	source, err := mapper.DataSource(sessionToken)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	// Resolve once, so nodes and edges come from the same source.
+	opts.Source = source
+	mapper = mapper.WithOptions(opts)
 	services, err := mapper.GetServicesWithMetrics(sessionToken)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
@@ -59,10 +108,223 @@ func (h *ServiceMapHandler) Get(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
 
+	slos, err := mapper.EvaluateSLOs(sessionToken, sloWindows)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	for i := range services {
+		for _, status := range slos {
+			if status.SLO.ServiceName == services[i].ServiceName {
+				services[i].SLOs = append(services[i].SLOs, status)
+			}
+		}
+	}
+
+	// error_logs=true counts each node's logs of severity ERROR and above.
+	if withLogs, _ := strconv.ParseBool(c.QueryParam("error_logs")); withLogs {
+		counts, err := mapper.GetErrorLogCounts(sessionToken)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		for i := range services {
+			count := counts[services[i].NodeID()]
+			services[i].ErrorLogCount = &count
+		}
+	}
+
+	// resource_metrics=true attaches the runtime and host metrics each
+	// service reported over resource_window (default 5m).
+	if withResources, _ := strconv.ParseBool(c.QueryParam("resource_metrics")); withResources {
+		window := defaultResourceWindow
+		if v := c.QueryParam("resource_window"); v != "" {
+			windows, err := mapz.ParseWindows(v)
+			if err != nil || len(windows) != 1 {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidWindow.Error()})
+			}
+			window = windows[0]
+		}
+		overlays, err := mapper.GetResourceMetrics(sessionToken, time.Now().Add(-window), mapz.DefaultStep)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		for i := range services {
+			services[i].ResourceMetrics = overlays[services[i].ServiceName]
+		}
+	}
+
+	// anomalies=true scores the latest complete bucket of each node and edge,
+	// bucketed by anomaly_step.
+	if withAnomalies, _ := strconv.ParseBool(c.QueryParam("anomalies")); withAnomalies {
+		seriesOpts, step, err := seriesOptions(c, c.QueryParam("anomaly_step"))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		seriesMapper := mapz.NewMapper(h.db, h.otelTracer, dbCtx).WithOptions(seriesOpts)
+		report, err := detectAnomalies(seriesMapper, sessionToken, step, analysis.DefaultAnomalyOptions())
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		report.Apply(services, edges)
+	}
+
 	serviceMapResponse := ServiceMapResponse{
-		Services: services,
-		Edges:    edges,
+		Services:   services,
+		Edges:      edges,
+		DataSource: source,
+	}
+
+	format, err := export.Negotiate(formatParam, c.Request().Header.Get(echo.HeaderAccept))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	image := format == export.FormatSVG || format == export.FormatPNG
+	if image && len(services) > render.MaxNodes {
+		return c.JSON(http.StatusUnprocessableEntity, map[string]string{"error": errorz.ErrImageTooLarge.Error()})
 	}
 
-	return c.JSON(http.StatusOK, serviceMapResponse)
+	var l layout.Layout
+	if layoutParam != "" || image {
+		l, err = h.layout(dbCtx, tokenUUID, layoutParam, services, edges)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		if layoutParam != "" {
+			serviceMapResponse.Layout = &l
+		}
+	}
+
+	// Viewers polling the map revalidate with If-None-Match and get a 304
+	// while it is unchanged.
+	etag, err := mapETag(format, serviceMapResponse, l)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	c.Response().Header().Set(headerETag, etag)
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-cache")
+	if etagMatches(c.Request().Header.Get(headerIfNoneMatch), etag) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	switch format {
+	case export.FormatNodeGraph:
+		return c.JSON(http.StatusOK, export.NodeGraph(services, edges))
+	case export.FormatCytoscape:
+		return c.JSON(http.StatusOK, export.Cytoscape(services, edges))
+	case export.FormatDOT:
+		return c.Blob(http.StatusOK, export.ContentTypes[format], export.DOT(services, edges))
+	case export.FormatMermaid:
+		return c.Blob(http.StatusOK, export.ContentTypes[format], export.Mermaid(services, edges))
+	case export.FormatGraphML:
+		data, err := export.GraphML(services, edges)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		return c.Blob(http.StatusOK, export.ContentTypes[format], data)
+	case export.FormatSVG:
+		return c.Blob(http.StatusOK, export.ContentTypes[format], render.SVG(services, edges, l))
+	case export.FormatPNG:
+		data, err := render.PNG(services, edges, l)
+		if errors.Is(err, errorz.ErrImageTooLarge) {
+			return c.JSON(http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		return c.Blob(http.StatusOK, export.ContentTypes[format], data)
+	default:
+		return c.JSON(http.StatusOK, serviceMapResponse)
+	}
+}
+
+// mapETag is a strong entity tag over everything a map representation is
+// rendered from.
+func mapETag(format export.Format, response ServiceMapResponse, l layout.Layout) (string, error) {
+	data, err := json.Marshal(struct {
+		Format   export.Format      `json:"format"`
+		Response ServiceMapResponse `json:"response"`
+		Layout   layout.Layout      `json:"layout"`
+	}{format, response, l})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`, nil
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, comparing
+// weakly as RFC 9110 asks.
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// layout computes node coordinates around the positions stored for the
+// session, then stores the positions of newly seen nodes.
+func (h *ServiceMapHandler) layout(ctx context.Context, sessionToken uuid.UUID, algorithm string, services []mapz.Service, edges []mapz.Edge) (layout.Layout, error) {
+	// Positions are stored per algorithm that actually runs, so a cyclic map
+	// laid out by force does not pin later layered renders.
+	g := export.Graph(services, edges)
+	key := layout.Resolve(algorithm, g)
+
+	store := layout.NewStore(h.db, h.otelTracer, ctx)
+	previous, err := store.Load(sessionToken, key)
+	if err != nil {
+		return layout.Layout{}, err
+	}
+
+	g.Pinned = previous
+	l := layout.Compute(key, g)
+
+	if err := store.Save(sessionToken, key, l, previous); err != nil {
+		return layout.Layout{}, err
+	}
+	return l, nil
+}
+
+// mapOptions reads the split_by and group_by query parameters, each a comma
+// separated list of resource attribute keys, the filter expression, extra
+// latency quantiles and whether to add latency histograms.
+// by_version=true splits nodes by service.version on top of split_by.
+func mapOptions(c echo.Context) (mapz.Options, error) {
+	splitBy, err := mapz.ParseAttributeKeys(c.QueryParam("split_by"))
+	if err != nil {
+		return mapz.Options{}, err
+	}
+	groupBy, err := mapz.ParseAttributeKeys(c.QueryParam("group_by"))
+	if err != nil {
+		return mapz.Options{}, err
+	}
+	if byVersion, _ := strconv.ParseBool(c.QueryParam("by_version")); byVersion && !slices.Contains(splitBy, mapz.VersionAttribute) {
+		splitBy = append(splitBy, mapz.VersionAttribute)
+	}
+	filter, err := mapz.ParseFilter(c.QueryParam("filter"))
+	if err != nil {
+		return mapz.Options{}, err
+	}
+	quantiles, err := mapz.ParseQuantiles(c.QueryParam("quantiles"))
+	if err != nil {
+		return mapz.Options{}, err
+	}
+	histogram, _ := strconv.ParseBool(c.QueryParam("histogram"))
+	source := c.QueryParam("source")
+	if source != "" && !mapz.ValidDataSource(source) {
+		return mapz.Options{}, errorz.ErrInvalidDataSource
+	}
+	return mapz.Options{
+		SplitBy:   splitBy,
+		GroupBy:   groupBy,
+		Filter:    filter,
+		Quantiles: quantiles,
+		Histogram: histogram,
+		Source:    source,
+	}, nil
 }
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+	mapz "github.com/jack5341/otel-map-server/internal/mapz"
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+type SLOsResponse struct {
+	SLOs []mapz.SLOStatus `json:"slos"`
+}
+
+// SLOHandler manages the service level objectives of a session.
+type SLOHandler struct {
+	db         *gorm.DB
+	otelTracer trace.Tracer
+}
+
+func NewSLOHandler(db *gorm.DB, otelTracer trace.Tracer) *SLOHandler {
+	return &SLOHandler{db: db, otelTracer: otelTracer}
+}
+
+// List evaluates every objective of the session over the windows query
+// parameter, e.g. windows=5m,1h,30d.
+func (h *SLOHandler) List(c echo.Context) error {
+	ctx, span := h.otelTracer.Start(c.Request().Context(), "SLOHandler.List")
+	defer span.End()
+	sessionToken := c.Param("session-token")
+	if sessionToken == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrSessionTokenRequired.Error()})
+	}
+
+	var _, err = uuid.Parse(sessionToken)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidSessionToken.Error()})
+	}
+
+	windows, err := mapz.ParseWindows(c.QueryParam("windows"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	statuses, err := mapz.NewMapper(h.db, h.otelTracer, dbCtx).EvaluateSLOs(sessionToken, windows)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, SLOsResponse{SLOs: statuses})
+}
+
+// Put creates an objective, or replaces the one with the ID in the body.
+func (h *SLOHandler) Put(c echo.Context) error {
+	ctx, span := h.otelTracer.Start(c.Request().Context(), "SLOHandler.Put")
+	defer span.End()
+	sessionToken := c.Param("session-token")
+	if sessionToken == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrSessionTokenRequired.Error()})
+	}
+
+	var _, err = uuid.Parse(sessionToken)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidSessionToken.Error()})
+	}
+
+	var slo mapz.SLO
+	if err := c.Bind(&slo); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidSLO.Error()})
+	}
+	if err := slo.Validate(); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	slo, err = mapz.NewMapper(h.db, h.otelTracer, dbCtx).SaveSLO(sessionToken, slo)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, slo)
+}
+
+func (h *SLOHandler) Delete(c echo.Context) error {
+	ctx, span := h.otelTracer.Start(c.Request().Context(), "SLOHandler.Delete")
+	defer span.End()
+	sessionToken := c.Param("session-token")
+	if sessionToken == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrSessionTokenRequired.Error()})
+	}
+
+	var _, err = uuid.Parse(sessionToken)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidSessionToken.Error()})
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": errorz.ErrSLONotFound.Error()})
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	err = mapz.NewMapper(h.db, h.otelTracer, dbCtx).DeleteSLO(sessionToken, id)
+	if errors.Is(err, errorz.ErrSLONotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jack5341/otel-map-server/internal/analysis"
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+	mapz "github.com/jack5341/otel-map-server/internal/mapz"
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+type TimeseriesResponse struct {
+	Step        string               `json:"step"`
+	Services    []mapz.ServiceSeries `json:"services"`
+	Edges       []mapz.EdgeSeries    `json:"edges"`
+	Annotations []mapz.Annotation    `json:"annotations"`
+}
+
+type AnomaliesResponse struct {
+	Step      string             `json:"step"`
+	Anomalies []analysis.Anomaly `json:"anomalies"`
+}
+
+// TimeseriesHandler serves per-bucket RED metrics of a session's nodes and
+// edges, and the anomalies detected in them.
+type TimeseriesHandler struct {
+	db         *gorm.DB
+	otelTracer trace.Tracer
+}
+
+func NewTimeseriesHandler(db *gorm.DB, otelTracer trace.Tracer) *TimeseriesHandler {
+	return &TimeseriesHandler{db: db, otelTracer: otelTracer}
+}
+
+// Get returns the series of every node and edge, bucketed by the step query
+// parameter (default 1m) over the window parameter, e.g. window=6h, with the
+// annotations in that window. The map options split_by, by_version and
+// filter apply.
+func (h *TimeseriesHandler) Get(c echo.Context) error {
+	ctx, span := h.otelTracer.Start(c.Request().Context(), "TimeseriesHandler.Get")
+	defer span.End()
+	sessionToken := c.Param("session-token")
+	if sessionToken == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrSessionTokenRequired.Error()})
+	}
+
+	var _, err = uuid.Parse(sessionToken)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidSessionToken.Error()})
+	}
+
+	opts, step, err := seriesOptions(c, c.QueryParam("step"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	mapper := mapz.NewMapper(h.db, h.otelTracer, dbCtx).WithOptions(opts)
+	services, err := mapper.GetServiceSeries(sessionToken, step)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	edges, err := mapper.GetEdgeSeries(sessionToken, step)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	annotations, err := mapper.GetAnnotations(sessionToken, opts.Since, time.Time{})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, TimeseriesResponse{
+		Step:        step.String(),
+		Services:    services,
+		Edges:       edges,
+		Annotations: annotations,
+	})
+}
+
+// Anomalies returns the anomalous buckets of the session's series, newest
+// first. threshold, lookback and min_requests tune the detection.
+func (h *TimeseriesHandler) Anomalies(c echo.Context) error {
+	ctx, span := h.otelTracer.Start(c.Request().Context(), "TimeseriesHandler.Anomalies")
+	defer span.End()
+	sessionToken := c.Param("session-token")
+	if sessionToken == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrSessionTokenRequired.Error()})
+	}
+
+	var _, err = uuid.Parse(sessionToken)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidSessionToken.Error()})
+	}
+
+	opts, step, err := seriesOptions(c, c.QueryParam("step"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	anomalyOpts, err := parseAnomalyOptions(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidThreshold.Error()})
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	report, err := detectAnomalies(mapz.NewMapper(h.db, h.otelTracer, dbCtx).WithOptions(opts), sessionToken, step, anomalyOpts)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, AnomaliesResponse{Step: step.String(), Anomalies: report.Anomalies})
+}
+
+func detectAnomalies(mapper *mapz.Mapper, sessionToken string, step time.Duration, opts analysis.AnomalyOptions) (analysis.AnomalyReport, error) {
+	services, err := mapper.GetServiceSeries(sessionToken, step)
+	if err != nil {
+		return analysis.AnomalyReport{}, err
+	}
+	edges, err := mapper.GetEdgeSeries(sessionToken, step)
+	if err != nil {
+		return analysis.AnomalyReport{}, err
+	}
+	return analysis.DetectAnomalies(services, edges, step, time.Now(), opts), nil
+}
+
+// seriesOptions reads the map options and the bucket step of a time series
+// request. Without a window parameter the series covers as many buckets as a
+// series may hold.
+func seriesOptions(c echo.Context, stepParam string) (mapz.Options, time.Duration, error) {
+	opts, err := mapOptions(c)
+	if err != nil {
+		return opts, 0, err
+	}
+	step, err := mapz.ParseStep(stepParam)
+	if err != nil {
+		return opts, 0, err
+	}
+
+	window := (mapz.MaxSeriesPoints - 1) * step
+	if v := c.QueryParam("window"); v != "" {
+		windows, err := mapz.ParseWindows(v)
+		if err != nil || len(windows) != 1 || windows[0] > window {
+			return opts, 0, errorz.ErrInvalidWindow
+		}
+		window = windows[0]
+	}
+	opts.Since = time.Now().Add(-window)
+	return opts, step, nil
+}
+
+func parseAnomalyOptions(c echo.Context) (analysis.AnomalyOptions, error) {
+	opts := analysis.DefaultAnomalyOptions()
+	if v := c.QueryParam("threshold"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f <= 0 {
+			return opts, errorz.ErrInvalidThreshold
+		}
+		opts.Threshold = f
+	}
+	if v := c.QueryParam("lookback"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < opts.MinBaseline {
+			return opts, errorz.ErrInvalidThreshold
+		}
+		opts.Lookback = n
+	}
+	if v := c.QueryParam("min_requests"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return opts, errorz.ErrInvalidThreshold
+		}
+		opts.MinRequests = n
+	}
+	return opts, nil
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jack5341/otel-map-server/internal/analysis"
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+	mapz "github.com/jack5341/otel-map-server/internal/mapz"
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+type VersionsHandler struct {
+	db         *gorm.DB
+	otelTracer trace.Tracer
+}
+
+func NewVersionsHandler(db *gorm.DB, otelTracer trace.Tracer) *VersionsHandler {
+	return &VersionsHandler{db: db, otelTracer: otelTracer}
+}
+
+// Compare reports the metrics of every version of a service, their deltas to
+// the baseline version and the edges into each version, along with the
+// annotations of the service and of the whole session. The baseline query
+// parameter picks the version to compare against; the filter parameter is
+// honoured like on the service map.
+func (h *VersionsHandler) Compare(c echo.Context) error {
+	ctx, span := h.otelTracer.Start(c.Request().Context(), "VersionsHandler.Compare")
+	defer span.End()
+	sessionToken := c.Param("session-token")
+	if sessionToken == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrSessionTokenRequired.Error()})
+	}
+
+	var _, err = uuid.Parse(sessionToken)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorz.ErrInvalidSessionToken.Error()})
+	}
+
+	filter, err := mapz.ParseFilter(c.QueryParam("filter"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	mapper := mapz.NewMapper(h.db, h.otelTracer, dbCtx).WithOptions(mapz.Options{
+		SplitBy: []string{mapz.VersionAttribute},
+		Filter:  filter,
+	})
+	services, err := mapper.GetServicesWithMetrics(sessionToken)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	edges, err := mapper.GetEdges(sessionToken)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	comparison, err := analysis.CompareVersions(c.Param("service"), c.QueryParam("baseline"), services, edges)
+	if errors.Is(err, errorz.ErrServiceNotFound) || errors.Is(err, errorz.ErrVersionNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	annotations, err := mapper.GetAnnotations(sessionToken, time.Time{}, time.Time{})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	for _, a := range annotations {
+		if a.ServiceName == "" || a.ServiceName == comparison.ServiceName {
+			comparison.Annotations = append(comparison.Annotations, a)
+		}
+	}
+
+	return c.JSON(http.StatusOK, comparison)
+}
@@ -1,6 +1,10 @@
 package middleware
 
 import (
+	"strconv"
+	"time"
+
+	"github.com/jack5341/otel-map-server/internal/metrics"
 	"github.com/labstack/echo/v4"
 	echomw "github.com/labstack/echo/v4/middleware"
 	"go.opentelemetry.io/otel"
@@ -15,6 +19,7 @@ func Apply(e *echo.Echo, otelTracer trace.Tracer) {
 	e.Use(echomw.Recover())
 	e.Use(echomw.RequestID())
 	e.Use(echomw.Logger())
+	e.Use(Metrics())
 	e.Use(echomw.Secure())
 	e.Use(echomw.CORS())
 	e.Use(echomw.RateLimiter(echomw.NewRateLimiterMemoryStore(20)))
@@ -27,3 +32,26 @@ func Apply(e *echo.Echo, otelTracer trace.Tracer) {
 		}
 	})
 }
+
+// Metrics records request counts and durations into the server's Prometheus registry.
+func Metrics() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			err := next(c)
+			if err != nil {
+				c.Error(err)
+			}
+
+			route := c.Path()
+			if route == "" {
+				route = "unmatched"
+			}
+			method := c.Request().Method
+			status := strconv.Itoa(c.Response().Status)
+			metrics.HTTPRequestsTotal.WithLabelValues(method, route, status).Inc()
+			metrics.HTTPRequestDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+			return nil
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/jack5341/otel-map-server/internal/config"
 	"github.com/jack5341/otel-map-server/internal/handlers"
 	imw "github.com/jack5341/otel-map-server/internal/http/middleware"
+	mapz "github.com/jack5341/otel-map-server/internal/mapz"
 )
 
 func Register(e *echo.Echo, db *gorm.DB, otelTracer trace.Tracer, config *config.Config) {
@@ -19,15 +20,71 @@ func Register(e *echo.Echo, db *gorm.DB, otelTracer trace.Tracer, config *config
 
 	// Handlers
 	health := handlers.NewHealthHandler(db, otelTracer)
-	serviceMap := handlers.NewServiceMapHandler(db, otelTracer)
+	serviceMap := handlers.NewServiceMapHandler(db, otelTracer, mapz.NewCache(config.MapCacheTTL))
 	sessionToken := handlers.NewSessionTokenHandler(db, otelTracer, config)
 	sessionEvents := handlers.NewSessionEventsHandler(db, otelTracer, config)
+	metrics := handlers.NewMetricsHandler(db, otelTracer)
+	jaegerQuery := handlers.NewJaegerHandler(db, otelTracer)
+	graphQuery := handlers.NewGraphQueryHandler(db, otelTracer)
+	findings := handlers.NewFindingsHandler(db, otelTracer)
+	versions := handlers.NewVersionsHandler(db, otelTracer)
+	errorPolicy := handlers.NewErrorPolicyHandler(db, otelTracer)
+	errorReport := handlers.NewErrorsHandler(db, otelTracer)
+	slos := handlers.NewSLOHandler(db, otelTracer)
+	alerts := handlers.NewAlertsHandler(db, otelTracer)
+	timeseries := handlers.NewTimeseriesHandler(db, otelTracer)
+	annotations := handlers.NewAnnotationsHandler(db, otelTracer)
+	logs := handlers.NewLogsHandler(db, otelTracer)
+	resourceMetrics := handlers.NewResourceMetricsHandler(db, otelTracer)
+	dataSource := handlers.NewDataSourceHandler(db, otelTracer)
 
 	// Health endpoints
 	v1.GET("/healthz", health.Liveness)
 	v1.GET("/readyz", health.Readiness)
 
+	// Prometheus scrape endpoints
+	e.GET("/metrics", metrics.Server)
+	v1.GET("/service-map/:session-token/metrics", metrics.ServiceGraph)
+
 	v1.GET("/service-map/:session-token", serviceMap.Get)
+	v1.GET("/service-map/:session-token/paths", graphQuery.Paths)
+	v1.GET("/service-map/:session-token/findings", findings.Get)
+	v1.GET("/service-map/:session-token/timeseries", timeseries.Get)
+	v1.GET("/service-map/:session-token/anomalies", timeseries.Anomalies)
+	v1.GET("/service-map/:session-token/annotations", annotations.List)
+	v1.POST("/service-map/:session-token/annotations", annotations.Put)
+	v1.DELETE("/service-map/:session-token/annotations/:id", annotations.Delete)
+	v1.GET("/service-map/:session-token/errors", errorReport.Get)
+	v1.GET("/service-map/:session-token/root-causes", errorReport.RootCauses)
+	v1.GET("/service-map/:session-token/error-policy", errorPolicy.Get)
+	v1.PUT("/service-map/:session-token/error-policy", errorPolicy.Put)
+	v1.GET("/service-map/:session-token/data-source", dataSource.Get)
+	v1.PUT("/service-map/:session-token/data-source", dataSource.Put)
+	v1.GET("/service-map/:session-token/slos", slos.List)
+	v1.POST("/service-map/:session-token/slos", slos.Put)
+	v1.DELETE("/service-map/:session-token/slos/:id", slos.Delete)
+	v1.GET("/service-map/:session-token/alerts", alerts.List)
+	v1.GET("/service-map/:session-token/alerts/rules", alerts.ListRules)
+	v1.POST("/service-map/:session-token/alerts/rules", alerts.PutRule)
+	v1.DELETE("/service-map/:session-token/alerts/rules/:id", alerts.DeleteRule)
+	v1.GET("/service-map/:session-token/services/:service/upstream", graphQuery.Upstream)
+	v1.GET("/service-map/:session-token/services/:service/downstream", graphQuery.Downstream)
+	v1.GET("/service-map/:session-token/services/:service/impact", graphQuery.Impact)
+	v1.GET("/service-map/:session-token/services/:service/versions", versions.Compare)
+	v1.GET("/service-map/:session-token/services/:service/logs", logs.Service)
+	v1.GET("/service-map/:session-token/traces/:trace-id/logs", logs.Trace)
+	v1.GET("/service-map/:session-token/services/:service/metrics", resourceMetrics.Get)
 	v1.GET("/session-events", sessionEvents.Listen)
 	v1.POST("/session-token", sessionToken.Create)
+
+	// Jaeger query API, scoped by the X-OTEL-SESSION header on /api or by
+	// the path prefix on /jaeger/:session-token/api
+	for _, g := range []*echo.Group{api, e.Group("/jaeger/:session-token/api")} {
+		g.GET("/services", jaegerQuery.Services)
+		g.GET("/services/:service/operations", jaegerQuery.Operations)
+		g.GET("/operations", jaegerQuery.Operations)
+		g.GET("/traces", jaegerQuery.FindTraces)
+		g.GET("/traces/:trace-id", jaegerQuery.GetTrace)
+		g.GET("/dependencies", jaegerQuery.Dependencies)
+	}
 }
@@ -0,0 +1,236 @@
+package jaeger
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	mapz "github.com/jack5341/otel-map-server/internal/mapz"
+)
+
+// Response is the envelope used by every Jaeger query API endpoint.
+type Response struct {
+	Data   any             `json:"data"`
+	Total  int             `json:"total"`
+	Limit  int             `json:"limit"`
+	Offset int             `json:"offset"`
+	Errors []ResponseError `json:"errors"`
+}
+
+type ResponseError struct {
+	Code    int    `json:"code"`
+	Msg     string `json:"msg"`
+	TraceID string `json:"traceID,omitempty"`
+}
+
+type KeyValue struct {
+	Key   string `json:"key"`
+	Type  string `json:"type"`
+	Value any    `json:"value"`
+}
+
+type Reference struct {
+	RefType string `json:"refType"`
+	TraceID string `json:"traceID"`
+	SpanID  string `json:"spanID"`
+}
+
+type Log struct {
+	Timestamp int64      `json:"timestamp"`
+	Fields    []KeyValue `json:"fields"`
+}
+
+type Span struct {
+	TraceID       string      `json:"traceID"`
+	SpanID        string      `json:"spanID"`
+	Flags         uint32      `json:"flags"`
+	OperationName string      `json:"operationName"`
+	References    []Reference `json:"references"`
+	StartTime     int64       `json:"startTime"`
+	Duration      int64       `json:"duration"`
+	Tags          []KeyValue  `json:"tags"`
+	Logs          []Log       `json:"logs"`
+	ProcessID     string      `json:"processID"`
+	Warnings      []string    `json:"warnings"`
+}
+
+type Process struct {
+	ServiceName string     `json:"serviceName"`
+	Tags        []KeyValue `json:"tags"`
+}
+
+type Trace struct {
+	TraceID   string             `json:"traceID"`
+	Spans     []Span             `json:"spans"`
+	Processes map[string]Process `json:"processes"`
+	Warnings  []string           `json:"warnings"`
+}
+
+type Operation struct {
+	Name     string `json:"name"`
+	SpanKind string `json:"spanKind"`
+}
+
+type Dependency struct {
+	Parent    string `json:"parent"`
+	Child     string `json:"child"`
+	CallCount uint64 `json:"callCount"`
+}
+
+// spanRow is a single row of default.otel_traces as written by the
+// collector's ClickHouse exporter.
+type spanRow struct {
+	TraceId            string
+	SpanId             string
+	ParentSpanId       string
+	ServiceName        string
+	SpanName           string
+	SpanKind           string
+	Timestamp          time.Time
+	Duration           int64
+	StatusCode         string
+	StatusMessage      string
+	SpanAttributes     map[string]string   `gorm:"type:Map(LowCardinality(String), String)"`
+	ResourceAttributes map[string]string   `gorm:"type:Map(LowCardinality(String), String)"`
+	EventsTimestamp    []time.Time         `gorm:"type:Array(DateTime64(9))"`
+	EventsName         []string            `gorm:"type:Array(LowCardinality(String))"`
+	EventsAttributes   []map[string]string `gorm:"type:Array(Map(LowCardinality(String), String))"`
+}
+
+// normalizeSpanKind maps exporter span kinds ("Server", "SPAN_KIND_SERVER")
+// to the lower-case values Jaeger uses ("server").
+func normalizeSpanKind(kind string) string {
+	kind = strings.TrimPrefix(strings.ToUpper(kind), "SPAN_KIND_")
+	switch kind {
+	case "SERVER", "CLIENT", "PRODUCER", "CONSUMER", "INTERNAL":
+		return strings.ToLower(kind)
+	default:
+		return ""
+	}
+}
+
+func stringTags(attrs map[string]string) []KeyValue {
+	tags := make([]KeyValue, 0, len(attrs))
+	for k, v := range attrs {
+		tags = append(tags, KeyValue{Key: k, Type: "string", Value: v})
+	}
+	return tags
+}
+
+func resourceKey(attrs map[string]string) string {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(attrs[k])
+		b.WriteByte(';')
+	}
+	return b.String()
+}
+
+// buildTraces groups span rows by trace and converts them into Jaeger traces,
+// deduplicating processes per trace by service name and resource attributes.
+func buildTraces(rows []spanRow) []Trace {
+	var traces []Trace
+	index := make(map[string]int)
+	processKeys := make(map[string]map[string]string)
+
+	for _, row := range rows {
+		i, ok := index[row.TraceId]
+		if !ok {
+			i = len(traces)
+			index[row.TraceId] = i
+			traces = append(traces, Trace{TraceID: row.TraceId, Processes: map[string]Process{}})
+			processKeys[row.TraceId] = map[string]string{}
+		}
+		trace := &traces[i]
+
+		processKey := row.ServiceName + "\x00" + resourceKey(row.ResourceAttributes)
+		processID, ok := processKeys[row.TraceId][processKey]
+		if !ok {
+			processID = "p" + strconv.Itoa(len(trace.Processes)+1)
+			processKeys[row.TraceId][processKey] = processID
+			trace.Processes[processID] = Process{
+				ServiceName: row.ServiceName,
+				Tags:        stringTags(row.ResourceAttributes),
+			}
+		}
+
+		trace.Spans = append(trace.Spans, buildSpan(row, processID))
+	}
+
+	return traces
+}
+
+func buildSpan(row spanRow, processID string) Span {
+	span := Span{
+		TraceID:       row.TraceId,
+		SpanID:        row.SpanId,
+		Flags:         1,
+		OperationName: row.SpanName,
+		References:    []Reference{},
+		StartTime:     row.Timestamp.UnixMicro(),
+		Duration:      row.Duration / int64(time.Microsecond),
+		Tags:          stringTags(row.SpanAttributes),
+		Logs:          []Log{},
+		ProcessID:     processID,
+	}
+
+	if row.ParentSpanId != "" {
+		span.References = append(span.References, Reference{
+			RefType: "CHILD_OF",
+			TraceID: row.TraceId,
+			SpanID:  row.ParentSpanId,
+		})
+	}
+	if kind := normalizeSpanKind(row.SpanKind); kind != "" {
+		span.Tags = append(span.Tags, KeyValue{Key: "span.kind", Type: "string", Value: kind})
+	}
+	if mapz.IsErrorStatus(row.StatusCode) {
+		span.Tags = append(span.Tags, KeyValue{Key: "error", Type: "bool", Value: true})
+	}
+	if row.StatusMessage != "" {
+		span.Tags = append(span.Tags, KeyValue{Key: "otel.status_description", Type: "string", Value: row.StatusMessage})
+	}
+
+	for i, ts := range row.EventsTimestamp {
+		log := Log{Timestamp: ts.UnixMicro()}
+		if i < len(row.EventsName) {
+			log.Fields = append(log.Fields, KeyValue{Key: "event", Type: "string", Value: row.EventsName[i]})
+		}
+		if i < len(row.EventsAttributes) {
+			log.Fields = append(log.Fields, stringTags(row.EventsAttributes[i])...)
+		}
+		span.Logs = append(span.Logs, log)
+	}
+
+	return span
+}
+
+// Dependencies collapses service map edges into Jaeger's parent/child
+// dependency links, skipping calls within the same service.
+func Dependencies(edges []mapz.Edge) []Dependency {
+	deps := []Dependency{}
+	index := make(map[[2]string]int)
+	for _, e := range edges {
+		if e.SourceServiceName == e.TargetServiceName {
+			continue
+		}
+		key := [2]string{e.SourceServiceName, e.TargetServiceName}
+		i, ok := index[key]
+		if !ok {
+			i = len(deps)
+			index[key] = i
+			deps = append(deps, Dependency{Parent: e.SourceServiceName, Child: e.TargetServiceName})
+		}
+		deps[i].CallCount += e.TotalRequests
+	}
+	return deps
+}
@@ -0,0 +1,239 @@
+package jaeger
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+	mapz "github.com/jack5341/otel-map-server/internal/mapz"
+	"github.com/jack5341/otel-map-server/internal/metrics"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const defaultTraceLimit = 20
+
+// MaxTraceLimit bounds how many traces one search returns, as every span of
+// each is fetched. It matches the largest limit the Jaeger UI offers.
+const MaxTraceLimit = 1500
+
+const getServicesQuery = `
+SELECT DISTINCT t.ServiceName
+FROM default.otel_traces AS t
+WHERE t.ResourceAttributes['otelmap.session_token'] = ?
+ORDER BY t.ServiceName
+`
+
+const getOperationsQuery = `
+SELECT
+    t.SpanName AS name,
+    t.SpanKind AS span_kind
+FROM default.otel_traces AS t
+WHERE t.ResourceAttributes['otelmap.session_token'] = ?
+    AND t.ServiceName = ?
+GROUP BY name, span_kind
+ORDER BY name
+`
+
+const getSpansQuery = `
+SELECT
+    t.TraceId AS trace_id,
+    t.SpanId AS span_id,
+    t.ParentSpanId AS parent_span_id,
+    t.ServiceName AS service_name,
+    t.SpanName AS span_name,
+    t.SpanKind AS span_kind,
+    t.Timestamp AS timestamp,
+    t.Duration AS duration,
+    t.StatusCode AS status_code,
+    t.StatusMessage AS status_message,
+    t.SpanAttributes AS span_attributes,
+    t.ResourceAttributes AS resource_attributes,
+    t.Events.Timestamp AS events_timestamp,
+    t.Events.Name AS events_name,
+    t.Events.Attributes AS events_attributes
+FROM default.otel_traces AS t
+WHERE t.ResourceAttributes['otelmap.session_token'] = ?
+    AND t.TraceId IN ?
+ORDER BY t.TraceId, t.Timestamp
+`
+
+// TraceQuery holds the search parameters of Jaeger's /api/traces endpoint.
+type TraceQuery struct {
+	Service     string
+	Operation   string
+	Tags        map[string]string
+	Start       time.Time
+	End         time.Time
+	MinDuration time.Duration
+	MaxDuration time.Duration
+	Limit       int
+}
+
+type Reader struct {
+	db         *gorm.DB
+	otelTracer trace.Tracer
+	ctx        context.Context
+}
+
+func NewReader(db *gorm.DB, otelTracer trace.Tracer, ctx context.Context) *Reader {
+	return &Reader{db: db, otelTracer: otelTracer, ctx: ctx}
+}
+
+func (r *Reader) GetServices(sessionToken string) ([]string, error) {
+	ctx, span := r.otelTracer.Start(r.ctx, "Reader.GetServices")
+	defer span.End()
+
+	if sessionToken == "" {
+		return nil, errorz.ErrSessionTokenRequired
+	}
+
+	services := []string{}
+	start := time.Now()
+	err := r.db.WithContext(ctx).Raw(getServicesQuery, sessionToken).Scan(&services).Error
+	metrics.ObserveQuery("jaeger_get_services", start, err)
+	if err != nil {
+		return nil, errors.Join(errorz.ErrWhileGettingServices, err)
+	}
+
+	return services, nil
+}
+
+// GetOperations returns the operations of a service, optionally restricted
+// to a Jaeger span kind ("server", "client", ...).
+func (r *Reader) GetOperations(sessionToken, service, spanKind string) ([]Operation, error) {
+	ctx, span := r.otelTracer.Start(r.ctx, "Reader.GetOperations")
+	defer span.End()
+
+	if sessionToken == "" {
+		return nil, errorz.ErrSessionTokenRequired
+	}
+
+	var rows []Operation
+	start := time.Now()
+	err := r.db.WithContext(ctx).Raw(getOperationsQuery, sessionToken, service).Scan(&rows).Error
+	metrics.ObserveQuery("jaeger_get_operations", start, err)
+	if err != nil {
+		return nil, errors.Join(errorz.ErrWhileGettingOperations, err)
+	}
+
+	operations := make([]Operation, 0, len(rows))
+	for _, op := range rows {
+		op.SpanKind = normalizeSpanKind(op.SpanKind)
+		if spanKind != "" && op.SpanKind != spanKind {
+			continue
+		}
+		operations = append(operations, op)
+	}
+
+	return operations, nil
+}
+
+// FindTraces returns the most recent traces with at least one span matching q.
+func (r *Reader) FindTraces(sessionToken string, q TraceQuery) ([]Trace, error) {
+	ctx, span := r.otelTracer.Start(r.ctx, "Reader.FindTraces")
+	defer span.End()
+
+	if sessionToken == "" {
+		return nil, errorz.ErrSessionTokenRequired
+	}
+
+	var where []string
+	args := []any{sessionToken}
+	if q.Service != "" {
+		where = append(where, "t.ServiceName = ?")
+		args = append(args, q.Service)
+	}
+	if q.Operation != "" {
+		where = append(where, "t.SpanName = ?")
+		args = append(args, q.Operation)
+	}
+	if !q.Start.IsZero() {
+		where = append(where, "t.Timestamp >= ?")
+		args = append(args, q.Start)
+	}
+	if !q.End.IsZero() {
+		where = append(where, "t.Timestamp <= ?")
+		args = append(args, q.End)
+	}
+	if q.MinDuration > 0 {
+		where = append(where, "t.Duration >= ?")
+		args = append(args, q.MinDuration.Nanoseconds())
+	}
+	if q.MaxDuration > 0 {
+		where = append(where, "t.Duration <= ?")
+		args = append(args, q.MaxDuration.Nanoseconds())
+	}
+	for k, v := range q.Tags {
+		if k == "error" && v == "true" {
+			where = append(where, mapz.StatusExpr("t"))
+			continue
+		}
+		where = append(where, "(t.SpanAttributes[?] = ? OR t.ResourceAttributes[?] = ?)")
+		args = append(args, k, v, k, v)
+	}
+
+	limit := min(q.Limit, MaxTraceLimit)
+	if limit <= 0 {
+		limit = defaultTraceLimit
+	}
+	args = append(args, limit)
+
+	query := `
+SELECT t.TraceId
+FROM default.otel_traces AS t
+WHERE t.ResourceAttributes['otelmap.session_token'] = ?`
+	for _, w := range where {
+		query += "\n    AND " + w
+	}
+	query += `
+GROUP BY t.TraceId
+ORDER BY max(t.Timestamp) DESC
+LIMIT ?`
+
+	var traceIDs []string
+	start := time.Now()
+	err := r.db.WithContext(ctx).Raw(query, args...).Scan(&traceIDs).Error
+	metrics.ObserveQuery("jaeger_find_trace_ids", start, err)
+	if err != nil {
+		return nil, errors.Join(errorz.ErrWhileGettingTraces, err)
+	}
+	if len(traceIDs) == 0 {
+		return []Trace{}, nil
+	}
+
+	return r.getTraces(ctx, sessionToken, traceIDs)
+}
+
+func (r *Reader) GetTrace(sessionToken, traceID string) (*Trace, error) {
+	ctx, span := r.otelTracer.Start(r.ctx, "Reader.GetTrace")
+	defer span.End()
+
+	if sessionToken == "" {
+		return nil, errorz.ErrSessionTokenRequired
+	}
+
+	traces, err := r.getTraces(ctx, sessionToken, []string{strings.ToLower(traceID)})
+	if err != nil {
+		return nil, err
+	}
+	if len(traces) == 0 {
+		return nil, errorz.ErrTraceNotFound
+	}
+
+	return &traces[0], nil
+}
+
+func (r *Reader) getTraces(ctx context.Context, sessionToken string, traceIDs []string) ([]Trace, error) {
+	var rows []spanRow
+	start := time.Now()
+	err := r.db.WithContext(ctx).Raw(getSpansQuery, sessionToken, traceIDs).Scan(&rows).Error
+	metrics.ObserveQuery("jaeger_get_spans", start, err)
+	if err != nil {
+		return nil, errors.Join(errorz.ErrWhileGettingTraces, err)
+	}
+
+	return buildTraces(rows), nil
+}
@@ -0,0 +1,126 @@
+package layout
+
+import "math"
+
+const forceIterations = 300
+
+// ForceLayout places nodes with a Fruchterman-Reingold simulation started
+// from a circle, so the result is deterministic for a given node order.
+// Pinned nodes start at, and never leave, their stored position; new nodes
+// start next to their pinned neighbours.
+func ForceLayout(g Graph) Layout {
+	n := len(g.Nodes)
+	index := make(map[string]int, n)
+	for i, id := range g.Nodes {
+		index[id] = i
+	}
+
+	// Ideal edge length; wide enough that node boxes do not overlap.
+	k := layerSpacing
+	radius := math.Max(k, float64(n)*nodeSpacing/(2*math.Pi))
+
+	pos := make([]Point, n)
+	for i := range pos {
+		angle := 2 * math.Pi * float64(i) / math.Max(float64(n), 1)
+		pos[i] = Point{X: radius * math.Cos(angle), Y: radius * math.Sin(angle)}
+	}
+
+	pinned := make([]bool, n)
+	for i, id := range g.Nodes {
+		if p, ok := g.Pinned[id]; ok {
+			pos[i], pinned[i] = p, true
+		}
+	}
+
+	var pairs [][2]int
+	for _, e := range g.Edges {
+		s, okS := index[e[0]]
+		t, okT := index[e[1]]
+		if okS && okT && s != t {
+			pairs = append(pairs, [2]int{s, t})
+		}
+	}
+	if len(g.Pinned) > 0 {
+		for i := range pos {
+			if pinned[i] {
+				continue
+			}
+			var sum Point
+			count := 0
+			for _, p := range pairs {
+				other := -1
+				if p[0] == i {
+					other = p[1]
+				} else if p[1] == i {
+					other = p[0]
+				}
+				if other >= 0 && pinned[other] {
+					sum.X += pos[other].X
+					sum.Y += pos[other].Y
+					count++
+				}
+			}
+			if count > 0 {
+				pos[i] = Point{X: sum.X/float64(count) + k/2, Y: sum.Y/float64(count) + k/2}
+			}
+		}
+	}
+
+	temperature := radius / 2
+	disp := make([]Point, n)
+	for iter := 0; iter < forceIterations; iter++ {
+		for i := range disp {
+			disp[i] = Point{}
+		}
+		for i := 0; i < n; i++ {
+			for j := i + 1; j < n; j++ {
+				dx, dy := pos[i].X-pos[j].X, pos[i].Y-pos[j].Y
+				dist := math.Max(math.Hypot(dx, dy), 0.01)
+				force := k * k / dist
+				disp[i].X += dx / dist * force
+				disp[i].Y += dy / dist * force
+				disp[j].X -= dx / dist * force
+				disp[j].Y -= dy / dist * force
+			}
+		}
+		for _, p := range pairs {
+			dx, dy := pos[p[0]].X-pos[p[1]].X, pos[p[0]].Y-pos[p[1]].Y
+			dist := math.Max(math.Hypot(dx, dy), 0.01)
+			force := dist * dist / k
+			disp[p[0]].X -= dx / dist * force
+			disp[p[0]].Y -= dy / dist * force
+			disp[p[1]].X += dx / dist * force
+			disp[p[1]].Y += dy / dist * force
+		}
+		for i := range pos {
+			if pinned[i] {
+				continue
+			}
+			length := math.Max(math.Hypot(disp[i].X, disp[i].Y), 0.01)
+			step := math.Min(length, temperature)
+			pos[i].X += disp[i].X / length * step
+			pos[i].Y += disp[i].Y / length * step
+		}
+		temperature *= 0.98
+	}
+
+	result := Layout{Algorithm: Force, Nodes: make(map[string]Point, n)}
+	for i, id := range g.Nodes {
+		result.Nodes[id] = pos[i]
+	}
+	for _, e := range g.Edges {
+		s, okS := index[e[0]]
+		t, okT := index[e[1]]
+		if !okS || !okT {
+			continue
+		}
+		result.Edges = append(result.Edges, EdgeRoute{Source: e[0], Target: e[1], Points: []Point{pos[s], pos[t]}})
+	}
+
+	if len(g.Pinned) > 0 {
+		applyPins(&result, g)
+	} else {
+		normalize(&result)
+	}
+	return result
+}
@@ -0,0 +1,194 @@
+package layout
+
+import "sort"
+
+// LayeredLayout places nodes left to right by longest path from the sources,
+// inserting virtual nodes on edges that span several layers and ordering each
+// layer with barycenter sweeps. Pinned nodes seed the initial order of their
+// layer so the ordering stays stable across refreshes. It reports false when
+// g has a cycle.
+func LayeredLayout(g Graph) (Layout, bool) {
+	index := make(map[string]int, len(g.Nodes))
+	for i, id := range g.Nodes {
+		index[id] = i
+	}
+
+	out := make([][]int, len(g.Nodes))
+	indegree := make([]int, len(g.Nodes))
+	seen := make(map[[2]int]bool)
+	for _, e := range g.Edges {
+		s, okS := index[e[0]]
+		t, okT := index[e[1]]
+		if !okS || !okT || s == t || seen[[2]int{s, t}] {
+			continue
+		}
+		seen[[2]int{s, t}] = true
+		out[s] = append(out[s], t)
+		indegree[t]++
+	}
+
+	// Kahn's algorithm, assigning each node the longest distance from a source.
+	layer := make([]int, len(g.Nodes))
+	queue := []int{}
+	for i, d := range indegree {
+		if d == 0 {
+			queue = append(queue, i)
+		}
+	}
+	visited := 0
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		visited++
+		for _, t := range out[n] {
+			if layer[n]+1 > layer[t] {
+				layer[t] = layer[n] + 1
+			}
+			indegree[t]--
+			if indegree[t] == 0 {
+				queue = append(queue, t)
+			}
+		}
+	}
+	if visited != len(g.Nodes) {
+		return Layout{}, false
+	}
+
+	// Split long edges into chains of virtual nodes, one per skipped layer.
+	layerOf := append([]int(nil), layer...)
+	next := make([][]int, len(layerOf))
+	chains := make(map[[2]int][]int)
+	for s := range out {
+		for _, t := range out[s] {
+			chain := []int{s}
+			prev := s
+			for l := layer[s] + 1; l < layer[t]; l++ {
+				v := len(layerOf)
+				layerOf = append(layerOf, l)
+				next = append(next, nil)
+				next[prev] = append(next[prev], v)
+				chain = append(chain, v)
+				prev = v
+			}
+			next[prev] = append(next[prev], t)
+			chains[[2]int{s, t}] = append(chain, t)
+		}
+	}
+
+	prevOf := make([][]int, len(layerOf))
+	for s, ts := range next {
+		for _, t := range ts {
+			prevOf[t] = append(prevOf[t], s)
+		}
+	}
+
+	maxLayer := 0
+	for _, l := range layerOf {
+		if l > maxLayer {
+			maxLayer = l
+		}
+	}
+	layers := make([][]int, maxLayer+1)
+	for n, l := range layerOf {
+		layers[l] = append(layers[l], n)
+	}
+
+	pos := make([]float64, len(layerOf))
+	for _, nodes := range layers {
+		// Reorder pinned nodes by their stored Y within the slots they occupy.
+		var slots, pinnedNodes []int
+		for i, n := range nodes {
+			if _, ok := pinnedY(g, n); ok {
+				slots = append(slots, i)
+				pinnedNodes = append(pinnedNodes, n)
+			}
+		}
+		sort.SliceStable(pinnedNodes, func(i, j int) bool {
+			yi, _ := pinnedY(g, pinnedNodes[i])
+			yj, _ := pinnedY(g, pinnedNodes[j])
+			return yi < yj
+		})
+		for i, slot := range slots {
+			nodes[slot] = pinnedNodes[i]
+		}
+		for i, n := range nodes {
+			pos[n] = float64(i)
+		}
+	}
+
+	barycenter := func(nodes []int, neighbours [][]int) {
+		bc := make(map[int]float64, len(nodes))
+		for _, n := range nodes {
+			if len(neighbours[n]) == 0 {
+				bc[n] = pos[n]
+				continue
+			}
+			sum := 0.0
+			for _, m := range neighbours[n] {
+				sum += pos[m]
+			}
+			bc[n] = sum / float64(len(neighbours[n]))
+		}
+		sort.SliceStable(nodes, func(i, j int) bool { return bc[nodes[i]] < bc[nodes[j]] })
+		for i, n := range nodes {
+			pos[n] = float64(i)
+		}
+	}
+	for sweep := 0; sweep < 4; sweep++ {
+		for l := 1; l < len(layers); l++ {
+			barycenter(layers[l], prevOf)
+		}
+		for l := len(layers) - 2; l >= 0; l-- {
+			barycenter(layers[l], next)
+		}
+	}
+
+	widest := 0
+	for _, nodes := range layers {
+		if len(nodes) > widest {
+			widest = len(nodes)
+		}
+	}
+	coords := make([]Point, len(layerOf))
+	for l, nodes := range layers {
+		offset := float64(widest-len(nodes)) * nodeSpacing / 2
+		for i, n := range nodes {
+			coords[n] = Point{X: float64(l) * layerSpacing, Y: offset + float64(i)*nodeSpacing}
+		}
+	}
+
+	result := Layout{Algorithm: Layered, Nodes: make(map[string]Point, len(g.Nodes))}
+	for i, id := range g.Nodes {
+		result.Nodes[id] = coords[i]
+	}
+	for _, e := range g.Edges {
+		s, okS := index[e[0]]
+		t, okT := index[e[1]]
+		if !okS || !okT {
+			continue
+		}
+		route := EdgeRoute{Source: e[0], Target: e[1]}
+		if chain, ok := chains[[2]int{s, t}]; ok {
+			for _, n := range chain {
+				route.Points = append(route.Points, coords[n])
+			}
+		} else {
+			route.Points = []Point{coords[s], coords[t]}
+		}
+		result.Edges = append(result.Edges, route)
+	}
+
+	normalize(&result)
+	if len(g.Pinned) > 0 {
+		applyPins(&result, g)
+	}
+	return result, true
+}
+
+func pinnedY(g Graph, n int) (float64, bool) {
+	if n >= len(g.Nodes) {
+		return 0, false
+	}
+	p, ok := g.Pinned[g.Nodes[n]]
+	return p.Y, ok
+}
@@ -0,0 +1,217 @@
+package layout
+
+import "math"
+
+const (
+	Layered = "layered"
+	Force   = "force"
+)
+
+const (
+	layerSpacing = 240.0
+	nodeSpacing  = 110.0
+	margin       = 100.0
+)
+
+type Point struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// EdgeRoute is the polyline an edge is drawn along, from the source node
+// centre through any bend points to the target node centre.
+type EdgeRoute struct {
+	Source string  `json:"source"`
+	Target string  `json:"target"`
+	Points []Point `json:"points"`
+}
+
+type Layout struct {
+	Algorithm string           `json:"algorithm"`
+	Width     float64          `json:"width"`
+	Height    float64          `json:"height"`
+	Nodes     map[string]Point `json:"nodes"`
+	Edges     []EdgeRoute      `json:"edges"`
+}
+
+// Graph is the input of a layout: node ids in a stable order and directed
+// edges between them. Parallel edges and self loops are allowed. Pinned nodes
+// keep their position; the others are placed around them.
+type Graph struct {
+	Nodes  []string
+	Edges  [][2]string
+	Pinned map[string]Point
+}
+
+// Auto lays g out in layers when it is acyclic and falls back to a
+// force-directed layout when it contains cycles.
+func Auto(g Graph) Layout {
+	if l, ok := LayeredLayout(g); ok {
+		return l
+	}
+	return ForceLayout(g)
+}
+
+// Compute runs the named algorithm; an empty name means Auto. A layered
+// layout requested for a cyclic graph falls back to force-directed.
+func Compute(algorithm string, g Graph) Layout {
+	switch algorithm {
+	case Force:
+		return ForceLayout(g)
+	default:
+		return Auto(g)
+	}
+}
+
+// Resolve names the algorithm Compute runs for g: force when requested or
+// when g has a cycle, layered otherwise.
+func Resolve(algorithm string, g Graph) string {
+	if algorithm == Force || !acyclic(g) {
+		return Force
+	}
+	return Layered
+}
+
+// acyclic reports whether g has no cycle, ignoring self loops and edges to
+// unknown nodes as LayeredLayout does.
+func acyclic(g Graph) bool {
+	index := make(map[string]int, len(g.Nodes))
+	for i, id := range g.Nodes {
+		index[id] = i
+	}
+	out := make([][]int, len(g.Nodes))
+	indegree := make([]int, len(g.Nodes))
+	for _, e := range g.Edges {
+		s, okS := index[e[0]]
+		t, okT := index[e[1]]
+		if okS && okT && s != t {
+			out[s] = append(out[s], t)
+			indegree[t]++
+		}
+	}
+
+	var queue []int
+	for i, d := range indegree {
+		if d == 0 {
+			queue = append(queue, i)
+		}
+	}
+	visited := 0
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		visited++
+		for _, t := range out[n] {
+			indegree[t]--
+			if indegree[t] == 0 {
+				queue = append(queue, t)
+			}
+		}
+	}
+	return visited == len(g.Nodes)
+}
+
+// Valid reports whether algorithm names a supported layout; empty means Auto.
+func Valid(algorithm string) bool {
+	return algorithm == "" || algorithm == Layered || algorithm == Force
+}
+
+// normalize shifts all points so the drawing starts at (margin, margin) and
+// records the resulting canvas size.
+func normalize(l *Layout) {
+	if len(l.Nodes) == 0 {
+		l.Width, l.Height = 2*margin, 2*margin
+		return
+	}
+
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	visit := func(p Point) {
+		minX, maxX = math.Min(minX, p.X), math.Max(maxX, p.X)
+		minY, maxY = math.Min(minY, p.Y), math.Max(maxY, p.Y)
+	}
+	for _, p := range l.Nodes {
+		visit(p)
+	}
+	for _, e := range l.Edges {
+		for _, p := range e.Points {
+			visit(p)
+		}
+	}
+
+	dx, dy := margin-minX, margin-minY
+	for id, p := range l.Nodes {
+		l.Nodes[id] = Point{X: round(p.X + dx), Y: round(p.Y + dy)}
+	}
+	for i := range l.Edges {
+		for j, p := range l.Edges[i].Points {
+			l.Edges[i].Points[j] = Point{X: round(p.X + dx), Y: round(p.Y + dy)}
+		}
+	}
+	l.Width = round(maxX - minX + 2*margin)
+	l.Height = round(maxY - minY + 2*margin)
+}
+
+// applyPins moves pinned nodes back to their stored position and shifts the
+// remaining nodes down until they no longer overlap a placed node. Edges whose
+// endpoints moved are redrawn as straight lines. Unlike normalize, it never
+// translates the drawing, so pinned coordinates stay exactly as stored. Like
+// normalize, it keeps the canvas at least 2*margin wide and high.
+func applyPins(l *Layout, g Graph) {
+	placed := make(map[string]Point, len(g.Nodes))
+	for _, id := range g.Nodes {
+		if p, ok := g.Pinned[id]; ok {
+			placed[id] = p
+		}
+	}
+	// Pins of nodes that are no longer on the map, e.g. filtered out, are
+	// ignored.
+	if len(placed) == 0 {
+		normalize(l)
+		return
+	}
+
+	overlaps := func(p Point) bool {
+		for _, q := range placed {
+			if math.Abs(p.X-q.X) < layerSpacing*0.75 && math.Abs(p.Y-q.Y) < nodeSpacing*0.75 {
+				return true
+			}
+		}
+		return false
+	}
+	for _, id := range g.Nodes {
+		if _, ok := placed[id]; ok {
+			continue
+		}
+		p := l.Nodes[id]
+		p.X, p.Y = math.Max(p.X, margin), math.Max(p.Y, margin)
+		for overlaps(p) {
+			p.Y += nodeSpacing
+		}
+		placed[id] = Point{X: round(p.X), Y: round(p.Y)}
+	}
+
+	for i, e := range l.Edges {
+		s, t := placed[e.Source], placed[e.Target]
+		if s != l.Nodes[e.Source] || t != l.Nodes[e.Target] {
+			l.Edges[i].Points = []Point{s, t}
+		}
+	}
+	l.Nodes = placed
+
+	l.Width, l.Height = 2*margin, 2*margin
+	for _, p := range placed {
+		l.Width = math.Max(l.Width, round(p.X+margin))
+		l.Height = math.Max(l.Height, round(p.Y+margin))
+	}
+	for _, e := range l.Edges {
+		for _, p := range e.Points {
+			l.Width = math.Max(l.Width, round(p.X+margin))
+			l.Height = math.Max(l.Height, round(p.Y+margin))
+		}
+	}
+}
+
+func round(f float64) float64 {
+	return math.Round(f*100) / 100
+}
@@ -0,0 +1,86 @@
+package layout
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+	"github.com/jack5341/otel-map-server/internal/metrics"
+	"github.com/jack5341/otel-map-server/internal/models"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const getNodePositionsQuery = `
+SELECT
+    node,
+    argMax(x, updated_at) AS x,
+    argMax(y, updated_at) AS y
+FROM node_positions
+WHERE token = ? AND algorithm = ?
+GROUP BY node
+`
+
+// Store persists node positions per session and algorithm so layouts stay
+// stable between refreshes.
+type Store struct {
+	db         *gorm.DB
+	otelTracer trace.Tracer
+	ctx        context.Context
+}
+
+func NewStore(db *gorm.DB, otelTracer trace.Tracer, ctx context.Context) *Store {
+	return &Store{db: db, otelTracer: otelTracer, ctx: ctx}
+}
+
+// Load returns the stored positions keyed by node id.
+func (s *Store) Load(sessionToken uuid.UUID, algorithm string) (map[string]Point, error) {
+	ctx, span := s.otelTracer.Start(s.ctx, "Store.Load")
+	defer span.End()
+
+	var rows []models.NodePosition
+	start := time.Now()
+	err := s.db.WithContext(ctx).Raw(getNodePositionsQuery, sessionToken, algorithm).Scan(&rows).Error
+	metrics.ObserveQuery("get_node_positions", start, err)
+	if err != nil {
+		return nil, errors.Join(errorz.ErrWhileGettingLayout, err)
+	}
+
+	positions := make(map[string]Point, len(rows))
+	for _, row := range rows {
+		positions[row.Node] = Point{X: row.X, Y: row.Y}
+	}
+	return positions, nil
+}
+
+// Save stores the positions of nodes in l that are not already in previous.
+func (s *Store) Save(sessionToken uuid.UUID, algorithm string, l Layout, previous map[string]Point) error {
+	ctx, span := s.otelTracer.Start(s.ctx, "Store.Save")
+	defer span.End()
+
+	now := time.Now().UTC()
+	var rows []models.NodePosition
+	for node, p := range l.Nodes {
+		if old, ok := previous[node]; ok && old == p {
+			continue
+		}
+		rows = append(rows, models.NodePosition{
+			Token:     sessionToken,
+			Algorithm: algorithm,
+			Node:      node,
+			X:         p.X,
+			Y:         p.Y,
+			UpdatedAt: now,
+		})
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	if err := s.db.WithContext(ctx).Create(&rows).Error; err != nil {
+		return errors.Join(errorz.ErrWhileSavingLayout, err)
+	}
+	return nil
+}
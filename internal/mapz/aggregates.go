@@ -0,0 +1,382 @@
+package mapz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+	"github.com/jack5341/otel-map-server/internal/metrics"
+	"github.com/jack5341/otel-map-server/internal/models"
+)
+
+// The per-minute stats tables. Service stats are filled by a materialized
+// view as spans are inserted. Edge stats join each span to its parent, which
+// is usually exported in a different insert, so they are rolled up once a
+// minute has closed; RollUpEdges does so and the coverage watermark records
+// how far.
+const (
+	ServiceStatsTable = "service_stats_1m"
+	EdgeStatsTable    = "edge_stats_1m"
+)
+
+// AggregateQuantiles are the levels the stats tables' duration digests are
+// declared with. The digests can be merged into any other levels.
+const AggregateQuantiles = "0.5, 0.9, 0.95, 0.99"
+
+// ParentLookback bounds how long before a span its parent may have started
+// for the edge rollup to find it.
+const ParentLookback = 15 * time.Minute
+
+const getAggregateCoverageQuery = `
+SELECT
+    name,
+    argMax(covered_from, updated_at) AS covered_from,
+    argMax(watermark, updated_at) AS watermark,
+    max(updated_at) AS updated_at
+FROM aggregate_coverage
+WHERE name = ?
+GROUP BY name
+`
+
+const getSessionCreatedQuery = `
+SELECT min(created_at) AS created_at
+FROM session_tokens
+WHERE token = ?
+`
+
+const getServiceStatsQuery = `
+SELECT
+    s.service_name AS service_name,
+    toInt64(sum(s.requests)) AS total_requests,
+    toInt64(sum(s.errors)) AS error_count,
+    ROUND(error_count / total_requests, 4) AS error_rate,
+    quantilesTDigestMerge(0.5, 0.9, 0.95)(s.duration_digest) AS standard_quantiles,
+    ROUND(standard_quantiles[1] / 1000000, 2) AS latency_p50_ms,
+    ROUND(standard_quantiles[2] / 1000000, 2) AS latency_p90_ms,
+    ROUND(standard_quantiles[3] / 1000000, 2) AS latency_p95_ms,
+    ROUND(max(s.max_duration) / 1000000, 2) AS latency_max_ms,
+    %[2]s AS quantile_values
+FROM service_stats_1m AS s
+WHERE s.session_token = ?%[1]s
+GROUP BY service_name
+ORDER BY total_requests DESC
+`
+
+const getStatsWindowQuery = `
+SELECT toInt64(toUnixTimestamp(max(s.last_seen)) - toUnixTimestamp(min(s.first_seen))) AS window_seconds
+FROM service_stats_1m AS s
+WHERE s.session_token = ?%[1]s
+`
+
+// getEdgeStatsQuery merges the rolled-up minutes before the watermark with
+// the edges of the spans that started after it.
+const getEdgeStatsQuery = `
+WITH Spans AS (
+    SELECT
+        t.TraceId AS TraceId,
+        t.SpanId AS SpanId,
+        t.ParentSpanId AS ParentSpanId,
+        t.ServiceName AS ServiceName,
+        multiIf(
+            has(t.SpanAttributes, 'http.route'),
+            t.SpanAttributes['http.method'] || ' ' || t.SpanAttributes['http.route'],
+            t.SpanName
+        ) AS Path,
+        %[2]s AS IsError,
+        t.Duration AS Duration,
+        t.Timestamp AS Timestamp
+    FROM default.otel_traces AS t
+    WHERE t.ResourceAttributes['otelmap.session_token'] = ?
+        AND t.TraceId IN (
+            SELECT t.TraceId
+            FROM default.otel_traces AS t
+            WHERE t.ResourceAttributes['otelmap.session_token'] = ?
+                AND t.Timestamp >= ?
+        )
+    GROUP BY TraceId, SpanId, ParentSpanId, ServiceName, Path, IsError, Duration, Timestamp
+)
+
+SELECT
+    e.source_service_name AS source_service_name,
+    e.target_service_name AS target_service_name,
+    e.target_service_path AS target_service_path,
+    sum(e.requests) AS total_requests,
+    sum(e.errors) AS error_count,
+    ROUND(error_count / total_requests, 4) AS error_rate,
+    ROUND(max(e.max_duration) / 1000000, 2) AS latency_max_ms,
+    %[3]s AS quantile_values
+FROM (
+    SELECT
+        s.source_service_name AS source_service_name,
+        s.target_service_name AS target_service_name,
+        s.target_service_path AS target_service_path,
+        s.requests AS requests,
+        s.errors AS errors,
+        s.max_duration AS max_duration,
+        s.duration_digest AS duration_digest
+    FROM edge_stats_1m AS s
+    WHERE s.session_token = ?
+        AND s.minute < ?%[1]s
+
+    UNION ALL
+
+    SELECT
+        p.ServiceName AS source_service_name,
+        c.ServiceName AS target_service_name,
+        c.Path AS target_service_path,
+        count() AS requests,
+        countIf(c.IsError) AS errors,
+        max(c.Duration) AS max_duration,
+        quantilesTDigestState(` + AggregateQuantiles + `)(c.Duration) AS duration_digest
+    FROM Spans AS c
+    INNER JOIN Spans AS p
+        ON c.ParentSpanId = p.SpanId
+        AND c.TraceId = p.TraceId
+    WHERE c.ParentSpanId != '' AND c.Timestamp >= ?
+    GROUP BY source_service_name, target_service_name, target_service_path
+) AS e
+GROUP BY source_service_name, target_service_name, target_service_path
+ORDER BY source_service_name, target_service_name, target_service_path
+`
+
+// rollUpEdgesQuery aggregates the edges into the spans that started in one
+// minute, across all sessions. The deduplication token makes a repeated
+// rollup of the minute, by a retry or another replica, a no-op.
+const rollUpEdgesQuery = `
+INSERT INTO edge_stats_1m
+SETTINGS insert_deduplication_token = '%[1]s'
+SELECT
+    c.session_token AS session_token,
+    p.ServiceName AS source_service_name,
+    c.ServiceName AS target_service_name,
+    c.Path AS target_service_path,
+    toStartOfMinute(c.Timestamp) AS minute,
+    count() AS requests,
+    countIf(c.IsError) AS errors,
+    max(c.Duration) AS max_duration,
+    quantilesTDigestState(` + AggregateQuantiles + `)(c.Duration) AS duration_digest
+FROM (
+    SELECT
+        t.ResourceAttributes['otelmap.session_token'] AS session_token,
+        t.TraceId AS TraceId,
+        t.SpanId AS SpanId,
+        t.ParentSpanId AS ParentSpanId,
+        t.ServiceName AS ServiceName,
+        multiIf(
+            has(t.SpanAttributes, 'http.route'),
+            t.SpanAttributes['http.method'] || ' ' || t.SpanAttributes['http.route'],
+            t.SpanName
+        ) AS Path,
+        %[2]s AS IsError,
+        t.Duration AS Duration,
+        t.Timestamp AS Timestamp
+    FROM default.otel_traces AS t
+    WHERE t.Timestamp >= ? AND t.Timestamp < ?
+        AND t.ParentSpanId != ''
+        AND session_token != ''
+    GROUP BY session_token, TraceId, SpanId, ParentSpanId, ServiceName, Path, IsError, Duration, Timestamp
+) AS c
+INNER JOIN (
+    SELECT
+        t.ResourceAttributes['otelmap.session_token'] AS session_token,
+        t.TraceId AS TraceId,
+        t.SpanId AS SpanId,
+        any(t.ServiceName) AS ServiceName
+    FROM default.otel_traces AS t
+    WHERE t.Timestamp >= ? AND t.Timestamp < ?
+        AND session_token != ''
+    GROUP BY session_token, TraceId, SpanId
+) AS p
+    ON c.session_token = p.session_token
+    AND c.TraceId = p.TraceId
+    AND c.ParentSpanId = p.SpanId
+GROUP BY session_token, source_service_name, target_service_name, target_service_path, minute
+`
+
+// GetAggregateCoverage returns the coverage of a stats table; ok is false
+// when the table has not been created.
+func (m *Mapper) GetAggregateCoverage(name string) (models.AggregateCoverage, bool, error) {
+	ctx, span := m.otelTracer.Start(m.ctx, "Mapper.GetAggregateCoverage")
+	defer span.End()
+
+	var rows []models.AggregateCoverage
+	start := time.Now()
+	err := m.db.WithContext(ctx).Raw(getAggregateCoverageQuery, name).Scan(&rows).Error
+	metrics.ObserveQuery("get_aggregate_coverage", start, err)
+	if err != nil {
+		return models.AggregateCoverage{}, false, errors.Join(errorz.ErrWhileGettingAggregates, err)
+	}
+	if len(rows) == 0 {
+		return models.AggregateCoverage{}, false, nil
+	}
+	return rows[0], true, nil
+}
+
+func (m *Mapper) SaveAggregateCoverage(coverage models.AggregateCoverage) error {
+	ctx, span := m.otelTracer.Start(m.ctx, "Mapper.SaveAggregateCoverage")
+	defer span.End()
+
+	coverage.UpdatedAt = time.Now().UTC()
+	if err := m.db.WithContext(ctx).Create(&coverage).Error; err != nil {
+		return errors.Join(errorz.ErrWhileSavingAggregates, err)
+	}
+	return nil
+}
+
+// RollUpEdges aggregates the edges into the spans that started in the minute
+// beginning at minute, for every session.
+func (m *Mapper) RollUpEdges(minute time.Time) error {
+	ctx, span := m.otelTracer.Start(m.ctx, "Mapper.RollUpEdges")
+	defer span.End()
+
+	minute = minute.UTC().Truncate(time.Minute)
+	end := minute.Add(time.Minute)
+	query := fmt.Sprintf(rollUpEdgesQuery, EdgeStatsTable+"_"+strconv.FormatInt(minute.Unix(), 10), StatusExpr("t"))
+
+	start := time.Now()
+	err := m.db.WithContext(ctx).Exec(query, minute, end, minute.Add(-ParentLookback), end).Error
+	metrics.ObserveQuery("roll_up_edges", start, err)
+	if err != nil {
+		return errors.Join(errorz.ErrWhileSavingAggregates, err)
+	}
+	return nil
+}
+
+// aggregates returns the coverage of both stats tables when they hold every
+// span the request reads: the session, or the requested window of it,
+// started after the stats began. Requests that need raw spans, see
+// needsSpans, never read the stats.
+func (m *Mapper) aggregates(sessionToken string) (services, edges models.AggregateCoverage, ok bool, err error) {
+	if spans, err := m.needsSpans(sessionToken); err != nil || spans {
+		return services, edges, false, err
+	}
+
+	var servicesOK, edgesOK bool
+	if services, servicesOK, err = m.GetAggregateCoverage(ServiceStatsTable); err != nil || !servicesOK {
+		return services, edges, false, err
+	}
+	if edges, edgesOK, err = m.GetAggregateCoverage(EdgeStatsTable); err != nil || !edgesOK {
+		return services, edges, false, err
+	}
+
+	from := m.opts.Since
+	if created, err := m.sessionCreated(sessionToken); err != nil {
+		return services, edges, false, err
+	} else if created.After(from) {
+		from = created
+	}
+	if from.IsZero() || from.Before(services.CoveredFrom) || from.Before(edges.CoveredFrom) {
+		return services, edges, false, nil
+	}
+	return services, edges, true, nil
+}
+
+func (m *Mapper) sessionCreated(sessionToken string) (time.Time, error) {
+	ctx, span := m.otelTracer.Start(m.ctx, "Mapper.sessionCreated")
+	defer span.End()
+
+	token, err := uuid.Parse(sessionToken)
+	if err != nil {
+		return time.Time{}, errorz.ErrInvalidSessionToken
+	}
+
+	var created time.Time
+	start := time.Now()
+	err = m.db.WithContext(ctx).Raw(getSessionCreatedQuery, token).Scan(&created).Error
+	metrics.ObserveQuery("get_session_created", start, err)
+	if err != nil {
+		return time.Time{}, errors.Join(errorz.ErrWhileGettingAggregates, err)
+	}
+	if created.Unix() <= 0 {
+		return time.Time{}, nil
+	}
+	return created, nil
+}
+
+// statsSince bounds the minutes read from a stats table to the requested
+// window, to the minute.
+func (m *Mapper) statsSince() (string, []any) {
+	if m.opts.Since.IsZero() {
+		return "", nil
+	}
+	return "\n        AND s.minute >= ?", []any{m.opts.Since.UTC().Truncate(time.Minute)}
+}
+
+func (m *Mapper) getServicesFromAggregates(ctx context.Context, sessionToken string) ([]Service, error) {
+	since, sinceArgs := m.statsSince()
+	query := fmt.Sprintf(getServiceStatsQuery, since, mergedQuantilesExpr("s.duration_digest", m.opts.Quantiles))
+	args := append([]any{sessionToken}, sinceArgs...)
+
+	var services []Service
+	start := time.Now()
+	err := m.db.WithContext(ctx).Raw(query, args...).Scan(&services).Error
+	metrics.ObserveQuery("get_service_stats", start, err)
+	if err != nil {
+		return nil, errors.Join(errorz.ErrWhileGettingServicesWithMetrics, err)
+	}
+
+	for i := range services {
+		svc := &services[i]
+		svc.ID = svc.ServiceName
+		svc.LatencyQuantiles = zipQuantiles(m.opts.Quantiles, svc.QuantileValues)
+	}
+	return services, nil
+}
+
+func (m *Mapper) getEdgesFromAggregates(ctx context.Context, sessionToken string, coverage models.AggregateCoverage) ([]Edge, error) {
+	rawFrom := coverage.Watermark
+	if m.opts.Since.After(rawFrom) {
+		rawFrom = m.opts.Since
+	}
+	since, sinceArgs := m.statsSince()
+	query := fmt.Sprintf(getEdgeStatsQuery, since, StatusExpr("t"), mergedQuantilesExpr("e.duration_digest", m.opts.Quantiles))
+	args := []any{sessionToken, sessionToken, rawFrom, sessionToken, coverage.Watermark}
+	args = append(args, sinceArgs...)
+	args = append(args, rawFrom)
+
+	var edges []Edge
+	start := time.Now()
+	err := m.db.WithContext(ctx).Raw(query, args...).Scan(&edges).Error
+	metrics.ObserveQuery("get_edge_stats", start, err)
+	if err != nil {
+		return nil, errors.Join(errorz.ErrWhileGettingEdges, err)
+	}
+
+	var windowSeconds int64
+	start = time.Now()
+	err = m.db.WithContext(ctx).Raw(fmt.Sprintf(getStatsWindowQuery, since), append([]any{sessionToken}, sinceArgs...)...).Scan(&windowSeconds).Error
+	metrics.ObserveQuery("get_stats_window", start, err)
+	if err != nil {
+		return nil, errors.Join(errorz.ErrWhileGettingEdges, err)
+	}
+
+	for i := range edges {
+		e := &edges[i]
+		e.SourceID = e.SourceServiceName
+		e.TargetID = e.TargetServiceName
+		e.LatencyQuantiles = zipQuantiles(m.opts.Quantiles, e.QuantileValues)
+		if windowSeconds >= 1 {
+			e.RequestsPerSecond = math.Round(float64(e.TotalRequests)/float64(windowSeconds)*100) / 100
+		}
+	}
+	return edges, nil
+}
+
+// mergedQuantilesExpr merges the duration digests in column, in nanoseconds,
+// into the given quantiles in milliseconds.
+func mergedQuantilesExpr(column string, quantiles []float64) string {
+	if len(quantiles) == 0 {
+		return "CAST([], 'Array(Float64)')"
+	}
+	levels := make([]string, len(quantiles))
+	for i, q := range quantiles {
+		levels[i] = strconv.FormatFloat(q, 'f', -1, 64)
+	}
+	return fmt.Sprintf("arrayMap(x -> round(x / 1000000, 2), quantilesTDigestMerge(%s)(%s))", strings.Join(levels, ", "), column)
+}
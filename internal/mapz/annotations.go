@@ -0,0 +1,209 @@
+package mapz
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+	"github.com/jack5341/otel-map-server/internal/metrics"
+	"github.com/jack5341/otel-map-server/internal/models"
+)
+
+const (
+	AnnotationDeploy      = "deploy"
+	AnnotationFeatureFlag = "feature_flag"
+	AnnotationIncident    = "incident"
+	AnnotationNote        = "note"
+	// AnnotationVersion marks the first span of a new service.version; these
+	// are detected from spans rather than posted.
+	AnnotationVersion = "version"
+)
+
+const maxAnnotationText = 4096
+
+const getAnnotationsQuery = `
+SELECT
+    id,
+    argMax(kind, updated_at) AS kind,
+    argMax(service_name, updated_at) AS service_name,
+    argMax(version, updated_at) AS version,
+    argMax(time, updated_at) AS time,
+    argMax(text, updated_at) AS text,
+    argMax(deleted, updated_at) AS deleted
+FROM annotations
+WHERE token = ?
+GROUP BY id
+HAVING NOT deleted
+`
+
+const getVersionChangesQuery = `
+SELECT
+    t.ServiceName AS service_name,
+    t.ResourceAttributes[?] AS version,
+    min(t.Timestamp) AS first_seen
+FROM otel_traces AS t
+WHERE t.ResourceAttributes['otelmap.session_token'] = ?
+    AND has(t.ResourceAttributes, ?)
+GROUP BY service_name, version
+ORDER BY service_name, first_seen
+`
+
+// Annotation marks a change, such as a deploy, a feature flag flip or an
+// incident, at a point in time. ServiceName and Version are optional.
+// Detected is set on version changes found in spans, which have no ID.
+type Annotation struct {
+	ID          uuid.UUID `json:"id,omitzero"`
+	Kind        string    `json:"kind"`
+	ServiceName string    `json:"service_name,omitempty"`
+	Version     string    `json:"version,omitempty"`
+	Time        time.Time `json:"time"`
+	Text        string    `json:"text,omitempty"`
+	Detected    bool      `json:"detected,omitempty"`
+}
+
+type versionFirstSeen struct {
+	ServiceName string
+	Version     string
+	FirstSeen   time.Time
+}
+
+func (a Annotation) Validate() error {
+	switch a.Kind {
+	case AnnotationDeploy, AnnotationFeatureFlag, AnnotationIncident, AnnotationNote:
+	default:
+		return errorz.ErrInvalidAnnotation
+	}
+	if len(a.Text) > maxAnnotationText {
+		return errorz.ErrInvalidAnnotation
+	}
+	return nil
+}
+
+// GetAnnotations returns the posted annotations and detected version changes
+// of the session between from and to, oldest first. A zero bound is open.
+func (m *Mapper) GetAnnotations(sessionToken string, from, to time.Time) ([]Annotation, error) {
+	ctx, span := m.otelTracer.Start(m.ctx, "Mapper.GetAnnotations")
+	defer span.End()
+
+	token, err := uuid.Parse(sessionToken)
+	if err != nil {
+		return nil, errorz.ErrInvalidSessionToken
+	}
+
+	var rows []models.Annotation
+	start := time.Now()
+	err = m.db.WithContext(ctx).Raw(getAnnotationsQuery, token).Scan(&rows).Error
+	metrics.ObserveQuery("get_annotations", start, err)
+	if err != nil {
+		return nil, errors.Join(errorz.ErrWhileGettingAnnotations, err)
+	}
+
+	var seen []versionFirstSeen
+	start = time.Now()
+	err = m.db.WithContext(ctx).Raw(getVersionChangesQuery, VersionAttribute, sessionToken, VersionAttribute).Scan(&seen).Error
+	metrics.ObserveQuery("get_version_changes", start, err)
+	if err != nil {
+		return nil, errors.Join(errorz.ErrWhileGettingAnnotations, err)
+	}
+
+	annotations := []Annotation{}
+	keep := func(a Annotation) {
+		if (from.IsZero() || !a.Time.Before(from)) && (to.IsZero() || !a.Time.After(to)) {
+			annotations = append(annotations, a)
+		}
+	}
+	for _, row := range rows {
+		keep(Annotation{
+			ID:          row.ID,
+			Kind:        row.Kind,
+			ServiceName: row.ServiceName,
+			Version:     row.Version,
+			Time:        row.Time.UTC(),
+			Text:        row.Text,
+		})
+	}
+	// The first version of each service was there from the start; every
+	// later one is a change.
+	for i, s := range seen {
+		if i == 0 || seen[i-1].ServiceName != s.ServiceName {
+			continue
+		}
+		keep(Annotation{
+			Kind:        AnnotationVersion,
+			ServiceName: s.ServiceName,
+			Version:     s.Version,
+			Time:        s.FirstSeen.UTC(),
+			Text:        s.ServiceName + " " + s.Version + " replaced " + seen[i-1].Version,
+			Detected:    true,
+		})
+	}
+
+	sort.SliceStable(annotations, func(i, j int) bool {
+		return annotations[i].Time.Before(annotations[j].Time)
+	})
+	return annotations, nil
+}
+
+// SaveAnnotation stores a new annotation, or replaces the one with the same
+// ID. A missing time defaults to now.
+func (m *Mapper) SaveAnnotation(sessionToken string, a Annotation) (Annotation, error) {
+	ctx, span := m.otelTracer.Start(m.ctx, "Mapper.SaveAnnotation")
+	defer span.End()
+
+	token, err := uuid.Parse(sessionToken)
+	if err != nil {
+		return Annotation{}, errorz.ErrInvalidSessionToken
+	}
+	if err := a.Validate(); err != nil {
+		return Annotation{}, err
+	}
+	if a.ID == uuid.Nil {
+		a.ID = uuid.New()
+	}
+	if a.Time.IsZero() {
+		a.Time = time.Now()
+	}
+	a.Time = a.Time.UTC()
+
+	if err := m.db.WithContext(ctx).Create(annotationRow(token, a, false)).Error; err != nil {
+		return Annotation{}, errors.Join(errorz.ErrWhileSavingAnnotation, err)
+	}
+	return a, nil
+}
+
+func (m *Mapper) DeleteAnnotation(sessionToken string, id uuid.UUID) error {
+	ctx, span := m.otelTracer.Start(m.ctx, "Mapper.DeleteAnnotation")
+	defer span.End()
+
+	annotations, err := m.GetAnnotations(sessionToken, time.Time{}, time.Time{})
+	if err != nil {
+		return err
+	}
+	for _, a := range annotations {
+		if a.Detected || a.ID != id {
+			continue
+		}
+		token, _ := uuid.Parse(sessionToken)
+		if err := m.db.WithContext(ctx).Create(annotationRow(token, a, true)).Error; err != nil {
+			return errors.Join(errorz.ErrWhileSavingAnnotation, err)
+		}
+		return nil
+	}
+	return errorz.ErrAnnotationNotFound
+}
+
+func annotationRow(token uuid.UUID, a Annotation, deleted bool) *models.Annotation {
+	return &models.Annotation{
+		ID:          a.ID,
+		Token:       token,
+		Kind:        a.Kind,
+		ServiceName: a.ServiceName,
+		Version:     a.Version,
+		Time:        a.Time,
+		Text:        a.Text,
+		Deleted:     deleted,
+		UpdatedAt:   time.Now().UTC(),
+	}
+}
@@ -0,0 +1,137 @@
+package mapz
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jack5341/otel-map-server/internal/metrics"
+	"golang.org/x/sync/singleflight"
+)
+
+// cacheLoadTimeout bounds a shared load, which outlives the request that
+// started it when that request goes away.
+const cacheLoadTimeout = 10 * time.Second
+
+// Cache holds map query results for a short TTL, so dashboards refreshing
+// the same map from many viewers share one query. Concurrent identical
+// queries are coalesced into one while it runs. Errors are not cached.
+type Cache struct {
+	ttl     time.Duration
+	group   singleflight.Group
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	swept   time.Time
+}
+
+type cacheEntry struct {
+	value   any
+	expires time.Time
+}
+
+// NewCache returns a cache holding results for ttl. A zero ttl still
+// coalesces concurrent queries but keeps no results.
+func NewCache(ttl time.Duration) *Cache {
+	return &Cache{ttl: ttl, entries: map[string]cacheEntry{}}
+}
+
+// WithCache returns a copy of the mapper that reads nodes and edges through
+// the cache.
+func (m *Mapper) WithCache(cache *Cache) *Mapper {
+	c := *m
+	c.cache = cache
+	return &c
+}
+
+func (c *Cache) get(key string, now time.Time) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || !now.Before(entry.expires) {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+func (c *Cache) set(key string, value any, now time.Time) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Drop expired entries at most once per TTL, so sessions no longer
+	// viewed do not pile up.
+	if now.Sub(c.swept) >= c.ttl {
+		for k, entry := range c.entries {
+			if !now.Before(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+		c.swept = now
+	}
+	c.entries[key] = cacheEntry{value: value, expires: now.Add(c.ttl)}
+}
+
+// cacheKey identifies a query by session, options and any further
+// arguments. Windows ending now move on every request, so Since is truncated
+// to the TTL: requests within one TTL share a result.
+func (m *Mapper) cacheKey(query, sessionToken string, args ...any) string {
+	o := m.opts
+	since := o.Since
+	if m.cache != nil && m.cache.ttl > 0 {
+		since = since.Truncate(m.cache.ttl)
+	}
+	var policy ErrorPolicy
+	if o.Errors != nil {
+		policy = *o.Errors
+	}
+	return fmt.Sprintf("%q|%q|%q|%q|%q|%t|%+v|%v|%t|%d|%q|%v",
+		query, sessionToken, o.SplitBy, o.GroupBy, o.Filter.key(), o.Errors != nil, policy,
+		o.Quantiles, o.Histogram, since.UnixNano(), o.Source, args)
+}
+
+// cached returns the result of load for the query, from the cache when it
+// holds one. The first request runs load on a context detached from its own,
+// so that requests sharing the result are not failed by it going away; each
+// request waits on its own context. Callers get a copy made by clone, so
+// they can set fields on the result.
+func cached[T any](m *Mapper, query, key string, load func(*Mapper) (T, error), clone func(T) T) (T, error) {
+	var zero T
+	if m.cache == nil {
+		return load(m)
+	}
+
+	if value, ok := m.cache.get(key, time.Now()); ok {
+		metrics.ObserveCache(query, metrics.CacheHit)
+		return clone(value.(T)), nil
+	}
+
+	results := m.cache.group.DoChan(key, func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(m.ctx), cacheLoadTimeout)
+		defer cancel()
+		loader := *m
+		loader.ctx = ctx
+		value, err := load(&loader)
+		if err != nil {
+			return nil, err
+		}
+		m.cache.set(key, value, time.Now())
+		return value, nil
+	})
+
+	select {
+	case <-m.ctx.Done():
+		return zero, m.ctx.Err()
+	case res := <-results:
+		if res.Shared {
+			metrics.ObserveCache(query, metrics.CacheShared)
+		} else {
+			metrics.ObserveCache(query, metrics.CacheMiss)
+		}
+		if res.Err != nil {
+			return zero, res.Err
+		}
+		return clone(res.Val.(T)), nil
+	}
+}
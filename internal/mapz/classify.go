@@ -0,0 +1,125 @@
+package mapz
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+	"github.com/jack5341/otel-map-server/internal/metrics"
+	"github.com/jack5341/otel-map-server/internal/models"
+)
+
+// ErrorStatusCodes are the upper-cased span status codes that mean error.
+// Depending on its version the ClickHouse exporter writes the numeric code,
+// the short name or the protobuf enum name.
+var ErrorStatusCodes = []string{"2", "ERROR", "STATUS_CODE_ERROR"}
+
+const getErrorPolicyQuery = `
+SELECT
+    argMax(http_server_errors, updated_at) AS http_server_errors,
+    argMax(grpc_errors, updated_at) AS grpc_errors,
+    argMax(exception_events, updated_at) AS exception_events
+FROM error_policies
+WHERE token = ?
+GROUP BY token
+`
+
+// ErrorPolicy decides which spans count as errors. A span with an error
+// status always does; the flags add HTTP 5xx responses, non-OK gRPC codes
+// and spans that recorded an exception event.
+type ErrorPolicy struct {
+	HTTPServerErrors bool `json:"http_server_errors"`
+	GRPCErrors       bool `json:"grpc_errors"`
+	ExceptionEvents  bool `json:"exception_events"`
+}
+
+// IsErrorStatus reports whether a span status code, as written by any
+// exporter version, means error.
+func IsErrorStatus(code string) bool {
+	code = strings.ToUpper(code)
+	for _, c := range ErrorStatusCodes {
+		if code == c {
+			return true
+		}
+	}
+	return false
+}
+
+// StatusExpr is the SQL condition matching spans of alias with an error status.
+func StatusExpr(alias string) string {
+	return "upper(" + alias + ".StatusCode) IN ('" + strings.Join(ErrorStatusCodes, "', '") + "')"
+}
+
+// Expr is the SQL condition matching error spans of alias under the policy.
+// It only contains constants, so it can be spliced into queries.
+func (p ErrorPolicy) Expr(alias string) string {
+	conditions := []string{StatusExpr(alias)}
+	if p.HTTPServerErrors {
+		conditions = append(conditions, "toUInt16OrZero(if(has("+alias+".SpanAttributes, 'http.response.status_code'), "+
+			alias+".SpanAttributes['http.response.status_code'], "+alias+".SpanAttributes['http.status_code'])) >= 500")
+	}
+	if p.GRPCErrors {
+		conditions = append(conditions, alias+".SpanAttributes['rpc.grpc.status_code'] NOT IN ('', '0')")
+	}
+	if p.ExceptionEvents {
+		conditions = append(conditions, "has("+alias+".Events.Name, 'exception')")
+	}
+	return "(" + strings.Join(conditions, " OR ") + ")"
+}
+
+// GetErrorPolicy returns the policy stored for a session, or the zero policy
+// when none was configured.
+func (m *Mapper) GetErrorPolicy(sessionToken string) (ErrorPolicy, error) {
+	ctx, span := m.otelTracer.Start(m.ctx, "Mapper.GetErrorPolicy")
+	defer span.End()
+
+	token, err := uuid.Parse(sessionToken)
+	if err != nil {
+		return ErrorPolicy{}, errorz.ErrInvalidSessionToken
+	}
+
+	var policies []ErrorPolicy
+	start := time.Now()
+	err = m.db.WithContext(ctx).Raw(getErrorPolicyQuery, token).Scan(&policies).Error
+	metrics.ObserveQuery("get_error_policy", start, err)
+	if err != nil {
+		return ErrorPolicy{}, errors.Join(errorz.ErrWhileGettingErrorPolicy, err)
+	}
+	if len(policies) == 0 {
+		return ErrorPolicy{}, nil
+	}
+	return policies[0], nil
+}
+
+func (m *Mapper) SaveErrorPolicy(sessionToken string, policy ErrorPolicy) error {
+	ctx, span := m.otelTracer.Start(m.ctx, "Mapper.SaveErrorPolicy")
+	defer span.End()
+
+	token, err := uuid.Parse(sessionToken)
+	if err != nil {
+		return errorz.ErrInvalidSessionToken
+	}
+
+	row := models.ErrorPolicy{
+		Token:            token,
+		HTTPServerErrors: policy.HTTPServerErrors,
+		GRPCErrors:       policy.GRPCErrors,
+		ExceptionEvents:  policy.ExceptionEvents,
+		UpdatedAt:        time.Now().UTC(),
+	}
+	if err := m.db.WithContext(ctx).Create(&row).Error; err != nil {
+		return errors.Join(errorz.ErrWhileSavingErrorPolicy, err)
+	}
+	return nil
+}
+
+// errorPolicy returns the policy set in the options, falling back to the one
+// stored for the session.
+func (m *Mapper) errorPolicy(sessionToken string) (ErrorPolicy, error) {
+	if m.opts.Errors != nil {
+		return *m.opts.Errors, nil
+	}
+	return m.GetErrorPolicy(sessionToken)
+}
@@ -0,0 +1,152 @@
+package mapz
+
+import (
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+	"github.com/jack5341/otel-map-server/internal/metrics"
+	"github.com/jack5341/otel-map-server/internal/models"
+)
+
+const (
+	// DataSourceAuto reads span metrics when the session has any, and raw
+	// spans otherwise.
+	DataSourceAuto = "auto"
+	// DataSourceSpans scans the raw spans on every request.
+	DataSourceSpans = "spans"
+	// DataSourceSpanMetrics reads the request, error and duration metrics
+	// the collector's spanmetrics and servicegraph connectors aggregate.
+	DataSourceSpanMetrics = "span_metrics"
+)
+
+var DataSources = []string{DataSourceAuto, DataSourceSpans, DataSourceSpanMetrics}
+
+const getDataSourceQuery = `
+SELECT argMax(source, updated_at) AS source
+FROM data_sources
+WHERE token = ?
+GROUP BY token
+`
+
+const hasSpanMetricsQuery = `
+SELECT count() AS points
+FROM (
+    SELECT 1
+    FROM default.otel_metrics_sum AS t
+    WHERE ` + spanMetricsTokenExpr + `
+        AND t.MetricName IN ?
+    LIMIT 1
+)
+`
+
+// ValidDataSource reports whether s names a data source.
+func ValidDataSource(s string) bool {
+	return slices.Contains(DataSources, s)
+}
+
+// GetDataSource returns the data source configured for a session, auto when
+// none was.
+func (m *Mapper) GetDataSource(sessionToken string) (string, error) {
+	ctx, span := m.otelTracer.Start(m.ctx, "Mapper.GetDataSource")
+	defer span.End()
+
+	token, err := uuid.Parse(sessionToken)
+	if err != nil {
+		return "", errorz.ErrInvalidSessionToken
+	}
+
+	var sources []string
+	start := time.Now()
+	err = m.db.WithContext(ctx).Raw(getDataSourceQuery, token).Scan(&sources).Error
+	metrics.ObserveQuery("get_data_source", start, err)
+	if err != nil {
+		return "", errors.Join(errorz.ErrWhileGettingDataSource, err)
+	}
+	if len(sources) == 0 || !ValidDataSource(sources[0]) {
+		return DataSourceAuto, nil
+	}
+	return sources[0], nil
+}
+
+func (m *Mapper) SaveDataSource(sessionToken, source string) error {
+	ctx, span := m.otelTracer.Start(m.ctx, "Mapper.SaveDataSource")
+	defer span.End()
+
+	token, err := uuid.Parse(sessionToken)
+	if err != nil {
+		return errorz.ErrInvalidSessionToken
+	}
+	if !ValidDataSource(source) {
+		return errorz.ErrInvalidDataSource
+	}
+
+	row := models.DataSource{Token: token, Source: source, UpdatedAt: time.Now().UTC()}
+	if err := m.db.WithContext(ctx).Create(&row).Error; err != nil {
+		return errors.Join(errorz.ErrWhileSavingDataSource, err)
+	}
+	return nil
+}
+
+// DataSource resolves where the mapper reads node and edge metrics from:
+// spans or span_metrics.
+func (m *Mapper) DataSource(sessionToken string) (string, error) {
+	return cached(m, "data_source", m.cacheKey("data_source", sessionToken), func(m *Mapper) (string, error) {
+		return m.dataSource(sessionToken)
+	}, func(s string) string { return s })
+}
+
+func (m *Mapper) dataSource(sessionToken string) (string, error) {
+	source := m.opts.Source
+	if source == "" {
+		var err error
+		if source, err = m.GetDataSource(sessionToken); err != nil {
+			return "", err
+		}
+	}
+	if source == DataSourceSpans {
+		return DataSourceSpans, nil
+	}
+
+	spans, err := m.needsSpans(sessionToken)
+	if err != nil {
+		return "", err
+	}
+	if spans {
+		return DataSourceSpans, nil
+	}
+	if source == DataSourceSpanMetrics {
+		return DataSourceSpanMetrics, nil
+	}
+
+	ctx, span := m.otelTracer.Start(m.ctx, "Mapper.DataSource")
+	defer span.End()
+
+	var points uint64
+	start := time.Now()
+	err = m.db.WithContext(ctx).Raw(hasSpanMetricsQuery, sessionToken, spanMetricsCallNames).Scan(&points).Error
+	metrics.ObserveQuery("has_span_metrics", start, err)
+	if err != nil {
+		return "", errors.Join(errorz.ErrWhileGettingSpanMetrics, err)
+	}
+	if points > 0 {
+		return DataSourceSpanMetrics, nil
+	}
+	return DataSourceSpans, nil
+}
+
+// needsSpans reports whether the request needs the raw spans. Pre-aggregated
+// metrics carry neither resource attributes beyond the service name nor the
+// span attributes filters and error policies look at, so requests
+// splitting, grouping, filtering, bucketing latency into a histogram or
+// counting errors beyond the span status read spans.
+func (m *Mapper) needsSpans(sessionToken string) (bool, error) {
+	policy, err := m.errorPolicy(sessionToken)
+	if err != nil {
+		return false, err
+	}
+	o := m.opts
+	return len(o.SplitBy) > 0 || len(o.GroupBy) > 0 || o.Filter != nil || o.Histogram || policy != (ErrorPolicy{}), nil
+}
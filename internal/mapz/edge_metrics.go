@@ -0,0 +1,94 @@
+package mapz
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+	"github.com/jack5341/otel-map-server/internal/metrics"
+)
+
+// HistogramBuckets are the upper bounds, in seconds, used for edge latency
+// histograms. They match the defaults of Tempo's service-graph connector.
+var HistogramBuckets = []float64{0.1, 0.2, 0.4, 0.8, 1.6, 3.2, 6.4, 12.8}
+
+const getEdgeMetricsQuery = `
+WITH SpansBase AS (
+    SELECT
+        t.TraceId,
+        t.SpanId,
+        t.ParentSpanId,
+        t.ServiceName,
+        %s AS IsError,
+        t.Duration
+    FROM default.otel_traces AS t
+    WHERE t.ResourceAttributes['otelmap.session_token'] = ?
+)
+
+SELECT
+    p.ServiceName AS client,
+    c.ServiceName AS server,
+    COUNT() AS total_requests,
+    countIf(p.IsError OR c.IsError) AS failed_requests,
+    SUM(p.Duration) / 1e9 AS client_seconds_sum,
+    SUM(c.Duration) / 1e9 AS server_seconds_sum,
+    %s AS client_seconds_buckets,
+    %s AS server_seconds_buckets
+FROM SpansBase AS c
+INNER JOIN SpansBase AS p
+    ON c.ParentSpanId = p.SpanId
+    AND c.TraceId = p.TraceId
+WHERE c.ParentSpanId != '' AND p.ServiceName != c.ServiceName
+GROUP BY client, server
+ORDER BY client, server
+`
+
+// EdgeMetrics describes the traffic between two services in the shape of
+// Tempo's service-graph connector. Bucket counts are cumulative and follow
+// HistogramBuckets.
+type EdgeMetrics struct {
+	Client               string   `json:"client"`
+	Server               string   `json:"server"`
+	TotalRequests        uint64   `json:"total_requests"`
+	FailedRequests       uint64   `json:"failed_requests"`
+	ClientSecondsSum     float64  `json:"client_seconds_sum"`
+	ServerSecondsSum     float64  `json:"server_seconds_sum"`
+	ClientSecondsBuckets []uint64 `json:"client_seconds_buckets" gorm:"type:Array(UInt64)"`
+	ServerSecondsBuckets []uint64 `json:"server_seconds_buckets" gorm:"type:Array(UInt64)"`
+}
+
+func bucketsExpr(column string) string {
+	parts := make([]string, len(HistogramBuckets))
+	for i, b := range HistogramBuckets {
+		parts[i] = fmt.Sprintf("countIf(%s <= %d)", column, int64(b*float64(time.Second)))
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+func (m *Mapper) GetEdgeMetrics(sessionToken string) ([]EdgeMetrics, error) {
+	ctx, span := m.otelTracer.Start(m.ctx, "Mapper.GetEdgeMetrics")
+	defer span.End()
+
+	if sessionToken == "" {
+		return nil, errorz.ErrSessionTokenRequired
+	}
+
+	policy, err := m.errorPolicy(sessionToken)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(getEdgeMetricsQuery, policy.Expr("t"), bucketsExpr("p.Duration"), bucketsExpr("c.Duration"))
+
+	var edges []EdgeMetrics
+	start := time.Now()
+	err = m.db.WithContext(ctx).Raw(query, sessionToken).Scan(&edges).Error
+	metrics.ObserveQuery("get_edge_metrics", start, err)
+	if err != nil {
+		return nil, errors.Join(errorz.ErrWhileGettingEdgeMetrics, err)
+	}
+
+	return edges, nil
+}
@@ -0,0 +1,144 @@
+package mapz
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+	"github.com/jack5341/otel-map-server/internal/metrics"
+)
+
+// getErrorOriginsQuery classifies every failed span: it originated the error
+// when none of its children failed, otherwise it propagated a child's
+// failure. RootFailures counts the traces an originating span made fail at
+// the root.
+const getErrorOriginsQuery = `
+WITH Spans AS (
+    SELECT
+        t.TraceId,
+        t.SpanId,
+        t.ParentSpanId,
+        t.ServiceName,
+        multiIf(
+            has(t.SpanAttributes, 'http.route'),
+            t.SpanAttributes['http.method'] || ' ' || t.SpanAttributes['http.route'],
+            t.SpanName
+        ) AS Operation,
+        %[2]s AS IsError
+    FROM default.otel_traces AS t
+    WHERE t.ResourceAttributes['otelmap.session_token'] = ?%[1]s
+),
+
+FailedChildren AS (
+    SELECT
+        TraceId,
+        ParentSpanId AS SpanId,
+        COUNT() AS failed_children
+    FROM Spans
+    WHERE IsError AND ParentSpanId != ''
+    GROUP BY TraceId, ParentSpanId
+),
+
+FailedRoots AS (
+    SELECT DISTINCT TraceId
+    FROM Spans
+    WHERE IsError AND ParentSpanId = ''
+)
+
+SELECT
+    s.ServiceName AS service_name,
+    s.Operation AS operation,
+    countIf(c.failed_children = 0) AS originated,
+    countIf(c.failed_children > 0) AS propagated,
+    uniqExactIf(s.TraceId, c.failed_children = 0) AS affected_traces,
+    uniqExactIf(s.TraceId, c.failed_children = 0 AND s.TraceId IN (SELECT TraceId FROM FailedRoots)) AS root_failures,
+    groupUniqArrayIf(3)(s.TraceId, c.failed_children = 0) AS example_trace_ids
+FROM Spans AS s
+LEFT JOIN FailedChildren AS c
+    ON s.TraceId = c.TraceId
+    AND s.SpanId = c.SpanId
+WHERE s.IsError
+GROUP BY service_name, operation
+ORDER BY affected_traces DESC, service_name, operation
+`
+
+const getFailedTracesQuery = `
+SELECT uniqExactIf(t.TraceId, %[2]s) AS failed_traces
+FROM default.otel_traces AS t
+WHERE t.ResourceAttributes['otelmap.session_token'] = ?%[1]s
+`
+
+// ErrorOrigin counts the failed spans of one operation of a service by
+// whether they originated or propagated the error. AffectedTraces and
+// RootFailures count distinct traces in which the operation originated an
+// error, the latter only those whose root span failed too.
+type ErrorOrigin struct {
+	ServiceName     string   `json:"service_name"`
+	Operation       string   `json:"operation"`
+	Originated      uint64   `json:"originated"`
+	Propagated      uint64   `json:"propagated"`
+	AffectedTraces  uint64   `json:"affected_traces"`
+	RootFailures    uint64   `json:"root_failures"`
+	ExampleTraceIDs []string `json:"example_trace_ids" gorm:"type:Array(String)"`
+}
+
+// GetErrorOrigins classifies the session's failed spans per service and
+// operation, the operations affecting most traces first.
+func (m *Mapper) GetErrorOrigins(sessionToken string) ([]ErrorOrigin, error) {
+	ctx, span := m.otelTracer.Start(m.ctx, "Mapper.GetErrorOrigins")
+	defer span.End()
+
+	if sessionToken == "" {
+		return nil, errorz.ErrSessionTokenRequired
+	}
+
+	policy, err := m.errorPolicy(sessionToken)
+	if err != nil {
+		return nil, err
+	}
+
+	filter, filterArgs := m.opts.where(sessionToken)
+	query := fmt.Sprintf(getErrorOriginsQuery, filter, policy.Expr("t"))
+	args := append([]any{sessionToken}, filterArgs...)
+
+	var origins []ErrorOrigin
+	start := time.Now()
+	err = m.db.WithContext(ctx).Raw(query, args...).Scan(&origins).Error
+	metrics.ObserveQuery("get_error_origins", start, err)
+	if err != nil {
+		return nil, errors.Join(errorz.ErrWhileGettingErrorOrigins, err)
+	}
+
+	return origins, nil
+}
+
+// GetFailedTraceCount counts the session's traces with at least one failed
+// span.
+func (m *Mapper) GetFailedTraceCount(sessionToken string) (uint64, error) {
+	ctx, span := m.otelTracer.Start(m.ctx, "Mapper.GetFailedTraceCount")
+	defer span.End()
+
+	if sessionToken == "" {
+		return 0, errorz.ErrSessionTokenRequired
+	}
+
+	policy, err := m.errorPolicy(sessionToken)
+	if err != nil {
+		return 0, err
+	}
+
+	filter, filterArgs := m.opts.where(sessionToken)
+	query := fmt.Sprintf(getFailedTracesQuery, filter, policy.Expr("t"))
+	args := append([]any{sessionToken}, filterArgs...)
+
+	var count uint64
+	start := time.Now()
+	err = m.db.WithContext(ctx).Raw(query, args...).Scan(&count).Error
+	metrics.ObserveQuery("get_failed_trace_count", start, err)
+	if err != nil {
+		return 0, errors.Join(errorz.ErrWhileGettingErrorOrigins, err)
+	}
+
+	return count, nil
+}
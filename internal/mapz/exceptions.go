@@ -0,0 +1,250 @@
+package mapz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+	"github.com/jack5341/otel-map-server/internal/metrics"
+)
+
+// normalizedMessageExpr collapses the variable parts of an exception message
+// (ids, hex values, quoted strings, numbers) so that occurrences of the same
+// failure group together. It must not contain a question mark, which would
+// be taken for a query parameter.
+const normalizedMessageExpr = `replaceRegexpAll(replaceRegexpAll(replaceRegexpAll(replaceRegexpAll(exception_message,
+        '[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}', '<uuid>'),
+        '0x[0-9a-fA-F]+|\\b[0-9a-fA-F]{16,}\\b', '<hex>'),
+        '\'[^\']*\'|"[^"]*"', '<str>'),
+        '\\d+', '<n>')`
+
+const exceptionsCTE = `
+WITH Exceptions AS (
+    SELECT
+        t.TraceId AS trace_id,
+        t.ParentSpanId AS parent_span_id,
+        t.ServiceName AS service_name,
+        event.Timestamp AS seen_at,
+        event.Attributes['exception.type'] AS exception_type,
+        event.Attributes['exception.message'] AS exception_message,
+        event.Attributes['exception.stacktrace'] AS exception_stacktrace
+    FROM default.otel_traces AS t
+    ARRAY JOIN t.Events AS event
+    WHERE t.ResourceAttributes['otelmap.session_token'] = ?%[1]s
+        AND event.Name = 'exception'
+)`
+
+const getServiceExceptionsQuery = exceptionsCTE + `
+
+SELECT
+    service_name,
+    exception_type,
+    ` + normalizedMessageExpr + ` AS message,
+    any(exception_message) AS example_message,
+    any(exception_stacktrace) AS example_stacktrace,
+    COUNT() AS occurrences,
+    min(seen_at) AS first_seen,
+    max(seen_at) AS last_seen,
+    groupUniqArray(3)(trace_id) AS example_trace_ids
+FROM Exceptions
+GROUP BY service_name, exception_type, message
+ORDER BY service_name, occurrences DESC
+LIMIT ? BY service_name
+`
+
+const getEdgeExceptionsQuery = exceptionsCTE + `,
+
+Spans AS (
+    SELECT
+        t.TraceId,
+        t.SpanId,
+        t.ServiceName
+    FROM default.otel_traces AS t
+    WHERE t.ResourceAttributes['otelmap.session_token'] = ?
+)
+
+SELECT
+    p.ServiceName AS source_service_name,
+    e.service_name AS service_name,
+    e.exception_type AS exception_type,
+    ` + normalizedMessageExpr + ` AS message,
+    any(e.exception_message) AS example_message,
+    any(e.exception_stacktrace) AS example_stacktrace,
+    COUNT() AS occurrences,
+    min(e.seen_at) AS first_seen,
+    max(e.seen_at) AS last_seen,
+    groupUniqArray(3)(e.trace_id) AS example_trace_ids
+FROM Exceptions AS e
+INNER JOIN Spans AS p
+    ON e.parent_span_id = p.SpanId
+    AND e.trace_id = p.TraceId
+WHERE e.parent_span_id != '' AND p.ServiceName != e.service_name
+GROUP BY source_service_name, service_name, exception_type, message
+ORDER BY source_service_name, service_name, occurrences DESC
+LIMIT ? BY source_service_name, service_name
+`
+
+const statusCodesCTE = `
+WITH Spans AS (
+    SELECT
+        t.TraceId,
+        t.SpanId,
+        t.ParentSpanId,
+        t.ServiceName,
+        arrayFilter(x -> x.2 != '', [
+            ('http', if(has(t.SpanAttributes, 'http.response.status_code'),
+                t.SpanAttributes['http.response.status_code'], t.SpanAttributes['http.status_code'])),
+            ('grpc', t.SpanAttributes['rpc.grpc.status_code'])
+        ]) AS Codes
+    FROM default.otel_traces AS t
+    WHERE t.ResourceAttributes['otelmap.session_token'] = ?%[1]s
+)`
+
+const getServiceStatusCodesQuery = statusCodesCTE + `
+
+SELECT
+    ServiceName AS service_name,
+    arrayJoin(Codes) AS code,
+    code.1 AS kind,
+    code.2 AS status_code,
+    COUNT() AS occurrences
+FROM Spans
+GROUP BY service_name, code
+ORDER BY service_name, kind, status_code
+`
+
+const getEdgeStatusCodesQuery = statusCodesCTE + `
+
+SELECT
+    p.ServiceName AS source_service_name,
+    c.ServiceName AS service_name,
+    arrayJoin(c.Codes) AS code,
+    code.1 AS kind,
+    code.2 AS status_code,
+    COUNT() AS occurrences
+FROM Spans AS c
+INNER JOIN Spans AS p
+    ON c.ParentSpanId = p.SpanId
+    AND c.TraceId = p.TraceId
+WHERE c.ParentSpanId != '' AND p.ServiceName != c.ServiceName
+GROUP BY source_service_name, service_name, code
+ORDER BY source_service_name, service_name, kind, status_code
+`
+
+const (
+	StatusKindHTTP = "http"
+	StatusKindGRPC = "grpc"
+)
+
+// ExceptionGroup is a set of exception events with the same type and
+// normalised message, recorded by ServiceName. For edges, SourceServiceName
+// is the caller of the failing span.
+type ExceptionGroup struct {
+	SourceServiceName string    `json:"source_service_name,omitempty"`
+	ServiceName       string    `json:"service_name"`
+	ExceptionType     string    `json:"exception_type"`
+	Message           string    `json:"message"`
+	ExampleMessage    string    `json:"example_message"`
+	ExampleStacktrace string    `json:"example_stacktrace"`
+	Occurrences       uint64    `json:"occurrences"`
+	FirstSeen         time.Time `json:"first_seen"`
+	LastSeen          time.Time `json:"last_seen"`
+	ExampleTraceIDs   []string  `json:"example_trace_ids" gorm:"type:Array(String)"`
+}
+
+// StatusCodeCount counts spans of ServiceName by HTTP status or gRPC code.
+// For edges, SourceServiceName is the caller.
+type StatusCodeCount struct {
+	SourceServiceName string `json:"source_service_name,omitempty"`
+	ServiceName       string `json:"service_name"`
+	Kind              string `json:"kind"`
+	StatusCode        string `json:"status_code"`
+	Occurrences       uint64 `json:"occurrences"`
+}
+
+// GetServiceExceptions returns up to limit exception groups per service, most
+// frequent first.
+func (m *Mapper) GetServiceExceptions(sessionToken string, limit int) ([]ExceptionGroup, error) {
+	ctx, span := m.otelTracer.Start(m.ctx, "Mapper.GetServiceExceptions")
+	defer span.End()
+
+	if sessionToken == "" {
+		return nil, errorz.ErrSessionTokenRequired
+	}
+
+	filter, filterArgs := m.opts.where(sessionToken)
+	query := fmt.Sprintf(getServiceExceptionsQuery, filter)
+	args := append([]any{sessionToken}, filterArgs...)
+	args = append(args, limit)
+
+	var groups []ExceptionGroup
+	start := time.Now()
+	err := m.db.WithContext(ctx).Raw(query, args...).Scan(&groups).Error
+	metrics.ObserveQuery("get_service_exceptions", start, err)
+	if err != nil {
+		return nil, errors.Join(errorz.ErrWhileGettingExceptions, err)
+	}
+
+	return groups, nil
+}
+
+// GetEdgeExceptions returns up to limit exception groups per edge, recorded
+// on spans called from another service.
+func (m *Mapper) GetEdgeExceptions(sessionToken string, limit int) ([]ExceptionGroup, error) {
+	ctx, span := m.otelTracer.Start(m.ctx, "Mapper.GetEdgeExceptions")
+	defer span.End()
+
+	if sessionToken == "" {
+		return nil, errorz.ErrSessionTokenRequired
+	}
+
+	filter, filterArgs := m.opts.where(sessionToken)
+	query := fmt.Sprintf(getEdgeExceptionsQuery, filter)
+	args := append([]any{sessionToken}, filterArgs...)
+	args = append(args, sessionToken, limit)
+
+	var groups []ExceptionGroup
+	start := time.Now()
+	err := m.db.WithContext(ctx).Raw(query, args...).Scan(&groups).Error
+	metrics.ObserveQuery("get_edge_exceptions", start, err)
+	if err != nil {
+		return nil, errors.Join(errorz.ErrWhileGettingExceptions, err)
+	}
+
+	return groups, nil
+}
+
+func (m *Mapper) GetServiceStatusCodes(sessionToken string) ([]StatusCodeCount, error) {
+	ctx, span := m.otelTracer.Start(m.ctx, "Mapper.GetServiceStatusCodes")
+	defer span.End()
+
+	return m.statusCodes(ctx, "get_service_status_codes", getServiceStatusCodesQuery, sessionToken)
+}
+
+func (m *Mapper) GetEdgeStatusCodes(sessionToken string) ([]StatusCodeCount, error) {
+	ctx, span := m.otelTracer.Start(m.ctx, "Mapper.GetEdgeStatusCodes")
+	defer span.End()
+
+	return m.statusCodes(ctx, "get_edge_status_codes", getEdgeStatusCodesQuery, sessionToken)
+}
+
+func (m *Mapper) statusCodes(ctx context.Context, name, query, sessionToken string) ([]StatusCodeCount, error) {
+	if sessionToken == "" {
+		return nil, errorz.ErrSessionTokenRequired
+	}
+
+	filter, filterArgs := m.opts.where(sessionToken)
+	args := append([]any{sessionToken}, filterArgs...)
+
+	var counts []StatusCodeCount
+	start := time.Now()
+	err := m.db.WithContext(ctx).Raw(fmt.Sprintf(query, filter), args...).Scan(&counts).Error
+	metrics.ObserveQuery(name, start, err)
+	if err != nil {
+		return nil, errors.Join(errorz.ErrWhileGettingExceptions, err)
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,266 @@
+package mapz
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+)
+
+const (
+	maxFilterConditions = 10
+	maxFilterValues     = 100
+)
+
+// Filter restricts the service map to traces containing a span that matches
+// every positive condition and no span matching a negated one (!= or
+// NOT IN). It is parsed from expressions such as
+//
+//	attr.http.route=/checkout AND resource.deployment.environment!=dev
+//	resource.tenant.id IN (acme, "big corp"), attr.http.method NOT IN (OPTIONS)
+//
+// Conditions are separated by AND or a comma. attr. addresses span
+// attributes, resource. resource attributes. Values containing spaces,
+// commas or parentheses must be quoted.
+type Filter struct {
+	conditions []condition
+}
+
+type condition struct {
+	column string
+	key    string
+	negate bool
+	values []string
+}
+
+// ParseFilter parses a filter expression; an empty expression yields nil.
+func ParseFilter(expr string) (*Filter, error) {
+	p := filterParser{in: []rune(expr)}
+	p.skipSpace()
+	if p.done() {
+		return nil, nil
+	}
+
+	f := &Filter{}
+	for {
+		c, err := p.condition()
+		if err != nil {
+			return nil, err
+		}
+		f.conditions = append(f.conditions, c)
+		if len(f.conditions) > maxFilterConditions {
+			return nil, errorz.ErrInvalidFilter
+		}
+
+		p.skipSpace()
+		if p.done() {
+			return f, nil
+		}
+		if !p.consume(",") && !p.keyword("AND") {
+			return nil, errorz.ErrInvalidFilter
+		}
+	}
+}
+
+// key encodes the filter canonically, quoting every value, for cache keys.
+func (f *Filter) key() string {
+	if f == nil {
+		return ""
+	}
+	var b strings.Builder
+	for _, c := range f.conditions {
+		fmt.Fprintf(&b, "%q %q %t", c.column, c.key, c.negate)
+		for _, v := range c.values {
+			fmt.Fprintf(&b, " %q", v)
+		}
+		b.WriteString(";")
+	}
+	return b.String()
+}
+
+// predicate compiles the filter into a parameterised condition on the
+// TraceId of the span aliased t. Negated conditions exclude every trace with
+// a span matching them.
+func (f *Filter) predicate(sessionToken string) (string, []any) {
+	if f == nil || len(f.conditions) == 0 {
+		return "", nil
+	}
+
+	var parts []string
+	var args []any
+	var excluded string
+	var excludedArgs []any
+	for _, c := range f.conditions {
+		op, value := "IN", any(c.values)
+		if len(c.values) == 1 {
+			op, value = "=", c.values[0]
+		}
+		if c.negate {
+			excluded += `
+    AND t.TraceId NOT IN (
+        SELECT TraceId
+        FROM default.otel_traces
+        WHERE ResourceAttributes['otelmap.session_token'] = ?
+            AND ` + c.column + "[?] " + op + ` ?
+    )`
+			excludedArgs = append(excludedArgs, sessionToken, c.key, value)
+			continue
+		}
+		parts = append(parts, c.column+"[?] "+op+" ?")
+		args = append(args, c.key, value)
+	}
+
+	if len(parts) == 0 {
+		return excluded, excludedArgs
+	}
+	return `
+    AND t.TraceId IN (
+        SELECT TraceId
+        FROM default.otel_traces
+        WHERE ResourceAttributes['otelmap.session_token'] = ?
+            AND ` + strings.Join(parts, "\n            AND ") + `
+    )` + excluded, append(append([]any{sessionToken}, args...), excludedArgs...)
+}
+
+type filterParser struct {
+	in  []rune
+	pos int
+}
+
+func (p *filterParser) done() bool {
+	return p.pos >= len(p.in)
+}
+
+func (p *filterParser) skipSpace() {
+	for !p.done() && unicode.IsSpace(p.in[p.pos]) {
+		p.pos++
+	}
+}
+
+func (p *filterParser) consume(s string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(string(p.in[p.pos:]), s) {
+		p.pos += len([]rune(s))
+		return true
+	}
+	return false
+}
+
+// keyword consumes a case-insensitive word followed by a space or an opening
+// parenthesis.
+func (p *filterParser) keyword(word string) bool {
+	p.skipSpace()
+	end := p.pos + len(word)
+	if end > len(p.in) || !strings.EqualFold(string(p.in[p.pos:end]), word) {
+		return false
+	}
+	if end < len(p.in) && !unicode.IsSpace(p.in[end]) && p.in[end] != '(' {
+		return false
+	}
+	p.pos = end
+	return true
+}
+
+func (p *filterParser) condition() (condition, error) {
+	var c condition
+	p.skipSpace()
+	start := p.pos
+	for !p.done() && !unicode.IsSpace(p.in[p.pos]) && !strings.ContainsRune("=!(", p.in[p.pos]) {
+		p.pos++
+	}
+	key := string(p.in[start:p.pos])
+	switch {
+	case strings.HasPrefix(key, "attr."):
+		c.column, c.key = "SpanAttributes", strings.TrimPrefix(key, "attr.")
+	case strings.HasPrefix(key, "resource."):
+		c.column, c.key = "ResourceAttributes", strings.TrimPrefix(key, "resource.")
+	default:
+		return c, errorz.ErrInvalidFilter
+	}
+	if !attributeKeyPattern.MatchString(c.key) {
+		return c, errorz.ErrInvalidFilter
+	}
+
+	switch {
+	case p.consume("!="):
+		c.negate = true
+	case p.consume("="):
+	case p.keyword("IN"):
+		return p.list(c)
+	case p.keyword("NOT"):
+		if !p.keyword("IN") {
+			return c, errorz.ErrInvalidFilter
+		}
+		c.negate = true
+		return p.list(c)
+	default:
+		return c, errorz.ErrInvalidFilter
+	}
+
+	v, err := p.value()
+	if err != nil {
+		return c, err
+	}
+	c.values = []string{v}
+	return c, nil
+}
+
+func (p *filterParser) list(c condition) (condition, error) {
+	if !p.consume("(") {
+		return c, errorz.ErrInvalidFilter
+	}
+	for {
+		v, err := p.value()
+		if err != nil {
+			return c, err
+		}
+		c.values = append(c.values, v)
+		if len(c.values) > maxFilterValues {
+			return c, errorz.ErrInvalidFilter
+		}
+		if p.consume(")") {
+			return c, nil
+		}
+		if !p.consume(",") {
+			return c, errorz.ErrInvalidFilter
+		}
+	}
+}
+
+// value reads a quoted string, where a backslash escapes the next character,
+// or a bare word ending at whitespace, a comma or a parenthesis.
+func (p *filterParser) value() (string, error) {
+	p.skipSpace()
+	if p.done() {
+		return "", errorz.ErrInvalidFilter
+	}
+
+	if q := p.in[p.pos]; q == '"' || q == '\'' {
+		p.pos++
+		var b strings.Builder
+		for !p.done() {
+			r := p.in[p.pos]
+			p.pos++
+			switch {
+			case r == '\\' && !p.done():
+				b.WriteRune(p.in[p.pos])
+				p.pos++
+			case r == q:
+				return b.String(), nil
+			default:
+				b.WriteRune(r)
+			}
+		}
+		return "", errorz.ErrInvalidFilter
+	}
+
+	start := p.pos
+	for !p.done() && !unicode.IsSpace(p.in[p.pos]) && !strings.ContainsRune(",()", p.in[p.pos]) {
+		p.pos++
+	}
+	if p.pos == start {
+		return "", errorz.ErrInvalidFilter
+	}
+	return string(p.in[start:p.pos]), nil
+}
@@ -0,0 +1,111 @@
+package mapz
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+)
+
+// MaxQuantiles bounds how many extra quantiles a caller can request.
+const MaxQuantiles = 10
+
+// LatencyBucketsMs are the upper bounds of the latency histogram buckets:
+// powers of two from 1ms to about 65s. A final bucket counts everything
+// slower.
+var LatencyBucketsMs = func() []float64 {
+	bounds := make([]float64, 17)
+	for i := range bounds {
+		bounds[i] = math.Exp2(float64(i))
+	}
+	return bounds
+}()
+
+// LatencyHistogram counts spans per latency bucket. Counts[i] covers
+// durations above BoundsMs[i-1] up to BoundsMs[i]; the extra last count
+// covers durations above the last bound.
+type LatencyHistogram struct {
+	BoundsMs []float64 `json:"bounds_ms"`
+	Counts   []uint64  `json:"counts"`
+}
+
+// ParseQuantiles parses a comma separated list of quantiles such as
+// "0.5,0.99,0.999". Each must lie strictly between 0 and 1.
+func ParseQuantiles(s string) ([]float64, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var quantiles []float64
+	for _, part := range strings.Split(s, ",") {
+		q, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || !(q > 0 && q < 1) {
+			return nil, errorz.ErrInvalidQuantile
+		}
+		quantiles = append(quantiles, q)
+	}
+	if len(quantiles) > MaxQuantiles {
+		return nil, errorz.ErrInvalidQuantile
+	}
+	return quantiles, nil
+}
+
+// QuantileKey names a quantile the way it is reported, e.g. 0.999 is "p99.9".
+func QuantileKey(q float64) string {
+	return "p" + strconv.FormatFloat(math.Round(q*1e6)/1e4, 'f', -1, 64)
+}
+
+// quantilesExpr computes the requested quantiles of column in milliseconds.
+// Quantile levels must be literals in ClickHouse; they are validated floats,
+// so formatting them into the query is safe.
+func quantilesExpr(column string, quantiles []float64) string {
+	if len(quantiles) == 0 {
+		return "CAST([], 'Array(Float64)')"
+	}
+	levels := make([]string, len(quantiles))
+	for i, q := range quantiles {
+		levels[i] = strconv.FormatFloat(q, 'f', -1, 64)
+	}
+	return fmt.Sprintf("arrayMap(x -> round(x / 1000000, 2), quantilesTDigest(%s)(%s))", strings.Join(levels, ", "), column)
+}
+
+// histogramExpr counts column, in nanoseconds, per LatencyBucketsMs bucket.
+func histogramExpr(column string, enabled bool) string {
+	if !enabled {
+		return "CAST([], 'Array(UInt64)')"
+	}
+	parts := make([]string, 0, len(LatencyBucketsMs)+1)
+	var lower int64
+	for i, b := range LatencyBucketsMs {
+		upper := int64(b * 1e6)
+		if i == 0 {
+			parts = append(parts, fmt.Sprintf("countIf(%s <= %d)", column, upper))
+		} else {
+			parts = append(parts, fmt.Sprintf("countIf(%s > %d AND %s <= %d)", column, lower, column, upper))
+		}
+		lower = upper
+	}
+	parts = append(parts, fmt.Sprintf("countIf(%s > %d)", column, lower))
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+func zipQuantiles(quantiles, values []float64) map[string]float64 {
+	if len(quantiles) == 0 {
+		return nil
+	}
+	result := make(map[string]float64, len(quantiles))
+	for i, q := range quantiles {
+		if i < len(values) {
+			result[QuantileKey(q)] = values[i]
+		}
+	}
+	return result
+}
+
+func histogram(counts []uint64) *LatencyHistogram {
+	if len(counts) == 0 {
+		return nil
+	}
+	return &LatencyHistogram{BoundsMs: LatencyBucketsMs, Counts: counts}
+}
@@ -0,0 +1,161 @@
+package mapz
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+	"github.com/jack5341/otel-map-server/internal/metrics"
+)
+
+// MaxLogLimit bounds how many log records one request returns.
+const MaxLogLimit = 1000
+
+// errorLogExpr matches log records of severity ERROR or above. Exporters that
+// only set the severity text are matched on it.
+const errorLogExpr = `(toInt32(t.SeverityNumber) >= 17 OR upper(t.SeverityText) IN ('ERROR', 'FATAL', 'CRITICAL', 'EMERGENCY'))`
+
+const getLogsQuery = `
+SELECT
+    t.Timestamp AS timestamp,
+    t.TraceId AS trace_id,
+    t.SpanId AS span_id,
+    t.ServiceName AS service_name,
+    t.SeverityText AS severity_text,
+    toInt32(t.SeverityNumber) AS severity_number,
+    t.Body AS body,
+    CAST(t.LogAttributes, 'Map(String, String)') AS attributes
+FROM default.otel_logs AS t
+WHERE t.ResourceAttributes['otelmap.session_token'] = ?%[1]s
+ORDER BY t.Timestamp %[2]s
+LIMIT ?
+`
+
+const getErrorLogCountsQuery = `
+SELECT
+    t.ServiceName AS service_name,
+    %[1]s AS split_values,
+    COUNT() AS error_logs
+FROM default.otel_logs AS t
+WHERE t.ResourceAttributes['otelmap.session_token'] = ?%[2]s
+    AND ` + errorLogExpr + `
+GROUP BY service_name, split_values
+`
+
+// LogRecord is a log record from the collector's logs pipeline. TraceID and
+// SpanID are empty for logs written outside a span.
+type LogRecord struct {
+	Timestamp      time.Time         `json:"timestamp"`
+	TraceID        string            `json:"trace_id,omitempty"`
+	SpanID         string            `json:"span_id,omitempty"`
+	ServiceName    string            `json:"service_name"`
+	SeverityText   string            `json:"severity_text"`
+	SeverityNumber int32             `json:"severity_number"`
+	Body           string            `json:"body"`
+	Attributes     map[string]string `json:"attributes" gorm:"type:Map(String, String)"`
+}
+
+// LogQuery selects the log records of a service in a time window. Zero
+// bounds are open; ErrorsOnly keeps severity ERROR and above.
+type LogQuery struct {
+	ServiceName string
+	From        time.Time
+	To          time.Time
+	ErrorsOnly  bool
+	Limit       int
+}
+
+type errorLogCount struct {
+	ServiceName string
+	SplitValues []string `gorm:"type:Array(String)"`
+	ErrorLogs   uint64
+}
+
+// GetTraceLogs returns the log records written in a trace, oldest first.
+func (m *Mapper) GetTraceLogs(sessionToken, traceID string, limit int) ([]LogRecord, error) {
+	ctx, span := m.otelTracer.Start(m.ctx, "Mapper.GetTraceLogs")
+	defer span.End()
+
+	if sessionToken == "" {
+		return nil, errorz.ErrSessionTokenRequired
+	}
+
+	query := fmt.Sprintf(getLogsQuery, "\n    AND t.TraceId = ?", "ASC")
+	args := []any{sessionToken, traceID, min(limit, MaxLogLimit)}
+
+	var logs []LogRecord
+	start := time.Now()
+	err := m.db.WithContext(ctx).Raw(query, args...).Scan(&logs).Error
+	metrics.ObserveQuery("get_trace_logs", start, err)
+	if err != nil {
+		return nil, errors.Join(errorz.ErrWhileGettingLogs, err)
+	}
+
+	return logs, nil
+}
+
+// GetServiceLogs returns the log records of a service, newest first.
+func (m *Mapper) GetServiceLogs(sessionToken string, q LogQuery) ([]LogRecord, error) {
+	ctx, span := m.otelTracer.Start(m.ctx, "Mapper.GetServiceLogs")
+	defer span.End()
+
+	if sessionToken == "" {
+		return nil, errorz.ErrSessionTokenRequired
+	}
+
+	conditions := "\n    AND t.ServiceName = ?"
+	args := []any{sessionToken, q.ServiceName}
+	if !q.From.IsZero() {
+		conditions += "\n    AND t.Timestamp >= ?"
+		args = append(args, q.From)
+	}
+	if !q.To.IsZero() {
+		conditions += "\n    AND t.Timestamp <= ?"
+		args = append(args, q.To)
+	}
+	if q.ErrorsOnly {
+		conditions += "\n    AND " + errorLogExpr
+	}
+	args = append(args, min(q.Limit, MaxLogLimit))
+
+	var logs []LogRecord
+	start := time.Now()
+	err := m.db.WithContext(ctx).Raw(fmt.Sprintf(getLogsQuery, conditions, "DESC"), args...).Scan(&logs).Error
+	metrics.ObserveQuery("get_service_logs", start, err)
+	if err != nil {
+		return nil, errors.Join(errorz.ErrWhileGettingLogs, err)
+	}
+
+	return logs, nil
+}
+
+// GetErrorLogCounts counts the error logs of each node, keyed by node ID.
+// Nodes are split like the map; a filter keeps the logs of matching traces.
+func (m *Mapper) GetErrorLogCounts(sessionToken string) (map[string]uint64, error) {
+	ctx, span := m.otelTracer.Start(m.ctx, "Mapper.GetErrorLogCounts")
+	defer span.End()
+
+	if sessionToken == "" {
+		return nil, errorz.ErrSessionTokenRequired
+	}
+
+	filter, filterArgs := m.opts.where(sessionToken)
+	query := fmt.Sprintf(getErrorLogCountsQuery, attributesExpr(len(m.opts.SplitBy), false), filter)
+	args := append(stringArgs(m.opts.SplitBy), sessionToken)
+	args = append(args, filterArgs...)
+
+	var rows []errorLogCount
+	start := time.Now()
+	err := m.db.WithContext(ctx).Raw(query, args...).Scan(&rows).Error
+	metrics.ObserveQuery("get_error_log_counts", start, err)
+	if err != nil {
+		return nil, errors.Join(errorz.ErrWhileGettingLogs, err)
+	}
+
+	counts := make(map[string]uint64, len(rows))
+	for _, row := range rows {
+		counts[NodeID(row.ServiceName, m.opts.SplitBy, row.SplitValues)] = row.ErrorLogs
+	}
+	return counts, nil
+}
@@ -3,8 +3,12 @@ package mapz
 import (
 	"context"
 	"errors"
+	"fmt"
+	"slices"
+	"time"
 
 	errorz "github.com/jack5341/otel-map-server/internal/errors"
+	"github.com/jack5341/otel-map-server/internal/metrics"
 	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
 )
@@ -13,6 +17,8 @@ type Mapper struct {
 	db         *gorm.DB
 	otelTracer trace.Tracer
 	ctx        context.Context
+	opts       Options
+	cache      *Cache
 }
 
 const getEdgesQuery = `
@@ -20,13 +26,6 @@ WITH parameters AS (
     SELECT
         ? AS session_token
 ),
-Duration AS (
-    SELECT 
-        (toUnixTimestamp(MAX(Timestamp)) - toUnixTimestamp(MIN(Timestamp))) AS window_seconds
-    FROM default.otel_traces, parameters
-    WHERE ResourceAttributes['otelmap.session_token'] = parameters.session_token
-    HAVING window_seconds >= 1
-),
 
 SpansBase AS (
     SELECT
@@ -34,6 +33,10 @@ SpansBase AS (
         t.SpanId,
         t.ParentSpanId,
         t.ServiceName AS ServiceName, 
+        %[1]s AS SplitValues,
+        %[3]s AS IsError,
+        t.Duration AS Duration,
+        t.Timestamp AS Timestamp,
         
         multiIf(
             has(t.SpanAttributes, 'http.route'),
@@ -41,7 +44,14 @@ SpansBase AS (
             t.SpanName
         ) AS Path
     FROM default.otel_traces AS t, parameters
-    WHERE t.ResourceAttributes['otelmap.session_token'] = parameters.session_token
+    WHERE t.ResourceAttributes['otelmap.session_token'] = parameters.session_token%[2]s
+),
+
+Duration AS (
+    SELECT 
+        (toUnixTimestamp(MAX(Timestamp)) - toUnixTimestamp(MIN(Timestamp))) AS window_seconds
+    FROM SpansBase
+    HAVING window_seconds >= 1
 ),
 
 ServiceNode AS (
@@ -50,63 +60,148 @@ ServiceNode AS (
         SpanId,
         ParentSpanId,
         ServiceName,
+        SplitValues,
+        IsError,
+        Duration,
         Path
     FROM SpansBase
-    GROUP BY TraceId, SpanId, ParentSpanId, ServiceName, Path
+    GROUP BY TraceId, SpanId, ParentSpanId, ServiceName, SplitValues, IsError, Duration, Path
 )
 
 SELECT
     p.ServiceName AS source_service_name, 
+    p.SplitValues AS source_split_values,
     c.ServiceName AS target_service_name,
+    c.SplitValues AS target_split_values,
     c.Path AS target_service_path,
     COUNT() AS total_requests,
+    countIf(c.IsError) AS error_count,
+    ROUND(error_count / total_requests, 4) AS error_rate,
+    ROUND(max(c.Duration) / 1000000, 2) AS latency_max_ms,
+    %[4]s AS quantile_values,
+    %[5]s AS histogram_counts,
     ROUND(COUNT() / (SELECT window_seconds FROM Duration), 2) AS requests_per_second
 FROM ServiceNode AS c
 INNER JOIN ServiceNode AS p
     ON c.ParentSpanId = p.SpanId 
     AND c.TraceId = p.TraceId
 WHERE c.ParentSpanId != '' AND c.ParentSpanId IS NOT NULL 
-GROUP BY source_service_name, target_service_name, target_service_path
+GROUP BY source_service_name, source_split_values, target_service_name, target_split_values, target_service_path
 ORDER BY source_service_name, target_service_name, target_service_path
 `
 
 const getServicesWithMetricsQuery = `
 SELECT
     t.ServiceName AS service_name,
+    %[1]s AS split_values,
+    %[2]s AS group_values,
     COUNT() AS total_requests,
-    SUM(multiIf(t.StatusCode = '2', 1, 0)) AS error_count,
+    countIf(%[4]s) AS error_count,
     ROUND(error_count / total_requests, 4) AS error_rate,
     ROUND(quantileTDigest(0.50)(t.Duration) / 1000000, 2) AS latency_p50_ms,
     ROUND(quantileTDigest(0.90)(t.Duration) / 1000000, 2) AS latency_p90_ms,
-    ROUND(quantileTDigest(0.95)(t.Duration) / 1000000, 2) AS latency_p95_ms
+    ROUND(quantileTDigest(0.95)(t.Duration) / 1000000, 2) AS latency_p95_ms,
+    ROUND(max(t.Duration) / 1000000, 2) AS latency_max_ms,
+    %[5]s AS quantile_values,
+    %[6]s AS histogram_counts
 FROM otel_traces AS t
-WHERE t.ResourceAttributes['otelmap.session_token'] = ?
-GROUP BY service_name
+WHERE t.ResourceAttributes['otelmap.session_token'] = ?%[3]s
+GROUP BY service_name, split_values
 ORDER BY total_requests DESC
 `
 
 type Edge struct {
-	SourceServiceName string  `json:"source_service_name"`
-	TargetServiceName string  `json:"target_service_name"`
-	TargetServicePath string  `json:"target_service_path"`
-	TotalRequests     uint64  `json:"total_requests"`
-	RequestsPerSecond float64 `json:"requests_per_second"`
+	SourceID          string            `json:"source_id"`
+	SourceServiceName string            `json:"source_service_name"`
+	SourceGroups      map[string]string `json:"source_groups,omitempty" gorm:"-"`
+	TargetID          string            `json:"target_id"`
+	TargetServiceName string            `json:"target_service_name"`
+	TargetGroups      map[string]string `json:"target_groups,omitempty" gorm:"-"`
+	TargetServicePath string            `json:"target_service_path"`
+	TotalRequests     uint64            `json:"total_requests"`
+	ErrorCount        uint64            `json:"error_count"`
+	ErrorRate         float64           `json:"error_rate"`
+	RequestsPerSecond float64           `json:"requests_per_second"`
+	LatencyMaxMs      float64           `json:"latency_max_ms"`
+	// LatencyQuantiles holds the requested quantiles of the called spans'
+	// latency, keyed by QuantileKey.
+	LatencyQuantiles  map[string]float64 `json:"latency_quantiles_ms,omitempty" gorm:"-"`
+	LatencyHistogram  *LatencyHistogram  `json:"latency_histogram,omitempty" gorm:"-"`
+	AnomalyScores     AnomalyScores      `json:"anomaly_scores,omitempty" gorm:"-"`
+	SourceSplitValues []string           `json:"-" gorm:"type:Array(String)"`
+	TargetSplitValues []string           `json:"-" gorm:"type:Array(String)"`
+	QuantileValues    []float64          `json:"-" gorm:"type:Array(Float64)"`
+	HistogramCounts   []uint64           `json:"-" gorm:"type:Array(UInt64)"`
 }
 type Service struct {
-	ServiceName   string  `json:"service_name"`
-	TotalRequests int64   `json:"total_requests"`
-	ErrorCount    int64   `json:"error_count"`
-	ErrorRate     float64 `json:"error_rate"`
-	LatencyP50Ms  float64 `json:"latency_p50_ms"`
-	LatencyP90Ms  float64 `json:"latency_p90_ms"`
-	LatencyP95Ms  float64 `json:"latency_p95_ms"`
+	ID            string            `json:"id"`
+	ServiceName   string            `json:"service_name"`
+	Groups        map[string]string `json:"groups,omitempty" gorm:"-"`
+	TotalRequests int64             `json:"total_requests"`
+	ErrorCount    int64             `json:"error_count"`
+	ErrorRate     float64           `json:"error_rate"`
+	LatencyP50Ms  float64           `json:"latency_p50_ms"`
+	LatencyP90Ms  float64           `json:"latency_p90_ms"`
+	LatencyP95Ms  float64           `json:"latency_p95_ms"`
+	LatencyMaxMs  float64           `json:"latency_max_ms"`
+	// LatencyQuantiles holds the requested quantiles, keyed by QuantileKey.
+	LatencyQuantiles map[string]float64 `json:"latency_quantiles_ms,omitempty" gorm:"-"`
+	LatencyHistogram *LatencyHistogram  `json:"latency_histogram,omitempty" gorm:"-"`
+	// SLOs are the session's objectives for this service, evaluated.
+	SLOs          []SLOStatus   `json:"slos,omitempty" gorm:"-"`
+	AnomalyScores AnomalyScores `json:"anomaly_scores,omitempty" gorm:"-"`
+	// ErrorLogCount is the number of logs of severity ERROR or above.
+	ErrorLogCount *uint64 `json:"error_log_count,omitempty" gorm:"-"`
+	// ResourceMetrics are the service's runtime and host metrics, keyed by
+	// overlay, e.g. cpu_utilization or gc_pause_ms.
+	ResourceMetrics map[string]ResourceMetric `json:"resource_metrics,omitempty" gorm:"-"`
+	SplitValues     []string                  `json:"-" gorm:"type:Array(String)"`
+	GroupValues     []string                  `json:"-" gorm:"type:Array(String)"`
+	QuantileValues  []float64                 `json:"-" gorm:"type:Array(Float64)"`
+	HistogramCounts []uint64                  `json:"-" gorm:"type:Array(UInt64)"`
+}
+
+// NodeID identifies the service's node on the map; it is the service name
+// unless nodes are split by resource attributes.
+func (s Service) NodeID() string {
+	if s.ID != "" {
+		return s.ID
+	}
+	return s.ServiceName
+}
+
+func (e Edge) SourceNodeID() string {
+	if e.SourceID != "" {
+		return e.SourceID
+	}
+	return e.SourceServiceName
+}
+
+func (e Edge) TargetNodeID() string {
+	if e.TargetID != "" {
+		return e.TargetID
+	}
+	return e.TargetServiceName
 }
 
 func NewMapper(db *gorm.DB, otelTracer trace.Tracer, ctx context.Context) *Mapper {
 	return &Mapper{db: db, otelTracer: otelTracer, ctx: ctx}
 }
 
+// WithOptions returns a copy of the mapper that applies opts to its queries.
+func (m *Mapper) WithOptions(opts Options) *Mapper {
+	c := *m
+	c.opts = opts
+	return &c
+}
+
 func (m *Mapper) GetEdges(sessionToken string) ([]Edge, error) {
+	return cached(m, "edges", m.cacheKey("edges", sessionToken), func(m *Mapper) ([]Edge, error) {
+		return m.getEdges(sessionToken)
+	}, slices.Clone[[]Edge])
+}
+
+func (m *Mapper) getEdges(sessionToken string) ([]Edge, error) {
 	ctx, span := m.otelTracer.Start(m.ctx, "Mapper.GetEdges")
 	defer span.End()
 
@@ -114,16 +209,64 @@ func (m *Mapper) GetEdges(sessionToken string) ([]Edge, error) {
 		return nil, errorz.ErrSessionTokenRequired
 	}
 
+	source, err := m.DataSource(sessionToken)
+	if err != nil {
+		return nil, err
+	}
+	if source == DataSourceSpanMetrics {
+		return m.getEdgesFromSpanMetrics(ctx, sessionToken)
+	}
+	_, edgeCoverage, aggregated, err := m.aggregates(sessionToken)
+	if err != nil {
+		return nil, err
+	}
+	if aggregated {
+		return m.getEdgesFromAggregates(ctx, sessionToken, edgeCoverage)
+	}
+
+	policy, err := m.errorPolicy(sessionToken)
+	if err != nil {
+		return nil, err
+	}
+
+	filter, filterArgs := m.opts.where(sessionToken)
+	query := fmt.Sprintf(getEdgesQuery,
+		attributesExpr(len(m.opts.SplitBy), false),
+		filter,
+		policy.Expr("t"),
+		quantilesExpr("c.Duration", m.opts.Quantiles),
+		histogramExpr("c.Duration", m.opts.Histogram))
+	args := append([]any{sessionToken}, stringArgs(m.opts.SplitBy)...)
+	args = append(args, filterArgs...)
+
 	var edges []Edge
-	err := m.db.WithContext(ctx).Raw(getEdgesQuery, sessionToken).Scan(&edges).Error
+	start := time.Now()
+	err = m.db.WithContext(ctx).Raw(query, args...).Scan(&edges).Error
+	metrics.ObserveQuery("get_edges", start, err)
 	if err != nil {
 		return nil, errors.Join(errorz.ErrWhileGettingEdges, err)
 	}
 
+	for i := range edges {
+		e := &edges[i]
+		e.SourceGroups = zipGroups(m.opts.SplitBy, e.SourceSplitValues)
+		e.TargetGroups = zipGroups(m.opts.SplitBy, e.TargetSplitValues)
+		e.SourceID = NodeID(e.SourceServiceName, m.opts.SplitBy, e.SourceSplitValues)
+		e.TargetID = NodeID(e.TargetServiceName, m.opts.SplitBy, e.TargetSplitValues)
+		e.LatencyQuantiles = zipQuantiles(m.opts.Quantiles, e.QuantileValues)
+		e.LatencyHistogram = histogram(e.HistogramCounts)
+	}
+
 	return edges, nil
 }
 
 func (m *Mapper) GetServicesWithMetrics(sessionToken string) ([]Service, error) {
+	return cached(m, "services", m.cacheKey("services", sessionToken), func(m *Mapper) ([]Service, error) {
+		return m.getServicesWithMetrics(sessionToken)
+	}, slices.Clone[[]Service])
+}
+
+func (m *Mapper) getServicesWithMetrics(sessionToken string) ([]Service, error) {
 	ctx, span := m.otelTracer.Start(m.ctx, "Mapper.GetServicesWithMetrics")
 	defer span.End()
 
@@ -131,11 +274,59 @@ func (m *Mapper) GetServicesWithMetrics(sessionToken string) ([]Service, error)
 		return nil, errorz.ErrSessionTokenRequired
 	}
 
+	source, err := m.DataSource(sessionToken)
+	if err != nil {
+		return nil, err
+	}
+	if source == DataSourceSpanMetrics {
+		return m.getServicesFromSpanMetrics(ctx, sessionToken)
+	}
+	_, _, aggregated, err := m.aggregates(sessionToken)
+	if err != nil {
+		return nil, err
+	}
+	if aggregated {
+		return m.getServicesFromAggregates(ctx, sessionToken)
+	}
+
+	policy, err := m.errorPolicy(sessionToken)
+	if err != nil {
+		return nil, err
+	}
+
+	filter, filterArgs := m.opts.where(sessionToken)
+	query := fmt.Sprintf(getServicesWithMetricsQuery,
+		attributesExpr(len(m.opts.SplitBy), false),
+		attributesExpr(len(m.opts.GroupBy), true),
+		filter,
+		policy.Expr("t"),
+		quantilesExpr("t.Duration", m.opts.Quantiles),
+		histogramExpr("t.Duration", m.opts.Histogram))
+	args := append(stringArgs(m.opts.SplitBy), stringArgs(m.opts.GroupBy)...)
+	args = append(args, sessionToken)
+	args = append(args, filterArgs...)
+
 	var services []Service
-	err := m.db.WithContext(ctx).Raw(getServicesWithMetricsQuery, sessionToken).Scan(&services).Error
+	start := time.Now()
+	err = m.db.WithContext(ctx).Raw(query, args...).Scan(&services).Error
+	metrics.ObserveQuery("get_services_with_metrics", start, err)
 	if err != nil {
 		return nil, errors.Join(errorz.ErrWhileGettingServicesWithMetrics, err)
 	}
 
+	for i := range services {
+		svc := &services[i]
+		svc.Groups = zipGroups(m.opts.GroupBy, svc.GroupValues)
+		for k, v := range zipGroups(m.opts.SplitBy, svc.SplitValues) {
+			if svc.Groups == nil {
+				svc.Groups = map[string]string{}
+			}
+			svc.Groups[k] = v
+		}
+		svc.ID = NodeID(svc.ServiceName, m.opts.SplitBy, svc.SplitValues)
+		svc.LatencyQuantiles = zipQuantiles(m.opts.Quantiles, svc.QuantileValues)
+		svc.LatencyHistogram = histogram(svc.HistogramCounts)
+	}
+
 	return services, nil
 }
@@ -0,0 +1,145 @@
+package mapz
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+)
+
+// MaxAttributeKeys bounds how many resource attributes a map can be split or
+// grouped by.
+const MaxAttributeKeys = 5
+
+// VersionAttribute is the resource attribute nodes are split by to compare
+// versions of a service.
+const VersionAttribute = "service.version"
+
+var attributeKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.\-/]+$`)
+
+// Options tune how the service map is built.
+type Options struct {
+	// SplitBy lists resource attribute keys that are part of a node's
+	// identity, so e.g. the same service in staging and prod becomes two nodes.
+	SplitBy []string
+	// GroupBy lists resource attribute keys reported on each node, using the
+	// most frequent value, without splitting it. The UI can cluster on them.
+	GroupBy []string
+	// Filter keeps only traces matching the expression; nil keeps all.
+	Filter *Filter
+	// Errors overrides the error policy stored for the session.
+	Errors *ErrorPolicy
+	// Quantiles are extra latency quantiles reported on nodes and edges.
+	Quantiles []float64
+	// Histogram adds a log-bucketed latency histogram to nodes and edges.
+	Histogram bool
+	// Since, when set, ignores spans that started before it.
+	Since time.Time
+	// Source overrides the data source stored for the session.
+	Source string
+}
+
+// where compiles the filter and the time window into conditions on the spans
+// aliased t.
+func (o Options) where(sessionToken string) (string, []any) {
+	predicate, args := o.Filter.predicate(sessionToken)
+	if !o.Since.IsZero() {
+		predicate += "\n    AND t.Timestamp >= ?"
+		args = append(args, o.Since)
+	}
+	return predicate, args
+}
+
+// ParseAttributeKeys parses a comma separated list of resource attribute keys.
+func ParseAttributeKeys(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var keys []string
+	seen := map[string]bool{}
+	for _, k := range strings.Split(s, ",") {
+		k = strings.TrimSpace(k)
+		if !attributeKeyPattern.MatchString(k) {
+			return nil, errorz.ErrInvalidAttributeKey
+		}
+		if !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) > MaxAttributeKeys {
+		return nil, errorz.ErrInvalidAttributeKey
+	}
+	return keys, nil
+}
+
+// NodeID builds the node identifier of a service split by resource
+// attributes, e.g. checkout[deployment.environment=prod].
+func NodeID(serviceName string, keys, values []string) string {
+	if len(keys) == 0 {
+		return serviceName
+	}
+	parts := make([]string, 0, len(keys))
+	for i, k := range keys {
+		v := ""
+		if i < len(values) {
+			v = values[i]
+		}
+		parts = append(parts, k+"="+v)
+	}
+	return serviceName + "[" + strings.Join(parts, ",") + "]"
+}
+
+// GroupLabel renders group values in key order, for subtitles and labels.
+func GroupLabel(groups map[string]string) string {
+	keys := make([]string, 0, len(groups))
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+"="+groups[k])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// attributesExpr builds an Array(String) of n resource attribute lookups, each
+// bound to a key parameter. Aggregated lookups take the most frequent value.
+func attributesExpr(n int, aggregate bool) string {
+	if n == 0 {
+		return "CAST([], 'Array(String)')"
+	}
+	parts := make([]string, n)
+	for i := range parts {
+		if aggregate {
+			parts[i] = "anyHeavy(t.ResourceAttributes[?])"
+		} else {
+			parts[i] = "t.ResourceAttributes[?]"
+		}
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+func stringArgs(values []string) []any {
+	args := make([]any, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return args
+}
+
+func zipGroups(keys, values []string) map[string]string {
+	if len(keys) == 0 {
+		return nil
+	}
+	groups := make(map[string]string, len(keys))
+	for i, k := range keys {
+		if i < len(values) {
+			groups[k] = values[i]
+		}
+	}
+	return groups
+}
@@ -0,0 +1,202 @@
+package mapz
+
+import (
+	"errors"
+	"time"
+
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+	"github.com/jack5341/otel-map-server/internal/metrics"
+)
+
+const getEdgeCallStatsQuery = `
+WITH Spans AS (
+    SELECT
+        t.TraceId,
+        t.SpanId,
+        t.ParentSpanId,
+        t.ServiceName
+    FROM default.otel_traces AS t
+    WHERE t.ResourceAttributes['otelmap.session_token'] = ?
+),
+
+PerTrace AS (
+    SELECT
+        p.ServiceName AS source,
+        c.ServiceName AS target,
+        c.TraceId AS trace_id,
+        COUNT() AS calls
+    FROM Spans AS c
+    INNER JOIN Spans AS p
+        ON c.ParentSpanId = p.SpanId
+        AND c.TraceId = p.TraceId
+    WHERE c.ParentSpanId != '' AND p.ServiceName != c.ServiceName
+    GROUP BY source, target, trace_id
+)
+
+SELECT
+    source AS source_service_name,
+    target AS target_service_name,
+    COUNT() AS trace_count,
+    SUM(calls) AS total_calls,
+    ROUND(AVG(calls), 2) AS avg_calls_per_trace,
+    MAX(calls) AS max_calls_per_trace,
+    arrayMap(x -> x.2, arraySlice(arrayReverseSort(x -> x.1, groupArray(1000)((calls, trace_id))), 1, 3)) AS example_trace_ids
+FROM PerTrace
+GROUP BY source, target
+ORDER BY source, target
+`
+
+const getRepeatedChildSpansQuery = `
+WITH Spans AS (
+    SELECT
+        t.TraceId,
+        t.SpanId,
+        t.ParentSpanId,
+        t.ServiceName,
+        t.SpanName
+    FROM default.otel_traces AS t
+    WHERE t.ResourceAttributes['otelmap.session_token'] = ?
+),
+
+Repeats AS (
+    SELECT
+        p.ServiceName AS parent_service,
+        c.ServiceName AS child_service,
+        c.SpanName AS span_name,
+        c.TraceId AS trace_id,
+        c.ParentSpanId AS parent_span_id,
+        COUNT() AS repeats
+    FROM Spans AS c
+    INNER JOIN Spans AS p
+        ON c.ParentSpanId = p.SpanId
+        AND c.TraceId = p.TraceId
+    WHERE c.ParentSpanId != ''
+    GROUP BY parent_service, child_service, span_name, trace_id, parent_span_id
+    HAVING repeats >= ?
+)
+
+SELECT
+    parent_service AS parent_service_name,
+    child_service AS child_service_name,
+    span_name,
+    COUNT() AS occurrences,
+    MAX(repeats) AS max_repeats,
+    ROUND(AVG(repeats), 2) AS avg_repeats,
+    arrayMap(x -> x.2, arraySlice(arrayReverseSort(x -> x.1, groupArray(1000)((repeats, trace_id))), 1, 3)) AS example_trace_ids
+FROM Repeats
+GROUP BY parent_service, child_service, span_name
+ORDER BY occurrences DESC
+LIMIT 50
+`
+
+const getRecentSpanLinksQuery = `
+SELECT
+    t.TraceId AS trace_id,
+    t.SpanId AS span_id,
+    t.ParentSpanId AS parent_span_id,
+    t.ServiceName AS service_name,
+    t.SpanKind AS span_kind
+FROM default.otel_traces AS t
+WHERE t.ResourceAttributes['otelmap.session_token'] = ?
+    AND t.TraceId IN (
+        SELECT TraceId
+        FROM default.otel_traces
+        WHERE ResourceAttributes['otelmap.session_token'] = ?
+        GROUP BY TraceId
+        ORDER BY max(Timestamp) DESC
+        LIMIT ?
+    )
+`
+
+// EdgeCallStats describes how often a service calls another within a single
+// trace, with the traces that made the most calls as examples.
+type EdgeCallStats struct {
+	SourceServiceName string   `json:"source_service_name"`
+	TargetServiceName string   `json:"target_service_name"`
+	TraceCount        uint64   `json:"trace_count"`
+	TotalCalls        uint64   `json:"total_calls"`
+	AvgCallsPerTrace  float64  `json:"avg_calls_per_trace"`
+	MaxCallsPerTrace  uint64   `json:"max_calls_per_trace"`
+	ExampleTraceIDs   []string `json:"example_trace_ids" gorm:"type:Array(String)"`
+}
+
+// RepeatedChildSpans groups parents that issued the same child span several
+// times, the typical shape of an N+1 query.
+type RepeatedChildSpans struct {
+	ParentServiceName string   `json:"parent_service_name"`
+	ChildServiceName  string   `json:"child_service_name"`
+	SpanName          string   `json:"span_name"`
+	Occurrences       uint64   `json:"occurrences"`
+	MaxRepeats        uint64   `json:"max_repeats"`
+	AvgRepeats        float64  `json:"avg_repeats"`
+	ExampleTraceIDs   []string `json:"example_trace_ids" gorm:"type:Array(String)"`
+}
+
+// SpanLink is the minimal span data needed to rebuild a trace tree.
+type SpanLink struct {
+	TraceID      string `json:"trace_id" gorm:"column:trace_id"`
+	SpanID       string `json:"span_id" gorm:"column:span_id"`
+	ParentSpanID string `json:"parent_span_id" gorm:"column:parent_span_id"`
+	ServiceName  string `json:"service_name"`
+	SpanKind     string `json:"span_kind"`
+}
+
+func (m *Mapper) GetEdgeCallStats(sessionToken string) ([]EdgeCallStats, error) {
+	ctx, span := m.otelTracer.Start(m.ctx, "Mapper.GetEdgeCallStats")
+	defer span.End()
+
+	if sessionToken == "" {
+		return nil, errorz.ErrSessionTokenRequired
+	}
+
+	var stats []EdgeCallStats
+	start := time.Now()
+	err := m.db.WithContext(ctx).Raw(getEdgeCallStatsQuery, sessionToken).Scan(&stats).Error
+	metrics.ObserveQuery("get_edge_call_stats", start, err)
+	if err != nil {
+		return nil, errors.Join(errorz.ErrWhileGettingCallPatterns, err)
+	}
+
+	return stats, nil
+}
+
+// GetRepeatedChildSpans returns child spans repeated at least minRepeats
+// times under the same parent span.
+func (m *Mapper) GetRepeatedChildSpans(sessionToken string, minRepeats int) ([]RepeatedChildSpans, error) {
+	ctx, span := m.otelTracer.Start(m.ctx, "Mapper.GetRepeatedChildSpans")
+	defer span.End()
+
+	if sessionToken == "" {
+		return nil, errorz.ErrSessionTokenRequired
+	}
+
+	var repeats []RepeatedChildSpans
+	start := time.Now()
+	err := m.db.WithContext(ctx).Raw(getRepeatedChildSpansQuery, sessionToken, minRepeats).Scan(&repeats).Error
+	metrics.ObserveQuery("get_repeated_child_spans", start, err)
+	if err != nil {
+		return nil, errors.Join(errorz.ErrWhileGettingCallPatterns, err)
+	}
+
+	return repeats, nil
+}
+
+// GetRecentSpanLinks returns the span tree data of the most recent traces.
+func (m *Mapper) GetRecentSpanLinks(sessionToken string, traceLimit int) ([]SpanLink, error) {
+	ctx, span := m.otelTracer.Start(m.ctx, "Mapper.GetRecentSpanLinks")
+	defer span.End()
+
+	if sessionToken == "" {
+		return nil, errorz.ErrSessionTokenRequired
+	}
+
+	var links []SpanLink
+	start := time.Now()
+	err := m.db.WithContext(ctx).Raw(getRecentSpanLinksQuery, sessionToken, sessionToken, traceLimit).Scan(&links).Error
+	metrics.ObserveQuery("get_recent_span_links", start, err)
+	if err != nil {
+		return nil, errors.Join(errorz.ErrWhileGettingCallPatterns, err)
+	}
+
+	return links, nil
+}
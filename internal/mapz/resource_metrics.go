@@ -0,0 +1,394 @@
+package mapz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+	"github.com/jack5341/otel-map-server/internal/metrics"
+)
+
+const (
+	MetricKindGauge     = "gauge"
+	MetricKindSum       = "sum"
+	MetricKindHistogram = "histogram"
+)
+
+// metricKinds maps each metric kind to the table the collector's ClickHouse
+// exporter writes it to.
+var metricKinds = []struct{ kind, table string }{
+	{MetricKindGauge, "otel_metrics_gauge"},
+	{MetricKindSum, "otel_metrics_sum"},
+	{MetricKindHistogram, "otel_metrics_histogram"},
+}
+
+// cumulativeTemporality is the OTLP AggregationTemporality of cumulative
+// sums and histograms; delta is 1.
+const cumulativeTemporality = 2
+
+// resourceOverlays lists the metrics attached to service nodes. Each overlay
+// takes the first metric name a service reports; names cover current and
+// older semantic conventions and the common runtimes. Overlays ending in _ms
+// are converted to milliseconds.
+var resourceOverlays = []struct {
+	key   string
+	names []string
+}{
+	{"cpu_utilization", []string{"process.cpu.utilization", "jvm.cpu.recent_utilization", "process.runtime.jvm.cpu.utilization", "system.cpu.utilization"}},
+	{"memory_bytes", []string{"process.memory.usage", "jvm.memory.used", "process.runtime.jvm.memory.usage", "process.runtime.go.mem.heap_alloc", "system.memory.usage"}},
+	{"gc_pause_ms", []string{"jvm.gc.duration", "process.runtime.jvm.gc.duration", "process.runtime.go.gc.pause_ns"}},
+	{"db_pool_connections", []string{"db.client.connection.count", "db.client.connections.usage"}},
+	{"http_server_duration_ms", []string{"http.server.request.duration", "http.server.duration"}},
+}
+
+const getMetricNamesQuery = `
+SELECT
+    t.MetricName AS name,
+    any(t.MetricUnit) AS unit,
+    any(t.MetricDescription) AS description,
+    max(t.TimeUnix) AS last_seen
+FROM default.%[1]s AS t
+WHERE t.ResourceAttributes['otelmap.session_token'] = ?
+    AND t.ServiceName = ?
+GROUP BY name
+ORDER BY name
+`
+
+// getMetricPointsQuery aggregates the data points of each series, a metric
+// of one resource with one set of attributes, per bucket.
+const getMetricPointsQuery = `
+SELECT
+    t.ServiceName AS service_name,
+    t.MetricName AS metric_name,
+    cityHash64(toString(t.ResourceAttributes), toString(t.Attributes)) AS series,
+    toStartOfInterval(t.TimeUnix, INTERVAL %[2]d SECOND) AS bucket,
+    any(t.MetricUnit) AS unit,
+    %[3]s
+FROM default.%[1]s AS t
+WHERE t.ResourceAttributes['otelmap.session_token'] = ?
+    AND t.MetricName IN ?
+    AND t.TimeUnix >= ?%[4]s
+GROUP BY service_name, metric_name, series, bucket
+ORDER BY service_name, metric_name, series, bucket
+`
+
+var metricPointColumns = map[string]string{
+	MetricKindGauge: `toInt32(0) AS temporality,
+    false AS monotonic,
+    avg(t.Value) AS avg_value,
+    max(t.Value) AS max_value,
+    sum(t.Value) AS sum_value,
+    argMax(t.Value, t.TimeUnix) AS last_value,
+    toUInt64(0) AS sum_count,
+    toUInt64(0) AS last_count`,
+	MetricKindSum: `toInt32(any(t.AggregationTemporality)) AS temporality,
+    any(t.IsMonotonic) AS monotonic,
+    avg(t.Value) AS avg_value,
+    max(t.Value) AS max_value,
+    sum(t.Value) AS sum_value,
+    argMax(t.Value, t.TimeUnix) AS last_value,
+    toUInt64(0) AS sum_count,
+    toUInt64(0) AS last_count`,
+	MetricKindHistogram: `toInt32(any(t.AggregationTemporality)) AS temporality,
+    true AS monotonic,
+    if(sum(t.Count) = 0, 0, sum(t.Sum) / sum(t.Count)) AS avg_value,
+    max(t.Max) AS max_value,
+    sum(t.Sum) AS sum_value,
+    argMax(t.Sum, t.TimeUnix) AS last_value,
+    sum(t.Count) AS sum_count,
+    argMax(t.Count, t.TimeUnix) AS last_count`,
+}
+
+// MetricInfo describes a metric reported by a service.
+type MetricInfo struct {
+	Name        string    `json:"name"`
+	Kind        string    `json:"kind"`
+	Unit        string    `json:"unit"`
+	Description string    `json:"description"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+// MetricPoint is one bucket of a metric series. Value is the mean of a
+// gauge over the service's instances, the total of a non-monotonic sum, the
+// per-second rate of a monotonic sum, or the mean observation of a
+// histogram. Max is the largest data point, or histogram maximum.
+type MetricPoint struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+	Max   float64   `json:"max"`
+}
+
+type MetricSeries struct {
+	ServiceName string        `json:"service_name"`
+	Name        string        `json:"name"`
+	Kind        string        `json:"kind"`
+	Unit        string        `json:"unit"`
+	Points      []MetricPoint `json:"points"`
+}
+
+// ResourceMetric is a metric overlaid on a service node, averaged over the
+// overlay window.
+type ResourceMetric struct {
+	Metric string  `json:"metric"`
+	Unit   string  `json:"unit"`
+	Value  float64 `json:"value"`
+	Max    float64 `json:"max"`
+}
+
+type metricRow struct {
+	ServiceName string
+	MetricName  string
+	Series      uint64
+	Bucket      time.Time
+	Unit        string
+	Temporality int32
+	Monotonic   bool
+	AvgValue    float64
+	MaxValue    float64
+	SumValue    float64
+	LastValue   float64
+	SumCount    uint64
+	LastCount   uint64
+}
+
+// GetServiceMetricNames lists the metrics a service reports in the session.
+func (m *Mapper) GetServiceMetricNames(sessionToken, serviceName string) ([]MetricInfo, error) {
+	ctx, span := m.otelTracer.Start(m.ctx, "Mapper.GetServiceMetricNames")
+	defer span.End()
+
+	if sessionToken == "" {
+		return nil, errorz.ErrSessionTokenRequired
+	}
+
+	infos := []MetricInfo{}
+	for _, k := range metricKinds {
+		var rows []MetricInfo
+		start := time.Now()
+		err := m.db.WithContext(ctx).Raw(fmt.Sprintf(getMetricNamesQuery, k.table), sessionToken, serviceName).Scan(&rows).Error
+		metrics.ObserveQuery("get_metric_names", start, err)
+		if err != nil {
+			return nil, errors.Join(errorz.ErrWhileGettingResourceMetrics, err)
+		}
+		for _, row := range rows {
+			row.Kind = k.kind
+			infos = append(infos, row)
+		}
+	}
+
+	sort.SliceStable(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos, nil
+}
+
+// GetServiceMetricSeries returns the series of one metric of a service since
+// the given time, bucketed by step.
+func (m *Mapper) GetServiceMetricSeries(sessionToken, serviceName, name string, since time.Time, step time.Duration) (*MetricSeries, error) {
+	ctx, span := m.otelTracer.Start(m.ctx, "Mapper.GetServiceMetricSeries")
+	defer span.End()
+
+	if sessionToken == "" {
+		return nil, errorz.ErrSessionTokenRequired
+	}
+
+	for _, k := range metricKinds {
+		series, err := m.metricSeries(ctx, sessionToken, k.kind, k.table, []string{name}, serviceName, since, step)
+		if err != nil {
+			return nil, err
+		}
+		if len(series) > 0 {
+			return &series[0], nil
+		}
+	}
+	return nil, errorz.ErrMetricNotFound
+}
+
+// GetResourceMetrics returns the resource overlays of every service since the
+// given time, keyed by service name and overlay.
+func (m *Mapper) GetResourceMetrics(sessionToken string, since time.Time, step time.Duration) (map[string]map[string]ResourceMetric, error) {
+	ctx, span := m.otelTracer.Start(m.ctx, "Mapper.GetResourceMetrics")
+	defer span.End()
+
+	if sessionToken == "" {
+		return nil, errorz.ErrSessionTokenRequired
+	}
+
+	var names []string
+	for _, o := range resourceOverlays {
+		names = append(names, o.names...)
+	}
+	byName := map[[2]string]MetricSeries{}
+	for _, k := range metricKinds {
+		series, err := m.metricSeries(ctx, sessionToken, k.kind, k.table, names, "", since, step)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range series {
+			byName[[2]string{s.ServiceName, s.Name}] = s
+		}
+	}
+
+	overlays := map[string]map[string]ResourceMetric{}
+	for key, s := range byName {
+		if len(s.Points) == 0 {
+			continue
+		}
+		for _, o := range resourceOverlays {
+			rank := slices.Index(o.names, key[1])
+			if rank < 0 {
+				continue
+			}
+			if overlays[s.ServiceName] == nil {
+				overlays[s.ServiceName] = map[string]ResourceMetric{}
+			}
+			if current, ok := overlays[s.ServiceName][o.key]; ok && slices.Index(o.names, current.Metric) < rank {
+				continue
+			}
+			overlays[s.ServiceName][o.key] = overlay(o.key, s)
+		}
+	}
+	return overlays, nil
+}
+
+func overlay(key string, s MetricSeries) ResourceMetric {
+	scale, unit := 1.0, s.Unit
+	if strings.HasSuffix(key, "_ms") {
+		scale, unit = millisecondScale(s.Unit), "ms"
+	}
+	r := ResourceMetric{Metric: s.Name, Unit: unit}
+	for _, p := range s.Points {
+		r.Value += p.Value
+		r.Max = max(r.Max, p.Max)
+	}
+	r.Value = math.Round(r.Value/float64(len(s.Points))*scale*1e4) / 1e4
+	r.Max = math.Round(r.Max*scale*1e4) / 1e4
+	return r
+}
+
+func millisecondScale(unit string) float64 {
+	switch unit {
+	case "s":
+		return 1000
+	case "us":
+		return 1e-3
+	case "ns":
+		return 1e-6
+	default:
+		return 1
+	}
+}
+
+// metricSeries reads the points of the named metrics from one table and
+// combines each service's series per bucket. Rates and histogram means of
+// cumulative series are taken between consecutive buckets, so the first
+// bucket of such a series only contributes its maximum.
+func (m *Mapper) metricSeries(ctx context.Context, sessionToken, kind, table string, names []string, serviceName string, since time.Time, step time.Duration) ([]MetricSeries, error) {
+	serviceFilter := ""
+	args := []any{sessionToken, names, since}
+	if serviceName != "" {
+		serviceFilter = "\n    AND t.ServiceName = ?"
+		args = append(args, serviceName)
+	}
+	query := fmt.Sprintf(getMetricPointsQuery, table, int(step.Seconds()), metricPointColumns[kind], serviceFilter)
+
+	var rows []metricRow
+	start := time.Now()
+	err := m.db.WithContext(ctx).Raw(query, args...).Scan(&rows).Error
+	metrics.ObserveQuery("get_metric_points", start, err)
+	if err != nil {
+		return nil, errors.Join(errorz.ErrWhileGettingResourceMetrics, err)
+	}
+
+	type bucket struct {
+		value, count, max float64
+		seen              bool
+	}
+	var (
+		series  []MetricSeries
+		buckets map[time.Time]*bucket
+		prev    *metricRow
+	)
+	flush := func() {
+		if len(series) == 0 {
+			return
+		}
+		s := &series[len(series)-1]
+		for t, b := range buckets {
+			p := MetricPoint{Time: t, Value: b.value, Max: math.Round(b.max*1e4) / 1e4}
+			switch {
+			case kind == MetricKindGauge && b.count > 0:
+				p.Value = b.value / b.count
+			case kind == MetricKindHistogram:
+				if b.count == 0 {
+					continue
+				}
+				p.Value = b.value / b.count
+			}
+			if !b.seen {
+				continue
+			}
+			p.Value = math.Round(p.Value*1e4) / 1e4
+			s.Points = append(s.Points, p)
+		}
+		sort.Slice(s.Points, func(i, j int) bool { return s.Points[i].Time.Before(s.Points[j].Time) })
+	}
+
+	for i := range rows {
+		row := &rows[i]
+		if len(series) == 0 || series[len(series)-1].ServiceName != row.ServiceName || series[len(series)-1].Name != row.MetricName {
+			flush()
+			series = append(series, MetricSeries{ServiceName: row.ServiceName, Name: row.MetricName, Kind: kind, Unit: row.Unit, Points: []MetricPoint{}})
+			buckets = map[time.Time]*bucket{}
+			prev = nil
+		}
+		if prev != nil && prev.Series != row.Series {
+			prev = nil
+		}
+
+		b, ok := buckets[row.Bucket]
+		if !ok {
+			b = &bucket{}
+			buckets[row.Bucket] = b
+		}
+		b.max = max(b.max, row.MaxValue)
+
+		switch {
+		case kind == MetricKindGauge:
+			b.value += row.AvgValue
+			b.count++
+			b.seen = true
+		case kind == MetricKindSum && !row.Monotonic:
+			b.value += row.LastValue
+			b.seen = true
+		case row.Temporality != cumulativeTemporality:
+			if kind == MetricKindHistogram {
+				b.value += row.SumValue
+				b.count += float64(row.SumCount)
+			} else {
+				b.value += row.SumValue / step.Seconds()
+			}
+			b.seen = true
+		case prev != nil:
+			sum, count := row.LastValue-prev.LastValue, float64(row.LastCount)-float64(prev.LastCount)
+			if sum < 0 || count < 0 {
+				// The series restarted; its counters begin at zero again.
+				sum, count = row.LastValue, float64(row.LastCount)
+			}
+			if kind == MetricKindHistogram {
+				b.value += sum
+				b.count += count
+			} else {
+				b.value += sum / step.Seconds()
+			}
+			b.seen = true
+		}
+		prev = row
+	}
+	flush()
+
+	return series, nil
+}
@@ -0,0 +1,387 @@
+package mapz
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+	"github.com/jack5341/otel-map-server/internal/metrics"
+	"github.com/jack5341/otel-map-server/internal/models"
+)
+
+const (
+	MaxSLOWindows = 5
+	minSLOWindow  = time.Minute
+	maxSLOWindow  = 30 * 24 * time.Hour
+)
+
+// DefaultSLOWindows is used when no windows are requested.
+var DefaultSLOWindows = []time.Duration{time.Hour}
+
+const getSLOsQuery = `
+SELECT
+    id,
+    argMax(service_name, updated_at) AS service_name,
+    argMax(operation, updated_at) AS operation,
+    argMax(availability_target, updated_at) AS availability_target,
+    argMax(latency_threshold_ms, updated_at) AS latency_threshold_ms,
+    argMax(latency_target, updated_at) AS latency_target,
+    argMax(deleted, updated_at) AS deleted
+FROM slos
+WHERE token = ?
+GROUP BY id
+HAVING NOT deleted
+ORDER BY service_name, operation
+`
+
+// evaluateSLOsQuery counts the entry spans of each objective's service,
+// optionally of one operation, in each window, in one scan grouped by the
+// objective's position. Windows end at the session's latest span so replayed
+// sessions are evaluated like live ones.
+const evaluateSLOsQuery = `
+WITH (
+    SELECT max(Timestamp)
+    FROM default.otel_traces
+    WHERE ResourceAttributes['otelmap.session_token'] = ?
+) AS window_end
+SELECT
+    toUInt32(arrayJoin(arrayFilter(i -> i > 0, %[1]s))) AS slo_index,
+    %[2]s AS requests,
+    %[3]s AS errors,
+    %[4]s AS fast,
+    %[5]s AS satisfied,
+    %[6]s AS tolerating
+FROM default.otel_traces AS t
+WHERE t.ResourceAttributes['otelmap.session_token'] = ?
+    AND t.ServiceName IN ?
+    AND upper(t.SpanKind) IN ('SERVER', 'SPAN_KIND_SERVER', 'CONSUMER', 'SPAN_KIND_CONSUMER')
+    AND t.Timestamp >= window_end - INTERVAL %[7]d SECOND
+GROUP BY slo_index
+`
+
+// sloMatchExpr matches the spans of one objective; an empty operation matches
+// every operation of the service.
+const sloMatchExpr = `(t.ServiceName = ? AND (? = '' OR t.SpanName = ? OR multiIf(
+        has(t.SpanAttributes, 'http.route'),
+        t.SpanAttributes['http.method'] || ' ' || t.SpanAttributes['http.route'],
+        t.SpanName
+    ) = ?))`
+
+// SLO is an objective for the requests served by a service, or by one of its
+// operations (a span name or "METHOD /route" path). LatencyThresholdMs is both
+// the latency SLI threshold and the Apdex T. A zero target disables that
+// objective.
+type SLO struct {
+	ID                 uuid.UUID `json:"id"`
+	ServiceName        string    `json:"service_name"`
+	Operation          string    `json:"operation,omitempty"`
+	AvailabilityTarget float64   `json:"availability_target"`
+	LatencyThresholdMs float64   `json:"latency_threshold_ms"`
+	LatencyTarget      float64   `json:"latency_target"`
+}
+
+// SLOWindow is the evaluation of an SLO over one window. Burn rates above 1
+// consume the error budget faster than the objective allows.
+type SLOWindow struct {
+	Window               string  `json:"window"`
+	Requests             uint64  `json:"requests"`
+	Errors               uint64  `json:"errors"`
+	Availability         float64 `json:"availability"`
+	LatencyAttainment    float64 `json:"latency_attainment"`
+	Apdex                float64 `json:"apdex"`
+	AvailabilityBurnRate float64 `json:"availability_burn_rate"`
+	LatencyBurnRate      float64 `json:"latency_burn_rate"`
+	Met                  bool    `json:"met"`
+}
+
+type SLOStatus struct {
+	SLO     SLO         `json:"slo"`
+	Windows []SLOWindow `json:"windows"`
+}
+
+type sloCounts struct {
+	SLOIndex   uint32
+	Requests   []uint64 `gorm:"type:Array(UInt64)"`
+	Errors     []uint64 `gorm:"type:Array(UInt64)"`
+	Fast       []uint64 `gorm:"type:Array(UInt64)"`
+	Satisfied  []uint64 `gorm:"type:Array(UInt64)"`
+	Tolerating []uint64 `gorm:"type:Array(UInt64)"`
+}
+
+// Validate checks the objective's targets and threshold.
+func (s SLO) Validate() error {
+	if s.ServiceName == "" || s.LatencyThresholdMs <= 0 {
+		return errorz.ErrInvalidSLO
+	}
+	for _, target := range []float64{s.AvailabilityTarget, s.LatencyTarget} {
+		if target < 0 || target >= 1 {
+			return errorz.ErrInvalidSLO
+		}
+	}
+	return nil
+}
+
+// ParseWindows parses a comma separated list of durations such as "5m,1h,7d".
+func ParseWindows(s string) ([]time.Duration, error) {
+	if s == "" {
+		return DefaultSLOWindows, nil
+	}
+	var windows []time.Duration
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		var d time.Duration
+		var err error
+		if days, ok := strings.CutSuffix(part, "d"); ok {
+			var n int
+			n, err = strconv.Atoi(days)
+			d = time.Duration(n) * 24 * time.Hour
+		} else {
+			d, err = time.ParseDuration(part)
+		}
+		if err != nil || d < minSLOWindow || d > maxSLOWindow {
+			return nil, errorz.ErrInvalidWindow
+		}
+		windows = append(windows, d)
+	}
+	if len(windows) > MaxSLOWindows {
+		return nil, errorz.ErrInvalidWindow
+	}
+	return windows, nil
+}
+
+func (m *Mapper) GetSLOs(sessionToken string) ([]SLO, error) {
+	ctx, span := m.otelTracer.Start(m.ctx, "Mapper.GetSLOs")
+	defer span.End()
+
+	token, err := uuid.Parse(sessionToken)
+	if err != nil {
+		return nil, errorz.ErrInvalidSessionToken
+	}
+
+	var slos []SLO
+	start := time.Now()
+	err = m.db.WithContext(ctx).Raw(getSLOsQuery, token).Scan(&slos).Error
+	metrics.ObserveQuery("get_slos", start, err)
+	if err != nil {
+		return nil, errors.Join(errorz.ErrWhileGettingSLOs, err)
+	}
+
+	return slos, nil
+}
+
+// SaveSLO stores a new objective, or replaces the one with the same ID.
+func (m *Mapper) SaveSLO(sessionToken string, slo SLO) (SLO, error) {
+	ctx, span := m.otelTracer.Start(m.ctx, "Mapper.SaveSLO")
+	defer span.End()
+
+	token, err := uuid.Parse(sessionToken)
+	if err != nil {
+		return SLO{}, errorz.ErrInvalidSessionToken
+	}
+	if err := slo.Validate(); err != nil {
+		return SLO{}, err
+	}
+	if slo.ID == uuid.Nil {
+		slo.ID = uuid.New()
+	}
+
+	if err := m.db.WithContext(ctx).Create(sloRow(token, slo, false)).Error; err != nil {
+		return SLO{}, errors.Join(errorz.ErrWhileSavingSLO, err)
+	}
+	return slo, nil
+}
+
+func (m *Mapper) DeleteSLO(sessionToken string, id uuid.UUID) error {
+	ctx, span := m.otelTracer.Start(m.ctx, "Mapper.DeleteSLO")
+	defer span.End()
+
+	slos, err := m.GetSLOs(sessionToken)
+	if err != nil {
+		return err
+	}
+	for _, slo := range slos {
+		if slo.ID != id {
+			continue
+		}
+		token, _ := uuid.Parse(sessionToken)
+		if err := m.db.WithContext(ctx).Create(sloRow(token, slo, true)).Error; err != nil {
+			return errors.Join(errorz.ErrWhileSavingSLO, err)
+		}
+		return nil
+	}
+	return errorz.ErrSLONotFound
+}
+
+func sloRow(token uuid.UUID, slo SLO, deleted bool) *models.SLO {
+	return &models.SLO{
+		ID:                 slo.ID,
+		Token:              token,
+		ServiceName:        slo.ServiceName,
+		Operation:          slo.Operation,
+		AvailabilityTarget: slo.AvailabilityTarget,
+		LatencyThresholdMs: slo.LatencyThresholdMs,
+		LatencyTarget:      slo.LatencyTarget,
+		Deleted:            deleted,
+		UpdatedAt:          time.Now().UTC(),
+	}
+}
+
+// EvaluateSLOs evaluates every objective of the session over each window.
+func (m *Mapper) EvaluateSLOs(sessionToken string, windows []time.Duration) ([]SLOStatus, error) {
+	return cached(m, "slos", m.cacheKey("slos", sessionToken, windows), func(m *Mapper) ([]SLOStatus, error) {
+		slos, err := m.GetSLOs(sessionToken)
+		if err != nil {
+			return nil, err
+		}
+		return m.evaluateSLOs(sessionToken, slos, windows)
+	}, slices.Clone[[]SLOStatus])
+}
+
+func (m *Mapper) EvaluateSLO(sessionToken string, slo SLO, windows []time.Duration) (SLOStatus, error) {
+	statuses, err := m.evaluateSLOs(sessionToken, []SLO{slo}, windows)
+	if err != nil {
+		return SLOStatus{}, err
+	}
+	return statuses[0], nil
+}
+
+// evaluateSLOs evaluates the objectives with one query, whatever their number.
+func (m *Mapper) evaluateSLOs(sessionToken string, slos []SLO, windows []time.Duration) ([]SLOStatus, error) {
+	ctx, span := m.otelTracer.Start(m.ctx, "Mapper.EvaluateSLOs")
+	defer span.End()
+
+	if sessionToken == "" {
+		return nil, errorz.ErrSessionTokenRequired
+	}
+	if len(slos) == 0 {
+		return []SLOStatus{}, nil
+	}
+	if len(windows) == 0 {
+		windows = DefaultSLOWindows
+	}
+
+	policy, err := m.errorPolicy(sessionToken)
+	if err != nil {
+		return nil, err
+	}
+
+	// Windows and thresholds are validated numbers, so they are formatted
+	// into the query; the per-window counts are built from them. Each span
+	// is counted once for every objective it matches, at the objective's
+	// 1-based position.
+	matches := make([]string, len(slos))
+	thresholds := make([]string, len(slos))
+	services := make([]string, 0, len(slos))
+	var matchArgs []any
+	for i, slo := range slos {
+		matches[i] = fmt.Sprintf("if(%s, %d, 0)", sloMatchExpr, i+1)
+		thresholds[i] = strconv.FormatInt(int64(slo.LatencyThresholdMs*1e6), 10)
+		services = append(services, slo.ServiceName)
+		matchArgs = append(matchArgs, slo.ServiceName, slo.Operation, slo.Operation, slo.Operation)
+	}
+	threshold := "[" + strings.Join(thresholds, ", ") + "][slo_index]"
+	isError := policy.Expr("t")
+	var longest time.Duration
+	perWindow := func(condition string) string {
+		parts := make([]string, len(windows))
+		for i, w := range windows {
+			parts[i] = fmt.Sprintf("countIf(t.Timestamp >= window_end - INTERVAL %d SECOND%s)", int64(w.Seconds()), condition)
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	}
+	for _, w := range windows {
+		longest = max(longest, w)
+	}
+	query := fmt.Sprintf(evaluateSLOsQuery,
+		"["+strings.Join(matches, ", ")+"]",
+		perWindow(""),
+		perWindow(" AND "+isError),
+		perWindow(fmt.Sprintf(" AND t.Duration <= %s", threshold)),
+		perWindow(fmt.Sprintf(" AND NOT %s AND t.Duration <= %s", isError, threshold)),
+		perWindow(fmt.Sprintf(" AND NOT %s AND t.Duration > %s AND t.Duration <= 4 * %s", isError, threshold, threshold)),
+		int64(longest.Seconds()))
+	args := append([]any{sessionToken}, matchArgs...)
+	args = append(args, sessionToken, services)
+
+	var rows []sloCounts
+	start := time.Now()
+	err = m.db.WithContext(ctx).Raw(query, args...).Scan(&rows).Error
+	metrics.ObserveQuery("evaluate_slos", start, err)
+	if err != nil {
+		return nil, errors.Join(errorz.ErrWhileEvaluatingSLO, err)
+	}
+	counts := make(map[uint32]sloCounts, len(rows))
+	for _, row := range rows {
+		counts[row.SLOIndex] = row
+	}
+
+	statuses := make([]SLOStatus, len(slos))
+	for n, slo := range slos {
+		row := counts[uint32(n+1)]
+		status := SLOStatus{SLO: slo, Windows: make([]SLOWindow, len(windows))}
+		for i, w := range windows {
+			var c [5]uint64
+			for j, values := range [][]uint64{row.Requests, row.Errors, row.Fast, row.Satisfied, row.Tolerating} {
+				if i < len(values) {
+					c[j] = values[i]
+				}
+			}
+			status.Windows[i] = sloWindow(slo, w, c[0], c[1], c[2], c[3], c[4])
+		}
+		statuses[n] = status
+	}
+	return statuses, nil
+}
+
+// sloWindow derives the SLIs from the window's counts. Errors count as
+// frustrated for Apdex. A window without requests burns no budget.
+func sloWindow(slo SLO, window time.Duration, requests, errs, fast, satisfied, tolerating uint64) SLOWindow {
+	w := SLOWindow{
+		Window:            formatWindow(window),
+		Requests:          requests,
+		Errors:            errs,
+		Availability:      1,
+		LatencyAttainment: 1,
+		Apdex:             1,
+	}
+	if requests > 0 {
+		total := float64(requests)
+		w.Availability = 1 - float64(errs)/total
+		w.LatencyAttainment = float64(fast) / total
+		w.Apdex = (float64(satisfied) + float64(tolerating)/2) / total
+	}
+
+	w.Met = true
+	if slo.AvailabilityTarget > 0 {
+		w.AvailabilityBurnRate = (1 - w.Availability) / (1 - slo.AvailabilityTarget)
+		w.Met = w.Met && w.Availability >= slo.AvailabilityTarget
+	}
+	if slo.LatencyTarget > 0 {
+		w.LatencyBurnRate = (1 - w.LatencyAttainment) / (1 - slo.LatencyTarget)
+		w.Met = w.Met && w.LatencyAttainment >= slo.LatencyTarget
+	}
+
+	for _, v := range []*float64{&w.Availability, &w.LatencyAttainment, &w.Apdex, &w.AvailabilityBurnRate, &w.LatencyBurnRate} {
+		*v = math.Round(*v*1e4) / 1e4
+	}
+	return w
+}
+
+func formatWindow(d time.Duration) string {
+	switch {
+	case d%(24*time.Hour) == 0:
+		return strconv.Itoa(int(d/(24*time.Hour))) + "d"
+	case d%time.Hour == 0:
+		return strconv.Itoa(int(d/time.Hour)) + "h"
+	case d%time.Minute == 0:
+		return strconv.Itoa(int(d/time.Minute)) + "m"
+	default:
+		return d.String()
+	}
+}
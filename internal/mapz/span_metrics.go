@@ -0,0 +1,411 @@
+package mapz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+	"github.com/jack5341/otel-map-server/internal/metrics"
+)
+
+// Metric names written by the spanmetrics connector, with and without its
+// default traces.span.metrics namespace, and by the servicegraph connector
+// in its current and legacy naming.
+var (
+	spanMetricsCallNames     = []string{"traces.span.metrics.calls", "calls", "calls_total"}
+	spanMetricsDurationNames = []string{"traces.span.metrics.duration", "duration", "latency"}
+	serviceGraphRequestNames = []string{"traces_service_graph_request_total"}
+	serviceGraphFailedNames  = []string{"traces_service_graph_request_failed_total"}
+	serviceGraphLatencyNames = []string{"traces_service_graph_request_server_seconds", "traces_service_graph_request_server"}
+)
+
+// spanMetricsTokenExpr matches the metrics of the session bound to the first
+// parameter. Span metrics keep the spans' resource attributes; servicegraph
+// metrics only carry the token when it is configured as a dimension, which
+// the connector prefixes with client_ and server_.
+const spanMetricsTokenExpr = `? IN (t.ResourceAttributes['otelmap.session_token'], t.Attributes['server_otelmap.session_token'], t.Attributes['client_otelmap.session_token'])`
+
+// getMetricCountersQuery totals each counter series: %[1]s selects the keys
+// it is attributed to, %[3]s groups by them.
+const getMetricCountersQuery = `
+SELECT
+    t.MetricName AS metric_name,
+    %[1]s,
+    toInt32(any(t.AggregationTemporality)) AS temporality,
+    sum(t.Value) AS sum_value,
+    argMax(t.Value, t.TimeUnix) AS last_value,
+    argMin(t.Value, t.TimeUnix) AS first_value,
+    min(t.StartTimeUnix) AS start_time,
+    min(t.TimeUnix) AS first_time,
+    max(t.TimeUnix) AS end_time
+FROM default.otel_metrics_sum AS t
+WHERE ` + spanMetricsTokenExpr + `
+    AND t.MetricName IN ?%[2]s
+GROUP BY metric_name, %[3]s, cityHash64(toString(t.ResourceAttributes), toString(t.Attributes))
+`
+
+const getMetricHistogramsQuery = `
+SELECT
+    t.MetricName AS metric_name,
+    %[1]s,
+    any(t.MetricUnit) AS unit,
+    toInt32(any(t.AggregationTemporality)) AS temporality,
+    any(t.ExplicitBounds) AS bounds,
+    sumForEach(t.BucketCounts) AS sum_buckets,
+    argMax(t.BucketCounts, t.TimeUnix) AS last_buckets,
+    argMin(t.BucketCounts, t.TimeUnix) AS first_buckets,
+    max(t.Max) AS max_value
+FROM default.otel_metrics_histogram AS t
+WHERE ` + spanMetricsTokenExpr + `
+    AND t.MetricName IN ?%[2]s
+GROUP BY metric_name, %[3]s, cityHash64(toString(t.ResourceAttributes), toString(t.Attributes))
+`
+
+const (
+	spanMetricsKeys   = "t.ServiceName AS service_name, t.Attributes['status.code'] AS status_code"
+	spanMetricsGroup  = "service_name, status_code"
+	serviceGraphKeys  = "t.Attributes['client'] AS client, t.Attributes['server'] AS server"
+	serviceGraphGroup = "client, server"
+)
+
+type counterRow struct {
+	MetricName  string
+	ServiceName string
+	StatusCode  string
+	Client      string
+	Server      string
+	Temporality int32
+	SumValue    float64
+	LastValue   float64
+	FirstValue  float64
+	StartTime   time.Time
+	FirstTime   time.Time
+	EndTime     time.Time
+}
+
+type histogramRow struct {
+	MetricName   string
+	ServiceName  string
+	Client       string
+	Server       string
+	Unit         string
+	Temporality  int32
+	Bounds       []float64 `gorm:"type:Array(Float64)"`
+	SumBuckets   []uint64  `gorm:"type:Array(UInt64)"`
+	LastBuckets  []uint64  `gorm:"type:Array(UInt64)"`
+	FirstBuckets []uint64  `gorm:"type:Array(UInt64)"`
+	MaxValue     float64
+}
+
+// durations merges the duration histograms of one node or edge, in
+// milliseconds.
+type durations struct {
+	boundsMs []float64
+	counts   []uint64
+	maxMs    float64
+}
+
+// total is what the series counted in the window. A cumulative series that
+// started before the window only counts its growth in it; one that went
+// backwards restarted and counts its last value.
+func (r counterRow) total(since time.Time) float64 {
+	if r.Temporality != cumulativeTemporality {
+		return r.SumValue
+	}
+	if since.IsZero() || r.LastValue < r.FirstValue {
+		return r.LastValue
+	}
+	return r.LastValue - r.FirstValue
+}
+
+func (r histogramRow) counts(since time.Time) []uint64 {
+	if r.Temporality != cumulativeTemporality {
+		return r.SumBuckets
+	}
+	if since.IsZero() || len(r.FirstBuckets) != len(r.LastBuckets) {
+		return r.LastBuckets
+	}
+	counts := make([]uint64, len(r.LastBuckets))
+	for i, last := range r.LastBuckets {
+		if last < r.FirstBuckets[i] {
+			return r.LastBuckets
+		}
+		counts[i] = last - r.FirstBuckets[i]
+	}
+	return counts
+}
+
+func (d *durations) add(r histogramRow, since time.Time) {
+	scale := millisecondScale(r.Unit)
+	if r.Unit == "" && strings.HasPrefix(r.MetricName, "traces_service_graph") {
+		// The servicegraph connector reports seconds, naming the unit only
+		// in its legacy metric names.
+		scale = 1000
+	}
+	bounds := make([]float64, len(r.Bounds))
+	for i, b := range r.Bounds {
+		bounds[i] = b * scale
+	}
+	counts := r.counts(since)
+	if len(counts) != len(bounds)+1 {
+		return
+	}
+	if d.counts == nil {
+		d.boundsMs, d.counts = bounds, make([]uint64, len(counts))
+	} else if !slices.Equal(d.boundsMs, bounds) {
+		// Series of one node bucketed differently cannot be merged; the
+		// first one read wins.
+		return
+	}
+	for i, c := range counts {
+		d.counts[i] += c
+	}
+	d.maxMs = max(d.maxMs, r.MaxValue*scale)
+}
+
+// quantile interpolates the q quantile linearly within its bucket. The
+// overflow bucket has no upper bound, so quantiles in it report its lower
+// bound, or the maximum when the exporter recorded one.
+func (d *durations) quantile(q float64) float64 {
+	var total uint64
+	for _, c := range d.counts {
+		total += c
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := q * float64(total)
+	var seen float64
+	for i, c := range d.counts {
+		if c == 0 || seen+float64(c) < rank {
+			seen += float64(c)
+			continue
+		}
+		lower := 0.0
+		if i > 0 {
+			lower = d.boundsMs[i-1]
+		}
+		if i == len(d.boundsMs) {
+			return math.Round(max(lower, d.maxMs)*100) / 100
+		}
+		upper := d.boundsMs[i]
+		return math.Round((lower+(upper-lower)*(rank-seen)/float64(c))*100) / 100
+	}
+	return math.Round(d.max()*100) / 100
+}
+
+// max is the recorded maximum, or else the upper bound of the highest
+// non-empty bucket.
+func (d *durations) max() float64 {
+	if d.maxMs > 0 {
+		return math.Round(d.maxMs*100) / 100
+	}
+	for i := len(d.counts) - 1; i >= 0; i-- {
+		if d.counts[i] == 0 {
+			continue
+		}
+		if i < len(d.boundsMs) {
+			return d.boundsMs[i]
+		}
+		if i > 0 {
+			return d.boundsMs[i-1]
+		}
+	}
+	return 0
+}
+
+func (d *durations) quantiles(quantiles []float64) []float64 {
+	values := make([]float64, len(quantiles))
+	for i, q := range quantiles {
+		values[i] = d.quantile(q)
+	}
+	return values
+}
+
+// getServicesFromSpanMetrics builds the map's nodes from the spanmetrics
+// connector's call counters and duration histograms.
+func (m *Mapper) getServicesFromSpanMetrics(ctx context.Context, sessionToken string) ([]Service, error) {
+	counters, err := m.metricCounters(ctx, sessionToken, spanMetricsKeys, spanMetricsGroup, spanMetricsCallNames)
+	if err != nil {
+		return nil, err
+	}
+	histograms, err := m.metricHistograms(ctx, sessionToken, spanMetricsKeys, spanMetricsGroup, spanMetricsDurationNames)
+	if err != nil {
+		return nil, err
+	}
+
+	byName := map[string]*Service{}
+	var order []string
+	for _, row := range counters {
+		svc, ok := byName[row.ServiceName]
+		if !ok {
+			svc = &Service{ID: row.ServiceName, ServiceName: row.ServiceName}
+			byName[row.ServiceName] = svc
+			order = append(order, row.ServiceName)
+		}
+		total := int64(math.Round(row.total(m.opts.Since)))
+		svc.TotalRequests += total
+		if IsErrorStatus(row.StatusCode) {
+			svc.ErrorCount += total
+		}
+	}
+
+	latencies := map[string]*durations{}
+	for _, row := range histograms {
+		d, ok := latencies[row.ServiceName]
+		if !ok {
+			d = &durations{}
+			latencies[row.ServiceName] = d
+		}
+		d.add(row, m.opts.Since)
+	}
+
+	services := make([]Service, 0, len(order))
+	for _, name := range order {
+		svc := byName[name]
+		if svc.TotalRequests == 0 {
+			continue
+		}
+		svc.ErrorRate = math.Round(float64(svc.ErrorCount)/float64(svc.TotalRequests)*1e4) / 1e4
+		if d, ok := latencies[name]; ok {
+			svc.LatencyP50Ms = d.quantile(0.50)
+			svc.LatencyP90Ms = d.quantile(0.90)
+			svc.LatencyP95Ms = d.quantile(0.95)
+			svc.LatencyMaxMs = d.max()
+			svc.LatencyQuantiles = zipQuantiles(m.opts.Quantiles, d.quantiles(m.opts.Quantiles))
+		}
+		services = append(services, *svc)
+	}
+
+	sort.SliceStable(services, func(i, j int) bool { return services[i].TotalRequests > services[j].TotalRequests })
+	return services, nil
+}
+
+// getEdgesFromSpanMetrics builds the map's edges from the servicegraph
+// connector's request counters and server latency histograms. The connector
+// does not record operations, so every edge has an empty target path.
+func (m *Mapper) getEdgesFromSpanMetrics(ctx context.Context, sessionToken string) ([]Edge, error) {
+	names := append(slices.Clone(serviceGraphRequestNames), serviceGraphFailedNames...)
+	counters, err := m.metricCounters(ctx, sessionToken, serviceGraphKeys, serviceGraphGroup, names)
+	if err != nil {
+		return nil, err
+	}
+	histograms, err := m.metricHistograms(ctx, sessionToken, serviceGraphKeys, serviceGraphGroup, serviceGraphLatencyNames)
+	if err != nil {
+		return nil, err
+	}
+
+	type edgeKey struct{ client, server string }
+	byKey := map[edgeKey]*Edge{}
+	var order []edgeKey
+	var from, to time.Time
+	for _, row := range counters {
+		key := edgeKey{row.Client, row.Server}
+		e, ok := byKey[key]
+		if !ok {
+			e = &Edge{
+				SourceID:          row.Client,
+				SourceServiceName: row.Client,
+				TargetID:          row.Server,
+				TargetServiceName: row.Server,
+			}
+			byKey[key] = e
+			order = append(order, key)
+		}
+		total := uint64(math.Round(row.total(m.opts.Since)))
+		if slices.Contains(serviceGraphFailedNames, row.MetricName) {
+			e.ErrorCount += total
+		} else {
+			e.TotalRequests += total
+		}
+
+		start := row.StartTime
+		if start.Unix() <= 0 {
+			start = row.FirstTime
+		}
+		if from.IsZero() || start.Before(from) {
+			from = start
+		}
+		if row.EndTime.After(to) {
+			to = row.EndTime
+		}
+	}
+	if !m.opts.Since.IsZero() {
+		from = m.opts.Since
+	}
+	windowSeconds := to.Sub(from).Seconds()
+
+	latencies := map[edgeKey]*durations{}
+	for _, row := range histograms {
+		key := edgeKey{row.Client, row.Server}
+		d, ok := latencies[key]
+		if !ok {
+			d = &durations{}
+			latencies[key] = d
+		}
+		d.add(row, m.opts.Since)
+	}
+
+	edges := make([]Edge, 0, len(order))
+	for _, key := range order {
+		e := byKey[key]
+		if e.TotalRequests == 0 || key.client == "" || key.server == "" {
+			continue
+		}
+		e.ErrorRate = math.Round(float64(e.ErrorCount)/float64(e.TotalRequests)*1e4) / 1e4
+		if windowSeconds >= 1 {
+			e.RequestsPerSecond = math.Round(float64(e.TotalRequests)/windowSeconds*100) / 100
+		}
+		if d, ok := latencies[key]; ok {
+			e.LatencyMaxMs = d.max()
+			e.LatencyQuantiles = zipQuantiles(m.opts.Quantiles, d.quantiles(m.opts.Quantiles))
+		}
+		edges = append(edges, *e)
+	}
+
+	sort.SliceStable(edges, func(i, j int) bool {
+		if edges[i].SourceServiceName != edges[j].SourceServiceName {
+			return edges[i].SourceServiceName < edges[j].SourceServiceName
+		}
+		return edges[i].TargetServiceName < edges[j].TargetServiceName
+	})
+	return edges, nil
+}
+
+func (m *Mapper) metricCounters(ctx context.Context, sessionToken, keys, group string, names []string) ([]counterRow, error) {
+	since, args := m.metricsSince(sessionToken, names)
+	var rows []counterRow
+	start := time.Now()
+	err := m.db.WithContext(ctx).Raw(fmt.Sprintf(getMetricCountersQuery, keys, since, group), args...).Scan(&rows).Error
+	metrics.ObserveQuery("get_span_metric_counters", start, err)
+	if err != nil {
+		return nil, errors.Join(errorz.ErrWhileGettingSpanMetrics, err)
+	}
+	return rows, nil
+}
+
+func (m *Mapper) metricHistograms(ctx context.Context, sessionToken, keys, group string, names []string) ([]histogramRow, error) {
+	since, args := m.metricsSince(sessionToken, names)
+	var rows []histogramRow
+	start := time.Now()
+	err := m.db.WithContext(ctx).Raw(fmt.Sprintf(getMetricHistogramsQuery, keys, since, group), args...).Scan(&rows).Error
+	metrics.ObserveQuery("get_span_metric_histograms", start, err)
+	if err != nil {
+		return nil, errors.Join(errorz.ErrWhileGettingSpanMetrics, err)
+	}
+	return rows, nil
+}
+
+func (m *Mapper) metricsSince(sessionToken string, names []string) (string, []any) {
+	args := []any{sessionToken, names}
+	if m.opts.Since.IsZero() {
+		return "", args
+	}
+	return "\n    AND t.TimeUnix >= ?", append(args, m.opts.Since)
+}
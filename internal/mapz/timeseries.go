@@ -0,0 +1,288 @@
+package mapz
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+	"github.com/jack5341/otel-map-server/internal/metrics"
+)
+
+const (
+	DefaultStep = time.Minute
+	minStep     = 10 * time.Second
+	maxStep     = 24 * time.Hour
+	// MaxSeriesPoints bounds the buckets of one series.
+	MaxSeriesPoints = 2000
+)
+
+const getServiceSeriesQuery = `
+SELECT
+    t.ServiceName AS service_name,
+    %[1]s AS split_values,
+    toStartOfInterval(t.Timestamp, INTERVAL %[4]d SECOND) AS bucket,
+    COUNT() AS requests,
+    countIf(%[3]s) AS errors,
+    ROUND(quantileTDigest(0.95)(t.Duration) / 1000000, 2) AS latency_p95_ms
+FROM otel_traces AS t
+WHERE t.ResourceAttributes['otelmap.session_token'] = ?%[2]s
+GROUP BY service_name, split_values, bucket
+ORDER BY service_name, split_values, bucket
+`
+
+const getEdgeSeriesQuery = `
+WITH Spans AS (
+    SELECT
+        t.TraceId,
+        t.SpanId,
+        t.ParentSpanId,
+        t.ServiceName,
+        %[1]s AS SplitValues,
+        %[3]s AS IsError,
+        t.Duration,
+        toStartOfInterval(t.Timestamp, INTERVAL %[4]d SECOND) AS Bucket
+    FROM default.otel_traces AS t
+    WHERE t.ResourceAttributes['otelmap.session_token'] = ?%[2]s
+)
+
+SELECT
+    p.ServiceName AS source_service_name,
+    p.SplitValues AS source_split_values,
+    c.ServiceName AS target_service_name,
+    c.SplitValues AS target_split_values,
+    c.Bucket AS bucket,
+    COUNT() AS requests,
+    countIf(c.IsError) AS errors,
+    ROUND(quantileTDigest(0.95)(c.Duration) / 1000000, 2) AS latency_p95_ms
+FROM Spans AS c
+INNER JOIN Spans AS p
+    ON c.ParentSpanId = p.SpanId
+    AND c.TraceId = p.TraceId
+WHERE c.ParentSpanId != ''
+GROUP BY source_service_name, source_split_values, target_service_name, target_split_values, bucket
+ORDER BY source_service_name, source_split_values, target_service_name, target_split_values, bucket
+`
+
+// SeriesPoint holds the RED metrics of one time bucket, which starts at Time.
+type SeriesPoint struct {
+	Time              time.Time `json:"time"`
+	Requests          uint64    `json:"requests"`
+	Errors            uint64    `json:"errors"`
+	ErrorRate         float64   `json:"error_rate"`
+	RequestsPerSecond float64   `json:"requests_per_second"`
+	LatencyP95Ms      float64   `json:"latency_p95_ms"`
+}
+
+// ServiceSeries is the time series of a node. Buckets without spans between
+// the session's first bucket and now are present with zero requests.
+type ServiceSeries struct {
+	ID          string            `json:"id"`
+	ServiceName string            `json:"service_name"`
+	Groups      map[string]string `json:"groups,omitempty"`
+	Points      []SeriesPoint     `json:"points"`
+}
+
+// EdgeSeries is the time series of calls between two nodes, over all paths.
+type EdgeSeries struct {
+	SourceID          string        `json:"source_id"`
+	SourceServiceName string        `json:"source_service_name"`
+	TargetID          string        `json:"target_id"`
+	TargetServiceName string        `json:"target_service_name"`
+	Points            []SeriesPoint `json:"points"`
+}
+
+// AnomalyScores are robust z-scores of the latest complete bucket of a node
+// or edge against its baseline, keyed by metric.
+type AnomalyScores map[string]float64
+
+type seriesRow struct {
+	SourceServiceName string
+	SourceSplitValues []string `gorm:"type:Array(String)"`
+	ServiceName       string
+	TargetServiceName string
+	SplitValues       []string `gorm:"type:Array(String)"`
+	TargetSplitValues []string `gorm:"type:Array(String)"`
+	Bucket            time.Time
+	Requests          uint64
+	Errors            uint64
+	LatencyP95Ms      float64
+}
+
+// ParseStep parses the bucket width of a time series, e.g. "30s" or "5m".
+func ParseStep(s string) (time.Duration, error) {
+	if s == "" {
+		return DefaultStep, nil
+	}
+	step, err := time.ParseDuration(s)
+	if err != nil || step < minStep || step > maxStep || step%time.Second != 0 {
+		return 0, errorz.ErrInvalidStep
+	}
+	return step, nil
+}
+
+func (m *Mapper) GetServiceSeries(sessionToken string, step time.Duration) ([]ServiceSeries, error) {
+	ctx, span := m.otelTracer.Start(m.ctx, "Mapper.GetServiceSeries")
+	defer span.End()
+
+	if sessionToken == "" {
+		return nil, errorz.ErrSessionTokenRequired
+	}
+
+	policy, err := m.errorPolicy(sessionToken)
+	if err != nil {
+		return nil, err
+	}
+
+	filter, filterArgs := m.opts.where(sessionToken)
+	query := fmt.Sprintf(getServiceSeriesQuery,
+		attributesExpr(len(m.opts.SplitBy), false),
+		filter,
+		policy.Expr("t"),
+		int64(step.Seconds()))
+	args := append(stringArgs(m.opts.SplitBy), sessionToken)
+	args = append(args, filterArgs...)
+
+	var rows []seriesRow
+	start := time.Now()
+	err = m.db.WithContext(ctx).Raw(query, args...).Scan(&rows).Error
+	metrics.ObserveQuery("get_service_series", start, err)
+	if err != nil {
+		return nil, errors.Join(errorz.ErrWhileGettingSeries, err)
+	}
+
+	first, last, err := seriesBounds(rows, step, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	var series []ServiceSeries
+	index := map[string]int{}
+	for _, row := range rows {
+		id := NodeID(row.ServiceName, m.opts.SplitBy, row.SplitValues)
+		i, ok := index[id]
+		if !ok {
+			i = len(series)
+			index[id] = i
+			series = append(series, ServiceSeries{
+				ID:          id,
+				ServiceName: row.ServiceName,
+				Groups:      zipGroups(m.opts.SplitBy, row.SplitValues),
+				Points:      emptyPoints(first, last, step),
+			})
+		}
+		series[i].Points[bucketIndex(first, row.Bucket, step)] = point(row, step)
+	}
+	return series, nil
+}
+
+func (m *Mapper) GetEdgeSeries(sessionToken string, step time.Duration) ([]EdgeSeries, error) {
+	ctx, span := m.otelTracer.Start(m.ctx, "Mapper.GetEdgeSeries")
+	defer span.End()
+
+	if sessionToken == "" {
+		return nil, errorz.ErrSessionTokenRequired
+	}
+
+	policy, err := m.errorPolicy(sessionToken)
+	if err != nil {
+		return nil, err
+	}
+
+	filter, filterArgs := m.opts.where(sessionToken)
+	query := fmt.Sprintf(getEdgeSeriesQuery,
+		attributesExpr(len(m.opts.SplitBy), false),
+		filter,
+		policy.Expr("t"),
+		int64(step.Seconds()))
+	args := append(stringArgs(m.opts.SplitBy), sessionToken)
+	args = append(args, filterArgs...)
+
+	var rows []seriesRow
+	start := time.Now()
+	err = m.db.WithContext(ctx).Raw(query, args...).Scan(&rows).Error
+	metrics.ObserveQuery("get_edge_series", start, err)
+	if err != nil {
+		return nil, errors.Join(errorz.ErrWhileGettingSeries, err)
+	}
+
+	first, last, err := seriesBounds(rows, step, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	var series []EdgeSeries
+	index := map[string]int{}
+	for _, row := range rows {
+		sourceID := NodeID(row.SourceServiceName, m.opts.SplitBy, row.SourceSplitValues)
+		targetID := NodeID(row.TargetServiceName, m.opts.SplitBy, row.TargetSplitValues)
+		key := sourceID + "\x00" + targetID
+		i, ok := index[key]
+		if !ok {
+			i = len(series)
+			index[key] = i
+			series = append(series, EdgeSeries{
+				SourceID:          sourceID,
+				SourceServiceName: row.SourceServiceName,
+				TargetID:          targetID,
+				TargetServiceName: row.TargetServiceName,
+				Points:            emptyPoints(first, last, step),
+			})
+		}
+		series[i].Points[bucketIndex(first, row.Bucket, step)] = point(row, step)
+	}
+	return series, nil
+}
+
+// seriesBounds returns the first bucket of the rows and the bucket holding
+// now, refusing series longer than MaxSeriesPoints. Series run up to now so
+// that traffic stopping altogether shows as trailing buckets without
+// requests.
+func seriesBounds(rows []seriesRow, step time.Duration, now time.Time) (time.Time, time.Time, error) {
+	var first, last time.Time
+	for i, row := range rows {
+		if i == 0 || row.Bucket.Before(first) {
+			first = row.Bucket
+		}
+		if i == 0 || row.Bucket.After(last) {
+			last = row.Bucket
+		}
+	}
+	// Buckets start at multiples of the step since the Unix epoch, as
+	// toStartOfInterval aligns them.
+	seconds := int64(step.Seconds())
+	if current := time.Unix(now.Unix()-now.Unix()%seconds, 0); len(rows) > 0 && current.After(last) {
+		last = current
+	}
+	if len(rows) > 0 && last.Sub(first)/step >= MaxSeriesPoints {
+		return first, last, errorz.ErrInvalidStep
+	}
+	return first, last, nil
+}
+
+func emptyPoints(first, last time.Time, step time.Duration) []SeriesPoint {
+	points := make([]SeriesPoint, bucketIndex(first, last, step)+1)
+	for i := range points {
+		points[i].Time = first.Add(time.Duration(i) * step).UTC()
+	}
+	return points
+}
+
+func bucketIndex(first, bucket time.Time, step time.Duration) int {
+	return int(bucket.Sub(first) / step)
+}
+
+func point(row seriesRow, step time.Duration) SeriesPoint {
+	p := SeriesPoint{
+		Time:              row.Bucket.UTC(),
+		Requests:          row.Requests,
+		Errors:            row.Errors,
+		RequestsPerSecond: math.Round(float64(row.Requests)/step.Seconds()*1e4) / 1e4,
+		LatencyP95Ms:      row.LatencyP95Ms,
+	}
+	if row.Requests > 0 {
+		p.ErrorRate = math.Round(float64(row.Errors)/float64(row.Requests)*1e4) / 1e4
+	}
+	return p
+}
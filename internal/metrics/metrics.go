@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// Registry holds the server's own metrics (HTTP and ClickHouse queries).
+var Registry = prometheus.NewRegistry()
+
+var HTTPRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "otelmap_http_requests_total",
+	Help: "Total number of HTTP requests handled by the server.",
+}, []string{"method", "route", "status"})
+
+var HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "otelmap_http_request_duration_seconds",
+	Help:    "Duration of HTTP requests handled by the server.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"method", "route"})
+
+var QueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "otelmap_query_duration_seconds",
+	Help:    "Duration of ClickHouse queries issued by the server.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"query"})
+
+var QueryErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "otelmap_query_errors_total",
+	Help: "Total number of failed ClickHouse queries issued by the server.",
+}, []string{"query"})
+
+var MapCacheRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "otelmap_map_cache_requests_total",
+	Help: "Total number of map queries served through the cache, by result: hit, miss or shared with a concurrent query.",
+}, []string{"query", "result"})
+
+// Map cache results.
+const (
+	CacheHit    = "hit"
+	CacheMiss   = "miss"
+	CacheShared = "shared"
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequestsTotal,
+		HTTPRequestDuration,
+		QueryDuration,
+		QueryErrorsTotal,
+		MapCacheRequestsTotal,
+	)
+}
+
+// ObserveQuery records the duration and outcome of a named query started at start.
+func ObserveQuery(query string, start time.Time, err error) {
+	QueryDuration.WithLabelValues(query).Observe(time.Since(start).Seconds())
+	if err != nil {
+		QueryErrorsTotal.WithLabelValues(query).Inc()
+	}
+}
+
+// ObserveCache records how the cache served a named map query.
+func ObserveCache(query, result string) {
+	MapCacheRequestsTotal.WithLabelValues(query, result).Inc()
+}
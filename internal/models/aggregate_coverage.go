@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// AggregateCoverage records which spans a pre-aggregated stats table holds:
+// those inserted from CoveredFrom on and, for tables rolled up per minute,
+// those that started before Watermark. Rows are append-only; the latest
+// UpdatedAt per name wins.
+type AggregateCoverage struct {
+	Name        string    `gorm:"type:String" json:"name"`
+	CoveredFrom time.Time `gorm:"type:DateTime" json:"covered_from"`
+	Watermark   time.Time `gorm:"type:DateTime" json:"watermark"`
+	UpdatedAt   time.Time `gorm:"type:DateTime" json:"updated_at"`
+}
+
+func (AggregateCoverage) TableName() string { return "aggregate_coverage" }
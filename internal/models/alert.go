@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AlertRule is an alerting rule of a session. Rows are append-only; the
+// latest UpdatedAt per ID wins and Deleted marks removed rules. Receivers is
+// the JSON encoded list of notification targets.
+type AlertRule struct {
+	ID          uuid.UUID `gorm:"type:UUID" json:"id"`
+	Token       uuid.UUID `gorm:"type:UUID" json:"token"`
+	Name        string    `gorm:"type:String" json:"name"`
+	Kind        string    `gorm:"type:String" json:"kind"`
+	Scope       string    `gorm:"type:String" json:"scope"`
+	Target      string    `gorm:"type:String" json:"target"`
+	Quantile    string    `gorm:"type:String" json:"quantile"`
+	Threshold   float64   `gorm:"type:Float64" json:"threshold"`
+	MinRequests uint64    `gorm:"type:UInt64" json:"min_requests"`
+	ForSeconds  int64     `gorm:"type:Int64" json:"for_seconds"`
+	Receivers   string    `gorm:"type:String" json:"receivers"`
+	Deleted     bool      `gorm:"type:Bool" json:"deleted"`
+	UpdatedAt   time.Time `gorm:"type:DateTime" json:"updated_at"`
+}
+
+func (AlertRule) TableName() string { return "alert_rules" }
+
+// AlertState is the state of one alert instance, a rule applied to one
+// service or edge. Rows are append-only; the latest UpdatedAt per instance
+// wins.
+type AlertState struct {
+	Token         uuid.UUID `gorm:"type:UUID" json:"token"`
+	RuleID        uuid.UUID `gorm:"type:UUID" json:"rule_id"`
+	Instance      string    `gorm:"type:String" json:"instance"`
+	State         string    `gorm:"type:String" json:"state"`
+	Value         float64   `gorm:"type:Float64" json:"value"`
+	Baseline      float64   `gorm:"type:Float64" json:"baseline"`
+	StartedAt     time.Time `gorm:"type:DateTime" json:"started_at"`
+	FiredAt       time.Time `gorm:"type:DateTime" json:"fired_at"`
+	ResolvedAt    time.Time `gorm:"type:DateTime" json:"resolved_at"`
+	NotifiedAt    time.Time `gorm:"type:DateTime" json:"notified_at"`
+	NotifiedState string    `gorm:"type:String" json:"notified_state"`
+	UpdatedAt     time.Time `gorm:"type:DateTime64(3)" json:"updated_at"`
+}
+
+func (AlertState) TableName() string { return "alert_states" }
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Annotation is a marker posted against a session, such as a deploy or an
+// incident. Rows are append-only; the latest UpdatedAt per ID wins and
+// Deleted marks removed annotations.
+type Annotation struct {
+	ID          uuid.UUID `gorm:"type:UUID" json:"id"`
+	Token       uuid.UUID `gorm:"type:UUID" json:"token"`
+	Kind        string    `gorm:"type:String" json:"kind"`
+	ServiceName string    `gorm:"type:String" json:"service_name"`
+	Version     string    `gorm:"type:String" json:"version"`
+	Time        time.Time `gorm:"type:DateTime64(3)" json:"time"`
+	Text        string    `gorm:"type:String" json:"text"`
+	Deleted     bool      `gorm:"type:Bool" json:"deleted"`
+	UpdatedAt   time.Time `gorm:"type:DateTime" json:"updated_at"`
+}
+
+func (Annotation) TableName() string { return "annotations" }
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DataSource selects where a session's map metrics are read from. Rows are
+// append-only; the latest UpdatedAt per token wins.
+type DataSource struct {
+	Token     uuid.UUID `gorm:"type:UUID" json:"token"`
+	Source    string    `gorm:"type:String" json:"source"`
+	UpdatedAt time.Time `gorm:"type:DateTime" json:"updated_at"`
+}
+
+func (DataSource) TableName() string { return "data_sources" }
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrorPolicy configures which spans of a session count as errors besides
+// those with an error status. Rows are append-only; the latest UpdatedAt per
+// token wins.
+type ErrorPolicy struct {
+	Token            uuid.UUID `gorm:"type:UUID" json:"token"`
+	HTTPServerErrors bool      `gorm:"type:Bool" json:"http_server_errors"`
+	GRPCErrors       bool      `gorm:"type:Bool" json:"grpc_errors"`
+	ExceptionEvents  bool      `gorm:"type:Bool" json:"exception_events"`
+	UpdatedAt        time.Time `gorm:"type:DateTime" json:"updated_at"`
+}
+
+func (ErrorPolicy) TableName() string { return "error_policies" }
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// NodePosition is the last known layout position of a service map node. Rows
+// are append-only; the latest UpdatedAt per node wins.
+type NodePosition struct {
+	Token     uuid.UUID `gorm:"type:UUID" json:"token"`
+	Algorithm string    `gorm:"type:String" json:"algorithm"`
+	Node      string    `gorm:"type:String" json:"node"`
+	X         float64   `gorm:"type:Float64" json:"x"`
+	Y         float64   `gorm:"type:Float64" json:"y"`
+	UpdatedAt time.Time `gorm:"type:DateTime" json:"updated_at"`
+}
+
+func (NodePosition) TableName() string { return "node_positions" }
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SLO is a service level objective of a session. Rows are append-only; the
+// latest UpdatedAt per ID wins and Deleted marks removed objectives.
+type SLO struct {
+	ID                 uuid.UUID `gorm:"type:UUID" json:"id"`
+	Token              uuid.UUID `gorm:"type:UUID" json:"token"`
+	ServiceName        string    `gorm:"type:String" json:"service_name"`
+	Operation          string    `gorm:"type:String" json:"operation"`
+	AvailabilityTarget float64   `gorm:"type:Float64" json:"availability_target"`
+	LatencyThresholdMs float64   `gorm:"type:Float64" json:"latency_threshold_ms"`
+	LatencyTarget      float64   `gorm:"type:Float64" json:"latency_target"`
+	Deleted            bool      `gorm:"type:Bool" json:"deleted"`
+	UpdatedAt          time.Time `gorm:"type:DateTime" json:"updated_at"`
+}
+
+func (SLO) TableName() string { return "slos" }
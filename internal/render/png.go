@@ -0,0 +1,113 @@
+package render
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+
+	errorz "github.com/jack5341/otel-map-server/internal/errors"
+	"github.com/jack5341/otel-map-server/internal/layout"
+	mapz "github.com/jack5341/otel-map-server/internal/mapz"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// PNG rasterises the same drawing as SVG. Canvases wider or higher than
+// MaxCanvasSide are refused with ErrImageTooLarge.
+func PNG(services []mapz.Service, edges []mapz.Edge, l layout.Layout) ([]byte, error) {
+	if l.Width > MaxCanvasSide || l.Height > MaxCanvasSide {
+		return nil, errorz.ErrImageTooLarge
+	}
+	s := buildScene(services, edges, l)
+
+	img := image.NewRGBA(image.Rect(0, 0, int(math.Ceil(s.width)), int(math.Ceil(s.height))))
+	draw.Draw(img, img.Bounds(), &image.Uniform{C: backgroundColor}, image.Point{}, draw.Src)
+
+	for _, e := range s.edges {
+		for i := 1; i < len(e.points); i++ {
+			drawLine(img, e.points[i-1], e.points[i], e.width, edgeColor)
+		}
+		fillTriangle(img, arrowHead(e.points, e.width), edgeColor)
+	}
+
+	for _, n := range s.nodes {
+		rect := image.Rect(
+			int(n.center.X-nodeWidth/2), int(n.center.Y-nodeHeight/2),
+			int(n.center.X+nodeWidth/2), int(n.center.Y+nodeHeight/2),
+		)
+		draw.Draw(img, rect, &image.Uniform{C: n.palette.stroke}, image.Point{}, draw.Src)
+		draw.Draw(img, rect.Inset(2), &image.Uniform{C: n.palette.fill}, image.Point{}, draw.Src)
+
+		titleY := n.center.Y + 4
+		if n.details != "" {
+			titleY = n.center.Y - 4
+		}
+		drawText(img, truncate(n.title, 22), n.center.X, titleY)
+		if n.details != "" {
+			drawText(img, n.details, n.center.X, n.center.Y+14)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// drawLine stamps discs of the given width along the segment.
+func drawLine(img *image.RGBA, from, to layout.Point, width float64, c color.RGBA) {
+	length := math.Hypot(to.X-from.X, to.Y-from.Y)
+	steps := int(math.Max(length*2, 1))
+	r := width / 2
+	for i := 0; i <= steps; i++ {
+		t := float64(i) / float64(steps)
+		x := from.X + (to.X-from.X)*t
+		y := from.Y + (to.Y-from.Y)*t
+		for dy := -r; dy <= r; dy++ {
+			for dx := -r; dx <= r; dx++ {
+				if dx*dx+dy*dy <= r*r+0.25 {
+					img.SetRGBA(int(math.Round(x+dx)), int(math.Round(y+dy)), c)
+				}
+			}
+		}
+	}
+}
+
+func fillTriangle(img *image.RGBA, t [3]layout.Point, c color.RGBA) {
+	minX := math.Floor(math.Min(t[0].X, math.Min(t[1].X, t[2].X)))
+	maxX := math.Ceil(math.Max(t[0].X, math.Max(t[1].X, t[2].X)))
+	minY := math.Floor(math.Min(t[0].Y, math.Min(t[1].Y, t[2].Y)))
+	maxY := math.Ceil(math.Max(t[0].Y, math.Max(t[1].Y, t[2].Y)))
+
+	sign := func(p, a, b layout.Point) float64 {
+		return (p.X-b.X)*(a.Y-b.Y) - (a.X-b.X)*(p.Y-b.Y)
+	}
+	for y := minY; y <= maxY; y++ {
+		for x := minX; x <= maxX; x++ {
+			p := layout.Point{X: x, Y: y}
+			d1, d2, d3 := sign(p, t[0], t[1]), sign(p, t[1], t[2]), sign(p, t[2], t[0])
+			neg := d1 < 0 || d2 < 0 || d3 < 0
+			pos := d1 > 0 || d2 > 0 || d3 > 0
+			if !(neg && pos) {
+				img.SetRGBA(int(x), int(y), c)
+			}
+		}
+	}
+}
+
+// drawText draws s centred horizontally on x with its baseline at y.
+func drawText(img *image.RGBA, s string, x, y float64) {
+	face := basicfont.Face7x13
+	d := &font.Drawer{Dst: img, Src: &image.Uniform{C: textColor}, Face: face}
+	width := d.MeasureString(s)
+	d.Dot = fixed.Point26_6{
+		X: fixed.I(int(x)) - width/2,
+		Y: fixed.I(int(y)),
+	}
+	d.DrawString(s)
+}
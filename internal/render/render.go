@@ -0,0 +1,191 @@
+package render
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"sort"
+
+	"github.com/jack5341/otel-map-server/internal/layout"
+	mapz "github.com/jack5341/otel-map-server/internal/mapz"
+)
+
+const (
+	nodeWidth  = 170.0
+	nodeHeight = 56.0
+	arrowSize  = 10.0
+)
+
+// MaxNodes bounds the nodes of a rendered map: laying it out costs time
+// quadratic in the nodes. MaxCanvasSide bounds each side of a PNG in pixels,
+// so one image stays under 256MB.
+const (
+	MaxNodes      = 300
+	MaxCanvasSide = 8192
+)
+
+// Error rate thresholds used for node health colours.
+const (
+	warnErrorRate     = 0.01
+	criticalErrorRate = 0.05
+)
+
+type palette struct {
+	fill, stroke color.RGBA
+}
+
+var (
+	healthyPalette  = palette{fill: color.RGBA{0xe8, 0xf5, 0xe9, 0xff}, stroke: color.RGBA{0x2e, 0x7d, 0x32, 0xff}}
+	warnPalette     = palette{fill: color.RGBA{0xff, 0xf8, 0xe1, 0xff}, stroke: color.RGBA{0xf9, 0xa8, 0x25, 0xff}}
+	criticalPalette = palette{fill: color.RGBA{0xff, 0xeb, 0xee, 0xff}, stroke: color.RGBA{0xc6, 0x28, 0x28, 0xff}}
+	unknownPalette  = palette{fill: color.RGBA{0xf5, 0xf5, 0xf5, 0xff}, stroke: color.RGBA{0x75, 0x75, 0x75, 0xff}}
+	edgeColor       = color.RGBA{0x60, 0x7d, 0x8b, 0xff}
+	textColor       = color.RGBA{0x21, 0x21, 0x21, 0xff}
+	backgroundColor = color.RGBA{0xff, 0xff, 0xff, 0xff}
+)
+
+type sceneNode struct {
+	center  layout.Point
+	title   string
+	details string
+	palette palette
+}
+
+type sceneEdge struct {
+	points []layout.Point
+	width  float64
+	self   bool
+}
+
+// scene is the resolution-independent drawing shared by the SVG and PNG
+// renderers.
+type scene struct {
+	width, height float64
+	nodes         []sceneNode
+	edges         []sceneEdge
+}
+
+func buildScene(services []mapz.Service, edges []mapz.Edge, l layout.Layout) scene {
+	s := scene{width: l.Width, height: l.Height}
+
+	byName := make(map[string]mapz.Service, len(services))
+	for _, svc := range services {
+		byName[svc.NodeID()] = svc
+	}
+
+	// Parallel edges (one per target path) are drawn once per service pair.
+	type pair struct{ source, target string }
+	rps := make(map[pair]float64)
+	routes := make(map[pair][]layout.Point)
+	var order []pair
+	for i, e := range edges {
+		p := pair{e.SourceNodeID(), e.TargetNodeID()}
+		if _, ok := routes[p]; !ok {
+			order = append(order, p)
+			routes[p] = nil
+			if i < len(l.Edges) {
+				routes[p] = l.Edges[i].Points
+			}
+		}
+		rps[p] += e.RequestsPerSecond
+	}
+
+	maxRPS := 0.0
+	for _, v := range rps {
+		maxRPS = math.Max(maxRPS, v)
+	}
+	for _, p := range order {
+		points := routes[p]
+		if len(points) < 2 {
+			continue
+		}
+		edge := sceneEdge{width: edgeWidth(rps[p], maxRPS), self: p.source == p.target}
+		if edge.self {
+			edge.points = selfLoop(points[0])
+		} else {
+			edge.points = append([]layout.Point(nil), points...)
+			edge.points[len(edge.points)-1] = clipToNode(edge.points[len(edge.points)-2], edge.points[len(edge.points)-1])
+		}
+		s.edges = append(s.edges, edge)
+	}
+
+	ids := make([]string, 0, len(l.Nodes))
+	for id := range l.Nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		node := sceneNode{center: l.Nodes[id], title: id, palette: unknownPalette}
+		if svc, ok := byName[id]; ok {
+			node.palette = healthPalette(svc.ErrorRate)
+			node.details = fmt.Sprintf("err %.1f%% | p95 %.0fms", svc.ErrorRate*100, svc.LatencyP95Ms)
+		}
+		s.nodes = append(s.nodes, node)
+	}
+
+	return s
+}
+
+func healthPalette(errorRate float64) palette {
+	switch {
+	case errorRate >= criticalErrorRate:
+		return criticalPalette
+	case errorRate >= warnErrorRate:
+		return warnPalette
+	default:
+		return healthyPalette
+	}
+}
+
+// edgeWidth scales logarithmically with RPS between 1 and 8 pixels.
+func edgeWidth(rps, maxRPS float64) float64 {
+	if maxRPS <= 0 || rps <= 0 {
+		return 1
+	}
+	return 1 + 7*math.Log1p(rps)/math.Log1p(maxRPS)
+}
+
+// clipToNode moves the end of the segment from -> to back onto the border of
+// the node box centred at to, so arrow heads stay visible.
+func clipToNode(from, to layout.Point) layout.Point {
+	dx, dy := from.X-to.X, from.Y-to.Y
+	if dx == 0 && dy == 0 {
+		return to
+	}
+	scale := math.Min(
+		(nodeWidth/2)/math.Max(math.Abs(dx), 1e-9),
+		(nodeHeight/2)/math.Max(math.Abs(dy), 1e-9),
+	)
+	return layout.Point{X: to.X + dx*scale, Y: to.Y + dy*scale}
+}
+
+// selfLoop flattens a cubic curve leaving and re-entering the top of a node.
+func selfLoop(c layout.Point) []layout.Point {
+	p0 := layout.Point{X: c.X + nodeWidth/4, Y: c.Y - nodeHeight/2}
+	p1 := layout.Point{X: c.X + nodeWidth/4 + 20, Y: c.Y - nodeHeight/2 - 50}
+	p2 := layout.Point{X: c.X + nodeWidth/2 + 30, Y: c.Y - nodeHeight/2 - 10}
+	p3 := layout.Point{X: c.X + nodeWidth/2, Y: c.Y - nodeHeight/4}
+
+	const steps = 16
+	points := make([]layout.Point, 0, steps+1)
+	for i := 0; i <= steps; i++ {
+		t := float64(i) / steps
+		u := 1 - t
+		points = append(points, layout.Point{
+			X: u*u*u*p0.X + 3*u*u*t*p1.X + 3*u*t*t*p2.X + t*t*t*p3.X,
+			Y: u*u*u*p0.Y + 3*u*u*t*p1.Y + 3*u*t*t*p2.Y + t*t*t*p3.Y,
+		})
+	}
+	return points
+}
+
+// arrowHead returns the three corners of the arrow at the end of points.
+func arrowHead(points []layout.Point, width float64) [3]layout.Point {
+	tip := points[len(points)-1]
+	from := points[len(points)-2]
+	angle := math.Atan2(tip.Y-from.Y, tip.X-from.X)
+	size := arrowSize + width
+	left := layout.Point{X: tip.X - size*math.Cos(angle-math.Pi/7), Y: tip.Y - size*math.Sin(angle-math.Pi/7)}
+	right := layout.Point{X: tip.X - size*math.Cos(angle+math.Pi/7), Y: tip.Y - size*math.Sin(angle+math.Pi/7)}
+	return [3]layout.Point{tip, left, right}
+}
@@ -0,0 +1,71 @@
+package render
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image/color"
+	"strings"
+
+	"github.com/jack5341/otel-map-server/internal/layout"
+	mapz "github.com/jack5341/otel-map-server/internal/mapz"
+)
+
+// SVG draws the service map on the positions of l. Node colours reflect the
+// error rate and edge thickness the requests per second.
+func SVG(services []mapz.Service, edges []mapz.Edge, l layout.Layout) []byte {
+	s := buildScene(services, edges, l)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%.0f" height="%.0f" viewBox="0 0 %.0f %.0f" font-family="Helvetica, Arial, sans-serif">`+"\n",
+		s.width, s.height, s.width, s.height)
+	fmt.Fprintf(&b, `  <rect width="100%%" height="100%%" fill="%s"/>`+"\n", hex(backgroundColor))
+
+	for _, e := range s.edges {
+		coords := make([]string, len(e.points))
+		for i, p := range e.points {
+			coords[i] = fmt.Sprintf("%.1f,%.1f", p.X, p.Y)
+		}
+		fmt.Fprintf(&b, `  <polyline points="%s" fill="none" stroke="%s" stroke-width="%.1f" stroke-linejoin="round" stroke-opacity="0.8"/>`+"\n",
+			strings.Join(coords, " "), hex(edgeColor), e.width)
+		head := arrowHead(e.points, e.width)
+		fmt.Fprintf(&b, `  <polygon points="%.1f,%.1f %.1f,%.1f %.1f,%.1f" fill="%s"/>`+"\n",
+			head[0].X, head[0].Y, head[1].X, head[1].Y, head[2].X, head[2].Y, hex(edgeColor))
+	}
+
+	for _, n := range s.nodes {
+		fmt.Fprintf(&b, `  <rect x="%.1f" y="%.1f" width="%.0f" height="%.0f" rx="8" fill="%s" stroke="%s" stroke-width="2"/>`+"\n",
+			n.center.X-nodeWidth/2, n.center.Y-nodeHeight/2, nodeWidth, nodeHeight, hex(n.palette.fill), hex(n.palette.stroke))
+		titleY := n.center.Y + 5
+		if n.details != "" {
+			titleY = n.center.Y - 4
+		}
+		fmt.Fprintf(&b, `  <text x="%.1f" y="%.1f" text-anchor="middle" font-size="13" font-weight="bold" fill="%s">%s</text>`+"\n",
+			n.center.X, titleY, hex(textColor), escape(truncate(n.title, 22)))
+		if n.details != "" {
+			fmt.Fprintf(&b, `  <text x="%.1f" y="%.1f" text-anchor="middle" font-size="11" fill="%s">%s</text>`+"\n",
+				n.center.X, n.center.Y+14, hex(textColor), escape(n.details))
+		}
+	}
+
+	b.WriteString("</svg>\n")
+	return b.Bytes()
+}
+
+func hex(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func truncate(s string, max int) string {
+	r := []rune(s)
+	if len(r) <= max {
+		return s
+	}
+	return string(r[:max-3]) + "..."
+}
@@ -0,0 +1,77 @@
+package rollup
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jack5341/otel-map-server/internal/config"
+	mapz "github.com/jack5341/otel-map-server/internal/mapz"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// maxMinutesPerRun bounds how many minutes one run rolls up, so catching up
+// after downtime does not hold a connection for long.
+const maxMinutesPerRun = 60
+
+// Roller rolls the edges of each closed minute up into the edge stats table
+// and advances its watermark. A minute is rolled up once it is older than
+// the delay, which leaves the collector time to export its spans; spans
+// inserted later are only counted by requests reading raw spans.
+type Roller struct {
+	db         *gorm.DB
+	otelTracer trace.Tracer
+	interval   time.Duration
+	delay      time.Duration
+}
+
+func NewRoller(db *gorm.DB, otelTracer trace.Tracer, cfg *config.Config) *Roller {
+	return &Roller{
+		db:         db,
+		otelTracer: otelTracer,
+		interval:   cfg.RollupInterval,
+		delay:      cfg.RollupDelay,
+	}
+}
+
+// Run rolls up the closed minutes every interval until ctx is done.
+func (r *Roller) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.RollUp(ctx); err != nil {
+				log.Printf("rollup: %v", err)
+			}
+		}
+	}
+}
+
+// RollUp rolls up the minutes between the watermark and the delay.
+func (r *Roller) RollUp(ctx context.Context) error {
+	ctx, span := r.otelTracer.Start(ctx, "Roller.RollUp")
+	defer span.End()
+
+	mapper := mapz.NewMapper(r.db, r.otelTracer, ctx)
+	coverage, ok, err := mapper.GetAggregateCoverage(mapz.EdgeStatsTable)
+	if err != nil || !ok {
+		return err
+	}
+
+	until := time.Now().UTC().Add(-r.delay).Truncate(time.Minute)
+	for i := 0; i < maxMinutesPerRun && coverage.Watermark.Before(until); i++ {
+		if err := mapper.RollUpEdges(coverage.Watermark); err != nil {
+			return err
+		}
+		coverage.Watermark = coverage.Watermark.Add(time.Minute)
+		if err := mapper.SaveAggregateCoverage(coverage); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,3 +0,0 @@
--- Ensure the OpenTelemetry database exists for the collector exporter
-CREATE DATABASE IF NOT EXISTS otel;
-CREATE DATABASE IF NOT EXISTS session_tokens;
\ No newline at end of file